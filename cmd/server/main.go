@@ -10,53 +10,183 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/AadityaChoubey68/clevr-live/internal/acl"
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
 	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
 	"github.com/AadityaChoubey68/clevr-live/internal/config"
 	"github.com/AadityaChoubey68/clevr-live/internal/core"
 	"github.com/AadityaChoubey68/clevr-live/internal/handlers"
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
+	"github.com/AadityaChoubey68/clevr-live/internal/quota"
+	"github.com/AadityaChoubey68/clevr-live/internal/spill"
 	"github.com/AadityaChoubey68/clevr-live/internal/throttle"
+	"github.com/AadityaChoubey68/clevr-live/internal/wal"
 )
 
 func main() {
 	config := config.LoadConfig()
 	log.Printf("Configuration loaded: MaxMemory=%dMB", config.MaxMemory/(1024*1024))
 
-	bufferManager := buffer.NewAdaptiveBufferManager(config.MaxMemory)
+	sampler := load.NewProcessSampler(config.MaxMemory, time.Second)
+	sampler.Start()
+	log.Printf("Load sampler started: %s", sampler.Sample())
+
+	bufferBudget := buffer.ByteBudget{
+		Total:         config.BufferMemory,
+		PerSubscriber: config.SubscriberBufferBytes,
+	}
+	bufferManager := buffer.NewAdaptiveBufferManager(config.MaxMemory, bufferBudget, sampler)
 	bufferManager.Start()
-	log.Println("Buffer manager started")
+	log.Printf("Buffer manager started: %dMB for subscriber buffers, at most %dMB each",
+		bufferBudget.Total/(1024*1024), bufferBudget.PerSubscriber/(1024*1024))
 
 	throttlerConfig := throttle.DefaultConfig()
-	adaptiveThrottler := throttle.NewAdaptiveThrottler(throttlerConfig)
-	log.Println("Adaptive throttler initialized")
+	adaptiveThrottler := throttle.NewAdaptiveThrottler(throttlerConfig, sampler)
+	adaptiveThrottler.Start()
+	log.Println("Adaptive throttler started")
+
+	topicManagerConfig := core.DefaultTopicManagerConfig()
+	topicManagerConfig.CacheSize = config.CacheSize
+	topicManagerConfig.RingSize = config.RingSize
+	topicManagerConfig.DeadLetterTopics = config.DeadLetterTopics
+
+	var writeAheadLog *wal.Log
+	if config.WALDir != "" {
+		fsync, err := wal.ParseFsyncPolicy(config.WALFsync)
+		if err != nil {
+			log.Fatalf("Invalid WAL_FSYNC: %v", err)
+		}
+
+		writeAheadLog, err = wal.Open(wal.Config{
+			Dir:           config.WALDir,
+			Fsync:         fsync,
+			FsyncInterval: config.WALFsyncInterval,
+			SegmentBytes:  config.WALSegmentBytes,
 
-	topicManager := core.NewTopicManager(bufferManager, adaptiveThrottler)
+			RetentionBytes: config.WALRetention,
+			RetentionAge:   config.WALRetentionAge,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log: %v", err)
+		}
+		topicManagerConfig.Log = writeAheadLog
+		log.Printf("Write-ahead log opened at %s (fsync=%s)", config.WALDir, fsync)
+	}
+
+	if config.SpillDir != "" {
+		fallback, err := core.ParseSpillFallback(config.SpillFallback)
+		if err != nil {
+			log.Fatalf("Invalid SPILL_FALLBACK: %v", err)
+		}
+
+		spillManager, err := spill.Open(spill.Config{
+			Dir:           config.SpillDir,
+			MaxBytes:      config.SpillMaxBytes,
+			QueueMaxBytes: config.SpillQueueMaxBytes,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open spill directory: %v", err)
+		}
+		topicManagerConfig.Spill = spillManager
+		topicManagerConfig.SpillFallback = fallback
+		log.Printf("Spill to disk enabled at %s (%dMB total, %dMB per subscriber)",
+			config.SpillDir, config.SpillMaxBytes/(1024*1024), config.SpillQueueMaxBytes/(1024*1024))
+	}
+
+	if config.ACLFile != "" {
+		aclStore, err := acl.Load(config.ACLFile)
+		if err != nil {
+			log.Fatalf("Failed to load ACL: %v", err)
+		}
+		topicManagerConfig.ACL = aclStore
+		log.Printf("Loaded %d ACL rules from %s (SIGHUP reloads)", aclStore.RuleCount(), config.ACLFile)
+	}
+
+	if config.QuotaFile != "" {
+		quotas, err := quota.Load(config.QuotaFile)
+		if err != nil {
+			log.Fatalf("Failed to load quotas: %v", err)
+		}
+		topicManagerConfig.Quotas = quotas
+		log.Printf("Loaded tenant quotas from %s", config.QuotaFile)
+	}
+
+	topicManager := core.NewTopicManager(bufferManager, adaptiveThrottler, topicManagerConfig)
+	if err := topicManager.Recover(); err != nil {
+		log.Fatalf("Failed to recover topics from write-ahead log: %v", err)
+	}
 	log.Println("Topic manager started")
 
-	publishHandler := handlers.NewPublishHandler(topicManager, adaptiveThrottler)
-	subscribeHandler := handlers.NewSubscribeHandler(topicManager, bufferManager)
+	if topicManagerConfig.ACL != nil {
+		go reloadOnHangup(topicManagerConfig.ACL, topicManager)
+	}
+
+	authenticator, err := buildAuthenticator(config)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+	authMiddleware := auth.NewMiddleware(authenticator)
+	if authMiddleware.Enabled() {
+		log.Printf("Authentication enabled (%s)", authenticator.Name())
+	} else {
+		log.Printf("Authentication disabled: all requests use tenant %s", auth.DefaultTenant)
+	}
+
+	publishHandler := handlers.NewPublishHandler(topicManager)
+	subscribeHandler := handlers.NewSubscribeHandler(topicManager, bufferManager, authMiddleware)
+	sseHandler := handlers.NewSSEHandler(topicManager, bufferManager)
+	streamHandler := handlers.NewStreamHandler(topicManager, bufferManager)
+	pollHandler := handlers.NewPollHandler(topicManager, bufferManager)
 	healthHandler := handlers.NewHealthHandler(topicManager)
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/publish", publishHandler.ServeHTTP)
+	mux.Handle("/publish", authMiddleware.Require(publishHandler))
+
+	mux.Handle("POST /topics/{topic}/messages", authMiddleware.Require(http.HandlerFunc(publishHandler.ServeRaw)))
+
+	mux.Handle("/subscribe", authMiddleware.Deferred(subscribeHandler))
+
+	mux.Handle("/ws", authMiddleware.Deferred(subscribeHandler))
 
-	mux.HandleFunc("/subscribe", subscribeHandler.ServeHTTP)
+	mux.Handle("/sse", authMiddleware.Require(sseHandler))
+
+	mux.Handle("/stream", authMiddleware.Require(streamHandler))
+
+	mux.Handle("/poll", authMiddleware.Require(pollHandler))
 
 	mux.HandleFunc("/health", healthHandler.ServeHTTP)
 
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/metrics", authMiddleware.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+
+		// With authentication on, tenants only see their own topics and
+		// usage; the server-wide view is for admins.
+		identity := auth.Current(r.Context())
+		if authMiddleware.Enabled() && !identity.HasRole(auth.AdminRole) {
+			fmt.Fprintf(w, "%v", topicManager.GetTenantMetrics(identity.TenantID))
+			return
+		}
+
 		metrics := topicManager.GetMetrics()
+		metrics["auth_metrics"] = authMiddleware.GetMetrics()
+		metrics["load_metrics"] = sampler.GetMetrics()
+		metrics["buffer_metrics"] = bufferManager.GetMetrics()
 
 		fmt.Fprintf(w, "%v", metrics)
-	})
+	})))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
 		fmt.Fprintf(w, "ClevrLive event Streaming system\n\n")
 		fmt.Fprintf(w, "Endpoints:\n")
 		fmt.Fprintf(w, "  POST /publish          - Publish a message\n")
+		fmt.Fprintf(w, "  POST /topics/{topic}/messages - Publish the body, of any Content-Type\n")
 		fmt.Fprintf(w, "  WS   /subscribe?topic= - Subscribe to a topic\n")
+		fmt.Fprintf(w, "  WS   /ws               - Session: subscribe and publish frames\n")
+		fmt.Fprintf(w, "  GET  /sse?topic=       - Server-Sent Events stream\n")
+		fmt.Fprintf(w, "  GET  /stream?topic=    - Newline-delimited JSON stream\n")
+		fmt.Fprintf(w, "  GET  /poll?topic=&after= - Long-poll for a batch of messages\n")
 		fmt.Fprintf(w, "  GET  /health           - Health check\n")
 		fmt.Fprintf(w, "  GET  /metrics          - System metrics\n")
 	})
@@ -94,7 +224,77 @@ func main() {
 
 	topicManager.ShutDown()
 
+	if writeAheadLog != nil {
+		if err := writeAheadLog.Close(); err != nil {
+			log.Printf("Write-ahead log close error: %v", err)
+		}
+	}
+
+	adaptiveThrottler.Stop()
 	bufferManager.Stop()
+	sampler.Stop()
 
 	log.Println("Server stopped gracefully")
 }
+
+// reloadOnHangup re-reads the ACL file on every SIGHUP and closes the live
+// subscriptions the new rules deny. A file that fails to parse leaves the
+// previous rules in force.
+func reloadOnHangup(aclStore *acl.Store, topicManager *core.TopicManager) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		if err := aclStore.Reload(); err != nil {
+			log.Printf("ACL reload failed, keeping previous rules: %v", err)
+			continue
+		}
+		revoked := topicManager.ReauthorizeSubscribers()
+		log.Printf("ACL reloaded: %d rules, %d subscriptions closed", aclStore.RuleCount(), revoked)
+	}
+}
+
+// buildAuthenticator chains every authenticator that has been configured.
+// It returns nil, disabling authentication, when none is.
+func buildAuthenticator(config config.Config) (auth.Authenticator, error) {
+	var chain auth.Chain
+
+	if config.AuthAPIKeysFile != "" {
+		keys, err := auth.LoadAPIKeys(config.AuthAPIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d API keys from %s", keys.Count(), config.AuthAPIKeysFile)
+		chain = append(chain, keys)
+	}
+
+	if config.AuthHMACSecret != "" {
+		chain = append(chain, auth.NewHMACAuthenticator([]byte(config.AuthHMACSecret)))
+	}
+
+	if config.AuthJWTSecret != "" || config.AuthJWTPublicKey != "" {
+		jwtConfig := auth.JWTConfig{
+			HS256Secret: []byte(config.AuthJWTSecret),
+			Issuer:      config.AuthJWTIssuer,
+			Audience:    config.AuthJWTAudience,
+		}
+		if config.AuthJWTPublicKey != "" {
+			key, err := auth.LoadRSAPublicKey(config.AuthJWTPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			jwtConfig.RS256Key = key
+		}
+
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(jwtConfig)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwtAuthenticator)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
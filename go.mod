@@ -5,6 +5,6 @@ go 1.23
 toolchain go1.24.3
 
 require (
-	github.com/coder/websocket v1.8.14 // indirect
-	github.com/google/uuid v1.6.0 // indirect
+	github.com/coder/websocket v1.8.14
+	github.com/google/uuid v1.6.0
 )
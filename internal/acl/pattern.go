@@ -0,0 +1,64 @@
+package acl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Patterns use the same syntax as wildcard subscriptions: levels separated
+// by ".", "*" matching one level and ">" (or "#") as the last level matching
+// one or more.
+
+func parsePattern(pattern string) ([]string, error) {
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		switch token {
+		case "":
+			return nil, fmt.Errorf("invalid pattern %q: empty level", pattern)
+		case "#", ">":
+			if i != len(tokens)-1 {
+				return nil, fmt.Errorf("invalid pattern %q: %s must be the last level", pattern, token)
+			}
+			tokens[i] = ">"
+		}
+	}
+	return tokens, nil
+}
+
+// covers reports whether every topic matched by target is also matched by
+// rule. For a concrete topic this is an ordinary match.
+func covers(rule, target []string) bool {
+	for i, token := range rule {
+		if token == ">" {
+			return len(target) > i
+		}
+		if i >= len(target) || target[i] == ">" {
+			return false
+		}
+		if token == "*" {
+			continue
+		}
+		if target[i] == "*" || target[i] != token {
+			return false
+		}
+	}
+	return len(target) == len(rule)
+}
+
+// intersects reports whether some topic is matched by both patterns.
+func intersects(a, b []string) bool {
+	for i := 0; ; i++ {
+		if i == len(a) || i == len(b) {
+			return len(a) == len(b)
+		}
+		if a[i] == ">" || b[i] == ">" {
+			return true
+		}
+		if a[i] == "*" || b[i] == "*" {
+			continue
+		}
+		if a[i] != b[i] {
+			return false
+		}
+	}
+}
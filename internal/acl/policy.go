@@ -0,0 +1,160 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+)
+
+type Action string
+
+const (
+	Publish   Action = "publish"
+	Subscribe Action = "subscribe"
+)
+
+// Rule grants or denies an action on the topics matching Pattern. Tenant,
+// Principal and Role narrow who it applies to; empty means anyone.
+type Rule struct {
+	Allow     bool
+	Action    Action
+	Pattern   string
+	Tenant    string
+	Principal string
+	Role      string
+
+	tokens []string
+}
+
+func (r Rule) appliesTo(id auth.Identity, action Action) bool {
+	if r.Action != "" && r.Action != action {
+		return false
+	}
+	if r.Tenant != "" && r.Tenant != id.TenantID {
+		return false
+	}
+	if r.Principal != "" && r.Principal != id.Principal {
+		return false
+	}
+	if r.Role != "" && !id.HasRole(r.Role) {
+		return false
+	}
+	return true
+}
+
+// Policy is an ordered rule set. Deny rules win over allow rules and anything
+// not explicitly allowed is denied.
+type Policy struct {
+	Rules []Rule
+}
+
+// Parse reads one rule per line:
+//
+//	allow publish   orders.*  role:publisher
+//	deny  subscribe admin.>
+//	allow *         >         tenant:acme principal:ops-bot
+//
+// Blank lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Policy, error) {
+	policy := &Policy{}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseRule(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func parseRule(fields []string) (Rule, error) {
+	if len(fields) < 3 {
+		return Rule{}, fmt.Errorf("expected effect, action and pattern")
+	}
+
+	var rule Rule
+	switch fields[0] {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return Rule{}, fmt.Errorf("invalid effect %q: expected allow or deny", fields[0])
+	}
+
+	switch fields[1] {
+	case "publish":
+		rule.Action = Publish
+	case "subscribe":
+		rule.Action = Subscribe
+	case "*":
+	default:
+		return Rule{}, fmt.Errorf("invalid action %q: expected publish, subscribe or *", fields[1])
+	}
+
+	tokens, err := parsePattern(fields[2])
+	if err != nil {
+		return Rule{}, err
+	}
+	rule.Pattern = fields[2]
+	rule.tokens = tokens
+
+	for _, qualifier := range fields[3:] {
+		kind, value, ok := strings.Cut(qualifier, ":")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("invalid subject %q: expected tenant:, principal: or role:", qualifier)
+		}
+		switch kind {
+		case "tenant":
+			rule.Tenant = value
+		case "principal":
+			rule.Principal = value
+		case "role":
+			rule.Role = value
+		default:
+			return Rule{}, fmt.Errorf("invalid subject %q: expected tenant:, principal: or role:", qualifier)
+		}
+	}
+
+	return rule, nil
+}
+
+// Evaluate decides whether id may perform action on topic, which for a
+// subscription may itself be a wildcard pattern. A pattern is allowed only if
+// one allow rule covers all of it and no deny rule overlaps any part of it,
+// so subscribing to ">" fails while any "deny subscribe" rule exists.
+func (p *Policy) Evaluate(id auth.Identity, action Action, topic string) (bool, error) {
+	target, err := parsePattern(topic)
+	if err != nil {
+		return false, err
+	}
+
+	allowed := false
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(id, action) {
+			continue
+		}
+		if !rule.Allow && intersects(rule.tokens, target) {
+			return false, nil
+		}
+		if rule.Allow && covers(rule.tokens, target) {
+			allowed = true
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,198 @@
+package acl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+)
+
+func mustParse(t *testing.T, rules string) *Policy {
+	t.Helper()
+	policy, err := Parse(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestEvaluate(t *testing.T) {
+	policy := mustParse(t, `
+# Publishers write orders; everyone reads everything but admin.
+allow publish   orders.*   role:publisher
+allow subscribe >
+deny  subscribe admin.>
+deny  publish   orders.internal
+allow *         audit.#    tenant:acme principal:auditor
+allow publish   metrics.>  tenant:acme
+`)
+
+	publisher := auth.Identity{TenantID: "acme", Principal: "svc", Roles: []string{"publisher"}}
+	reader := auth.Identity{TenantID: "acme", Principal: "reader"}
+	auditor := auth.Identity{TenantID: "acme", Principal: "auditor"}
+	otherAuditor := auth.Identity{TenantID: "globex", Principal: "auditor"}
+
+	tests := []struct {
+		name   string
+		id     auth.Identity
+		action Action
+		topic  string
+		want   bool
+	}{
+		{"role allows", publisher, Publish, "orders.eu", true},
+		{"role missing", reader, Publish, "orders.eu", false},
+		{"* matches one level only", publisher, Publish, "orders.eu.de", false},
+		{"deny wins over allow", publisher, Publish, "orders.internal", false},
+		{"default deny", publisher, Publish, "payments", false},
+		{"action must match", reader, Publish, "news", false},
+
+		{"allow everything", reader, Subscribe, "news.today", true},
+		{"deny a concrete topic", reader, Subscribe, "admin.users", false},
+		{"> needs a level after its prefix", reader, Subscribe, "admin", true},
+		{"wildcard inside the denied range", reader, Subscribe, "admin.*", false},
+		{"wildcard overlapping a deny", reader, Subscribe, ">", false},
+		{"wildcard beside a deny", reader, Subscribe, "news.>", true},
+		{"single level wildcard overlapping a deny", reader, Subscribe, "*.users", false},
+
+		{"principal and tenant qualify", auditor, Subscribe, "audit.log", true},
+		{"any action", auditor, Publish, "audit.log", true},
+		{"tenant qualifier", otherAuditor, Publish, "audit.log", false},
+		{"principal qualifier", reader, Publish, "audit.log", false},
+		{"# is one or more levels", auditor, Publish, "audit", false},
+		{"tenant only", reader, Publish, "metrics.cpu.load", true},
+		{"tenant only, other tenant", auth.Identity{TenantID: "globex", Principal: "svc"}, Publish, "metrics.cpu", false},
+		{"wildcard covered by an allow", reader, Publish, "metrics.>", true},
+		{"wildcard wider than the allow", reader, Publish, "*.cpu", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Evaluate(tt.id, tt.action, tt.topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("%s %s %s: allowed %v, want %v", tt.id.Principal, tt.action, tt.topic, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := policy.Evaluate(reader, Subscribe, "a..b"); err == nil {
+		t.Fatal("evaluated an invalid topic")
+	}
+}
+
+func TestCoversAndIntersects(t *testing.T) {
+	tests := []struct {
+		rule, target string
+		covers       bool
+		intersects   bool
+	}{
+		{"a", "a", true, true},
+		{"a", "b", false, false},
+		{"a.>", "a", false, false},
+		{"a.>", "a.b", true, true},
+		{"a.>", "a.b.c", true, true},
+		{"a.>", "a.*", true, true},
+		{"a.>", "a.>", true, true},
+		{"a.*", "a.>", false, true},
+		{"a.*", "a.*", true, true},
+		{"a.*", "a.b.c", false, false},
+		{"a.b", "a.*", false, true},
+		{"*", "a", true, true},
+		{"*", "a.b", false, false},
+		{">", "a.b", true, true},
+		{">", ">", true, true},
+		{"a.#", "a.b", true, true},
+		{"a.b", "*.c", false, false},
+		{"*.b", "a.*", false, true},
+	}
+
+	for _, tt := range tests {
+		rule, err := parsePattern(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, err := parsePattern(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := covers(rule, target); got != tt.covers {
+			t.Errorf("covers(%s, %s) = %v, want %v", tt.rule, tt.target, got, tt.covers)
+		}
+		if got := intersects(rule, target); got != tt.intersects {
+			t.Errorf("intersects(%s, %s) = %v, want %v", tt.rule, tt.target, got, tt.intersects)
+		}
+		if got := intersects(target, rule); got != tt.intersects {
+			t.Errorf("intersects(%s, %s) = %v, want %v", tt.target, tt.rule, got, tt.intersects)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{"too few fields", "allow publish\n", "line 1: expected effect, action and pattern"},
+		{"bad effect", "# comment\n\npermit publish orders\n", "line 3: invalid effect"},
+		{"bad action", "allow read orders\n", "line 1: invalid action"},
+		{"empty level", "allow publish orders..eu\n", "line 1: invalid pattern"},
+		{"> not last", "allow * orders.\n", "empty level"},
+		{"# not last", "allow publish >\ndeny subscribe a.#.b\n", "line 2: invalid pattern \"a.#.b\": # must be the last level"},
+		{"qualifier without value", "allow publish orders role:\n", "line 1: invalid subject"},
+		{"unknown qualifier", "allow publish orders group:ops\n", "line 1: invalid subject \"group:ops\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.rules))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl")
+	write := func(rules string) {
+		if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reader := auth.Identity{TenantID: "acme", Principal: "reader"}
+
+	write("allow subscribe orders.>\n")
+	store, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := store.Policy()
+	if err := store.Authorize(reader, Subscribe, "orders.eu"); err != nil {
+		t.Fatal(err)
+	}
+
+	write("deny subscribe orders.eu\nallow subscribe orders.>\n")
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Policy() == first {
+		t.Fatal("reload kept the old policy")
+	}
+	if err := store.Authorize(reader, Subscribe, "orders.eu"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("after reload got %v, want denied", err)
+	}
+
+	// A broken file leaves the last good policy in force.
+	write("allow subscribe orders.>\nnonsense\n")
+	if err := store.Reload(); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("reload of a broken file gave %v", err)
+	}
+	if store.RuleCount() != 2 {
+		t.Fatalf("%d rules in force after a failed reload, want 2", store.RuleCount())
+	}
+}
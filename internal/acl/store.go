@@ -0,0 +1,105 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+)
+
+var ErrDenied = errors.New("access denied")
+
+// Store holds the active policy loaded from a file and swaps it atomically
+// on Reload, so evaluations never see a half-loaded rule set.
+type Store struct {
+	path   string
+	policy atomic.Pointer[Policy]
+
+	reloadMu sync.Mutex
+	loadedAt time.Time
+	reloads  int64
+
+	deniedPublish   atomic.Int64
+	deniedSubscribe atomic.Int64
+}
+
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the policy file. On error the previous policy stays active.
+func (s *Store) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("open ACL file: %w", err)
+	}
+	defer file.Close()
+
+	policy, err := Parse(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	if s.policy.Swap(policy) != nil {
+		s.reloads++
+	}
+	s.loadedAt = time.Now()
+	return nil
+}
+
+// Policy returns the policy in force. A reload replaces it with a new one,
+// so callers may cache answers for as long as it is the same pointer.
+func (s *Store) Policy() *Policy {
+	return s.policy.Load()
+}
+
+func (s *Store) RuleCount() int {
+	return len(s.policy.Load().Rules)
+}
+
+// Authorize returns an error wrapping ErrDenied when the policy does not
+// allow id to perform action on topic.
+func (s *Store) Authorize(id auth.Identity, action Action, topic string) error {
+	allowed, err := s.policy.Load().Evaluate(id, action, topic)
+	if err != nil {
+		return err
+	}
+
+	if allowed {
+		return nil
+	}
+
+	switch action {
+	case Publish:
+		s.deniedPublish.Add(1)
+	case Subscribe:
+		s.deniedSubscribe.Add(1)
+	}
+	return fmt.Errorf("%w: %s may not %s %s", ErrDenied, id.Principal, action, topic)
+}
+
+func (s *Store) GetMetrics() map[string]interface{} {
+	s.reloadMu.Lock()
+	loadedAt := s.loadedAt
+	reloads := s.reloads
+	s.reloadMu.Unlock()
+
+	return map[string]interface{}{
+		"rules":            s.RuleCount(),
+		"loaded_at":        loadedAt,
+		"reloads":          reloads,
+		"denied_publish":   s.deniedPublish.Load(),
+		"denied_subscribe": s.deniedSubscribe.Load(),
+	}
+}
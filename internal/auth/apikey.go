@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKeyAuthenticator accepts static API keys loaded from a file with one key
+// per line:
+//
+//	# key               tenant   principal   roles
+//	k_live_3f9a...      acme     ingest-svc  publisher,ops
+//
+// Blank lines and lines starting with # are ignored; roles are optional.
+type APIKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]Identity
+}
+
+func LoadAPIKeys(path string) (*APIKeyAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open API key file: %w", err)
+	}
+	defer file.Close()
+
+	authenticator := &APIKeyAuthenticator{keys: make(map[[sha256.Size]byte]Identity)}
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s:%d: expected key, tenant, principal and optional roles", path, lineNumber)
+		}
+
+		id := Identity{TenantID: fields[1], Principal: fields[2]}
+		if len(fields) == 4 {
+			id.Roles = strings.Split(fields[3], ",")
+		}
+
+		// Keys are stored hashed so lookups do not leak key contents through
+		// timing and the plaintext does not linger in memory.
+		digest := sha256.Sum256([]byte(fields[0]))
+		if _, exists := authenticator.keys[digest]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate API key", path, lineNumber)
+		}
+		authenticator.keys[digest] = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read API key file: %w", err)
+	}
+
+	return authenticator, nil
+}
+
+func (a *APIKeyAuthenticator) Authenticate(credential string) (Identity, error) {
+	id, ok := a.keys[sha256.Sum256([]byte(credential))]
+	if !ok {
+		return Identity{}, errUnrecognized
+	}
+	return id, nil
+}
+
+func (a *APIKeyAuthenticator) Name() string {
+	return "api_key"
+}
+
+func (a *APIKeyAuthenticator) Count() int {
+	return len(a.keys)
+}
@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSecret = []byte("s3cret")
+	testKey    = mustRSAKey()
+)
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func encode(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func hs256(secret []byte, signed string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func rs256(key *rsa.PrivateKey, signed string) string {
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwt builds a token with alg in its header, signed as alg says; any other
+// alg gets an empty signature.
+func jwt(alg, claims string) string {
+	signed := encode(`{"alg":"`+alg+`","typ":"JWT"}`) + "." + encode(claims)
+	switch alg {
+	case "HS256":
+		return signed + "." + hs256(testSecret, signed)
+	case "RS256":
+		return signed + "." + rs256(testKey, signed)
+	}
+	return signed + "."
+}
+
+// claims is a valid body with fields appended or overridden by extra.
+func claims(extra string) string {
+	body := `"sub":"svc","tenant_id":"acme","roles":["publisher"],"exp":` + unix(time.Hour)
+	if extra != "" {
+		body += "," + extra
+	}
+	return "{" + body + "}"
+}
+
+// unix is the time offset from now, as a JSON NumericDate.
+func unix(offset time.Duration) string {
+	return strconv.FormatInt(time.Now().Add(offset).Unix(), 10)
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	hsOnly := JWTConfig{HS256Secret: testSecret}
+	rsOnly := JWTConfig{RS256Key: &testKey.PublicKey}
+	scoped := JWTConfig{HS256Secret: testSecret, Issuer: "issuer", Audience: "clevr"}
+
+	tests := []struct {
+		name   string
+		config JWTConfig
+		token  string
+		err    string
+	}{
+		{"HS256", hsOnly, jwt("HS256", claims("")), ""},
+		{"RS256", rsOnly, jwt("RS256", claims("")), ""},
+		{"alg none", hsOnly, jwt("none", claims("")), `unsupported algorithm "none"`},
+		{"HS256 without a secret", rsOnly, jwt("HS256", claims("")), `unsupported algorithm "HS256"`},
+		{"RS256 with only a secret", hsOnly, jwt("RS256", claims("")), `unsupported algorithm "RS256"`},
+		{"HS256 bad signature", hsOnly, jwt("HS256", claims("")) + "x", "bad signature"},
+		{"RS256 bad signature", rsOnly, strings.Replace(jwt("RS256", claims("")), encode(claims("")), encode(claims(`"sub":"root"`)), 1), "bad signature"},
+		{"HS256 wrong secret", JWTConfig{HS256Secret: []byte("other")}, jwt("HS256", claims("")), "bad signature"},
+		{"missing exp", hsOnly, jwt("HS256", `{"sub":"svc","tenant_id":"acme"}`), "no exp claim"},
+		{"expired", hsOnly, jwt("HS256", claims(`"exp":`+unix(-time.Minute))), "token expired"},
+		{"not yet valid", hsOnly, jwt("HS256", claims(`"nbf":`+unix(time.Minute))), "not yet valid"},
+		{"already valid", hsOnly, jwt("HS256", claims(`"nbf":`+unix(-time.Minute))), ""},
+		{"issuer and audience", scoped, jwt("HS256", claims(`"iss":"issuer","aud":"clevr"`)), ""},
+		{"issuer mismatch", scoped, jwt("HS256", claims(`"iss":"other","aud":"clevr"`)), "unexpected issuer"},
+		{"missing issuer", scoped, jwt("HS256", claims(`"aud":"clevr"`)), "unexpected issuer"},
+		{"audience string mismatch", scoped, jwt("HS256", claims(`"iss":"issuer","aud":"other"`)), "audience"},
+		{"audience list", scoped, jwt("HS256", claims(`"iss":"issuer","aud":["other","clevr"]`)), ""},
+		{"audience list mismatch", scoped, jwt("HS256", claims(`"iss":"issuer","aud":["other","more"]`)), "audience"},
+		{"audience of the wrong type", scoped, jwt("HS256", claims(`"iss":"issuer","aud":7`)), "malformed claims"},
+		{"missing tenant_id", hsOnly, jwt("HS256", `{"sub":"svc","exp":`+unix(time.Hour)+`}`), "no tenant_id claim"},
+		{"missing sub", hsOnly, jwt("HS256", `{"tenant_id":"acme","exp":`+unix(time.Hour)+`}`), "no sub claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewJWTAuthenticator(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := a.Authenticate(tt.token)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				if id.TenantID != "acme" || id.Principal != "svc" || !slices.Equal(id.Roles, []string{"publisher"}) {
+					t.Fatalf("authenticated as %+v", id)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an invalid credentials error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestJWTLeavesOtherFormatsToTheChain(t *testing.T) {
+	a, err := NewJWTAuthenticator(JWTConfig{HS256Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, credential := range []string{"k_live_123", "one.two", "a.b.c.d", "!!.e30.sig"} {
+		if _, err := a.Authenticate(credential); !errors.Is(err, errUnrecognized) {
+			t.Errorf("%q gave %v, want it left unrecognized", credential, err)
+		}
+	}
+}
+
+func TestNewJWTAuthenticatorNeedsAKey(t *testing.T) {
+	if _, err := NewJWTAuthenticator(JWTConfig{Issuer: "issuer"}); err == nil {
+		t.Fatal("created a JWT authenticator with no key")
+	}
+}
+
+func hmacToken(secret []byte, body string) string {
+	payload := encode(body)
+	return payload + "." + hs256(secret, payload)
+}
+
+func TestHMACAuthenticate(t *testing.T) {
+	a := NewHMACAuthenticator(testSecret)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+		msg   string
+	}{
+		{"valid", hmacToken(testSecret, claims("")), nil, ""},
+		{"no dot", encode(claims("")), errUnrecognized, ""},
+		{"two dots is a JWT", jwt("HS256", claims("")), errUnrecognized, ""},
+		{"signature not base64", encode(claims("")) + ".!!", errUnrecognized, ""},
+		{"bad signature", hmacToken([]byte("other"), claims("")), ErrInvalidCredentials, "bad signature"},
+		{"expired", hmacToken(testSecret, claims(`"exp":`+unix(-time.Minute))), ErrInvalidCredentials, "token expired"},
+		{"missing exp", hmacToken(testSecret, `{"sub":"svc","tenant_id":"acme"}`), ErrInvalidCredentials, "no exp claim"},
+		{"malformed claims", hmacToken(testSecret, `not json`), ErrInvalidCredentials, "malformed claims"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(tt.token)
+			if tt.err == nil {
+				if err != nil || id.TenantID != "acme" || id.Principal != "svc" {
+					t.Fatalf("got %+v, %v", id, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.msg) {
+				t.Fatalf("got %v, want %v containing %q", err, tt.err, tt.msg)
+			}
+		})
+	}
+}
+
+func writeKeys(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := writeKeys(t, `
+# key        tenant  principal   roles
+k_one        acme    ingest-svc  publisher,ops
+
+  k_two      globex  reader
+`)
+	a, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 2 {
+		t.Fatalf("loaded %d keys, want 2", a.Count())
+	}
+
+	id, err := a.Authenticate("k_one")
+	if err != nil || id.TenantID != "acme" || id.Principal != "ingest-svc" || !slices.Equal(id.Roles, []string{"publisher", "ops"}) {
+		t.Fatalf("k_one authenticated as %+v, %v", id, err)
+	}
+	id, err = a.Authenticate("k_two")
+	if err != nil || id.TenantID != "globex" || id.Roles != nil {
+		t.Fatalf("k_two authenticated as %+v, %v", id, err)
+	}
+	if _, err := a.Authenticate("k_three"); !errors.Is(err, errUnrecognized) {
+		t.Fatalf("unknown key gave %v, want it left unrecognized", err)
+	}
+}
+
+func TestLoadAPIKeysErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"too few fields", "k_one acme\n", ":1: expected key, tenant, principal"},
+		{"too many fields", "# header\nk_one acme svc roles extra\n", ":2: expected key, tenant, principal"},
+		{"duplicate key", "k_one acme svc\nk_two acme other\nk_one globex svc\n", ":3: duplicate API key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadAPIKeys(writeKeys(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := LoadAPIKeys(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("loaded a missing key file")
+	}
+}
+
+// countingAuthenticator answers every credential with err, counting calls.
+type countingAuthenticator struct {
+	err   error
+	calls int
+}
+
+func (a *countingAuthenticator) Authenticate(string) (Identity, error) {
+	a.calls++
+	if a.err != nil {
+		return Identity{}, a.err
+	}
+	return Identity{TenantID: "acme", Principal: "svc"}, nil
+}
+
+func (a *countingAuthenticator) Name() string { return "counting" }
+
+func TestChain(t *testing.T) {
+	keys, err := LoadAPIKeys(writeKeys(t, "k_one acme ingest-svc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwts, err := NewJWTAuthenticator(JWTConfig{HS256Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		credential string
+		method     string
+		err        error
+		reachesEnd bool
+	}{
+		{"first recognizes", "k_one", "api_key", nil, false},
+		{"falls through to the second", jwt("HS256", claims("")), "jwt", nil, false},
+		{"hard failure stops the chain", jwt("HS256", claims("")) + "x", "", ErrInvalidCredentials, false},
+		{"nobody recognizes", "k_unknown", "counting", nil, true},
+		{"no credential", "", "", ErrNoCredentials, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := &countingAuthenticator{}
+			chain := Chain{keys, jwts, last}
+			id, err := chain.Authenticate(tt.credential)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if id.Method != tt.method {
+				t.Fatalf("authenticated by %q, want %q", id.Method, tt.method)
+			}
+			if reached := last.calls > 0; reached != tt.reachesEnd {
+				t.Fatalf("last authenticator consulted: %v, want %v", reached, tt.reachesEnd)
+			}
+		})
+	}
+
+	unrecognized := Chain{&countingAuthenticator{err: errUnrecognized}}
+	if _, err := unrecognized.Authenticate("anything"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unrecognized by every authenticator gave %v, want invalid credentials", err)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		query   string
+		want    string
+	}{
+		{"bearer first", map[string]string{"Authorization": "Bearer tok", "X-API-Key": "key"}, "?token=q&api_key=k", "tok"},
+		{"bearer scheme is case insensitive", map[string]string{"Authorization": "bearer  tok "}, "", "tok"},
+		{"other schemes are skipped", map[string]string{"Authorization": "Basic dXNlcg==", "X-API-Key": "key"}, "", "key"},
+		{"api key header before query", map[string]string{"X-API-Key": "key"}, "?token=q&api_key=k", "key"},
+		{"token query before api_key", nil, "?token=q&api_key=k", "q"},
+		{"api_key query", nil, "?api_key=k", "k"},
+		{"none", nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws"+tt.query, nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := Credential(r); got != tt.want {
+				t.Fatalf("credential %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
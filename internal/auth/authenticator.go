@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// errUnrecognized tells a Chain the credential is not in this
+	// authenticator's format, so the next one should try it.
+	errUnrecognized = errors.New("unrecognized credential")
+)
+
+// Authenticator resolves a credential (API key or token) to an identity.
+type Authenticator interface {
+	Authenticate(credential string) (Identity, error)
+	Name() string
+}
+
+// Chain tries each authenticator in turn; the first one that recognizes the
+// credential decides.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(credential string) (Identity, error) {
+	if credential == "" {
+		return Identity{}, ErrNoCredentials
+	}
+
+	for _, authenticator := range c {
+		id, err := authenticator.Authenticate(credential)
+		if errors.Is(err, errUnrecognized) {
+			continue
+		}
+		if err != nil {
+			return Identity{}, err
+		}
+		id.Method = authenticator.Name()
+		return id, nil
+	}
+	return Identity{}, ErrInvalidCredentials
+}
+
+func (c Chain) Name() string {
+	names := make([]string, len(c))
+	for i, authenticator := range c {
+		names[i] = authenticator.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Credential extracts the credential from a request, in order of preference:
+// an Authorization bearer token, an X-API-Key header, or a token / api_key
+// query parameter for clients such as browsers that cannot set headers on a
+// WebSocket or EventSource.
+func Credential(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	query := r.URL.Query()
+	if token := query.Get("token"); token != "" {
+		return token
+	}
+	return query.Get("api_key")
+}
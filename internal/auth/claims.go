@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Claims is the token body shared by HMAC tokens and JWTs.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	TenantID  string   `json:"tenant_id"`
+	Roles     []string `json:"roles,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+}
+
+// audience accepts the JWT "aud" claim as either a string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("aud must be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+// validate checks the time window, which must end, and, when configured, issuer and audience.
+func (c Claims) validate(now time.Time, issuer, aud string) error {
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("%w: token has no exp claim", ErrInvalidCredentials)
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	}
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore {
+		return fmt.Errorf("%w: token not yet valid", ErrInvalidCredentials)
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidCredentials, c.Issuer)
+	}
+	if aud != "" && !slices.Contains(c.Audience, aud) {
+		return fmt.Errorf("%w: token not issued for this audience", ErrInvalidCredentials)
+	}
+	if c.TenantID == "" {
+		return fmt.Errorf("%w: token has no tenant_id claim", ErrInvalidCredentials)
+	}
+	if c.Subject == "" {
+		return fmt.Errorf("%w: token has no sub claim", ErrInvalidCredentials)
+	}
+	return nil
+}
+
+func (c Claims) identity() Identity {
+	return Identity{
+		TenantID:  c.TenantID,
+		Principal: c.Subject,
+		Roles:     c.Roles,
+	}
+}
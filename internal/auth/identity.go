@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// DefaultTenant is used when authentication is disabled.
+const DefaultTenant = "default-tenant"
+
+// AdminRole grants access to server-wide state, such as every tenant's
+// metrics, instead of only the caller's own tenant.
+const AdminRole = "admin"
+
+// Identity is who a request was authenticated as.
+type Identity struct {
+	TenantID  string
+	Principal string
+	Roles     []string
+	// Method names the authenticator that accepted the credential.
+	Method string
+}
+
+// Anonymous is the identity given to every request when no authenticator is
+// configured.
+var Anonymous = Identity{
+	TenantID:  DefaultTenant,
+	Principal: "anonymous",
+	Method:    "none",
+}
+
+func (id Identity) HasRole(role string) bool {
+	return slices.Contains(id.Roles, role)
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity the middleware attached to ctx.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Current returns the identity attached to ctx, or Anonymous when the
+// request carries none.
+func Current(ctx context.Context) Identity {
+	if id, ok := FromContext(ctx); ok && id.TenantID != "" {
+		return id
+	}
+	return Anonymous
+}
+
+// TenantID returns the authenticated tenant, or DefaultTenant when the
+// request carries no identity.
+func TenantID(ctx context.Context) string {
+	return Current(ctx).TenantID
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Middleware authenticates requests and attaches the resulting Identity to
+// the request context. With no authenticator every request is Anonymous.
+type Middleware struct {
+	authenticator Authenticator
+
+	authenticated atomic.Int64
+	rejected      atomic.Int64
+}
+
+func NewMiddleware(authenticator Authenticator) *Middleware {
+	return &Middleware{authenticator: authenticator}
+}
+
+func (m *Middleware) Enabled() bool {
+	return m.authenticator != nil
+}
+
+// Authenticator returns the configured authenticator, for handlers that
+// authenticate in-band, or nil when authentication is disabled.
+func (m *Middleware) Authenticator() Authenticator {
+	return m.authenticator
+}
+
+// Require rejects requests without valid credentials with 401.
+func (m *Middleware) Require(next http.Handler) http.Handler {
+	return m.wrap(next, false)
+}
+
+// Deferred rejects invalid credentials but lets requests without any through
+// unauthenticated, for WebSocket upgrades that send their credential in the
+// first frame. The handler must check FromContext.
+func (m *Middleware) Deferred(next http.Handler) http.Handler {
+	return m.wrap(next, true)
+}
+
+func (m *Middleware) wrap(next http.Handler, allowMissing bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.authenticator == nil {
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), Anonymous)))
+			return
+		}
+
+		credential := Credential(r)
+		if credential == "" && allowMissing {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := m.Authenticate(credential)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="clevr-live"`)
+			http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// Authenticate checks a credential and counts the outcome.
+func (m *Middleware) Authenticate(credential string) (Identity, error) {
+	if m.authenticator == nil {
+		return Anonymous, nil
+	}
+
+	id, err := m.authenticator.Authenticate(credential)
+	if err != nil {
+		m.rejected.Add(1)
+		return Identity{}, err
+	}
+	m.authenticated.Add(1)
+	return id, nil
+}
+
+func (m *Middleware) GetMetrics() map[string]interface{} {
+	method := "none"
+	if m.authenticator != nil {
+		method = m.authenticator.Name()
+	}
+
+	return map[string]interface{}{
+		"methods":       method,
+		"authenticated": m.authenticated.Load(),
+		"rejected":      m.rejected.Load(),
+	}
+}
@@ -1,9 +1,10 @@
 package buffer
 
 import (
-	"runtime"
 	"sync/atomic"
 	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
 )
 
 const (
@@ -11,22 +12,48 @@ const (
 	MaxBufferSize = 1000
 	ReCalcTime    = 5 * time.Second
 	AvgMessSize   = 1024
+
+	// MinSubscriberBytes is the smallest byte budget a subscriber is ever
+	// given, however many subscribers share the memory.
+	MinSubscriberBytes = 256 * 1024
 )
 
+// ByteBudget bounds the encoded bytes sitting in subscriber buffers. Total
+// caps every buffer together; PerSubscriber caps any single one.
+type ByteBudget struct {
+	Total         int64
+	PerSubscriber int64
+}
+
+// ResizeFunc applies new per-subscriber limits to every live subscriber.
+type ResizeFunc func(slots int, subscriberBytes int64)
+
 type AddaptiveBufferManager struct {
 	maxTotalMemory int64
 	suncriberCount atomic.Int32
 	bufferSize     atomic.Int32
+	sampler        load.LoadSampler
 	stopChan       chan struct{}
+
+	budget          ByteBudget
+	subscriberBytes atomic.Int64
+	bytesInFlight   atomic.Int64
+	refused         atomic.Int64
+
+	onResize atomic.Pointer[ResizeFunc]
+	resizes  atomic.Int64
 }
 
-func NewAdaptiveBufferManager(maxMemort int64) *AddaptiveBufferManager {
+func NewAdaptiveBufferManager(maxMemort int64, budget ByteBudget, sampler load.LoadSampler) *AddaptiveBufferManager {
 	adm := &AddaptiveBufferManager{
 		maxTotalMemory: maxMemort,
+		sampler:        sampler,
 		stopChan:       make(chan struct{}),
+		budget:         budget,
 	}
 
 	adm.bufferSize.Store(MaxBufferSize)
+	adm.subscriberBytes.Store(budget.PerSubscriber)
 	return adm
 }
 
@@ -55,11 +82,16 @@ func (adm *AddaptiveBufferManager) recalculate() {
 		return
 	}
 
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	currentMomory := int64(m.Alloc)
+	// The sampler's limit is already the tightest of MAX_MEMORY_MB, the
+	// cgroup limit and GOMEMLIMIT.
+	sample := adm.sampler.Sample()
+	maxMemory := adm.maxTotalMemory
+	if sample.MemoryLimit > 0 && int64(sample.MemoryLimit) < maxMemory {
+		maxMemory = int64(sample.MemoryLimit)
+	}
+	currentMomory := int64(sample.MemoryUsed)
 
-	availableMemory := adm.maxTotalMemory - currentMomory
+	availableMemory := maxMemory - currentMomory
 	if availableMemory < 0 {
 		availableMemory = 0
 	}
@@ -67,6 +99,20 @@ func (adm *AddaptiveBufferManager) recalculate() {
 	memoryPerSub := availableMemory / int64(subCount)
 	bufferPerSub := int32(memoryPerSub / AvgMessSize)
 
+	// Byte budgets share whichever is smaller, the buffer budget or the
+	// memory actually left, between the current subscribers.
+	pool := adm.budget.Total
+	if availableMemory < pool {
+		pool = availableMemory
+	}
+	bytesPerSub := pool / int64(subCount)
+	if bytesPerSub > adm.budget.PerSubscriber {
+		bytesPerSub = adm.budget.PerSubscriber
+	}
+	if bytesPerSub < MinSubscriberBytes {
+		bytesPerSub = MinSubscriberBytes
+	}
+
 	if bufferPerSub < MinBifferSize {
 		bufferPerSub = MinBifferSize
 	}
@@ -74,13 +120,81 @@ func (adm *AddaptiveBufferManager) recalculate() {
 		bufferPerSub = MaxBufferSize
 	}
 
-	adm.bufferSize.Store(bufferPerSub)
+	oldBytes := adm.subscriberBytes.Swap(bytesPerSub)
+	oldSize := adm.bufferSize.Swap(bufferPerSub)
+
+	// Existing buffers follow the new sizes too, otherwise a shrink would
+	// only relieve memory as subscribers reconnect.
+	if onResize := adm.onResize.Load(); onResize != nil && (oldBytes != bytesPerSub || oldSize != bufferPerSub) {
+		adm.resizes.Add(1)
+		(*onResize)(int(bufferPerSub), bytesPerSub)
+	}
+}
+
+// SetResizeHandler registers fn to resize live subscribers whenever a
+// recalculation changes the per-subscriber limits.
+func (adm *AddaptiveBufferManager) SetResizeHandler(fn ResizeFunc) {
+	adm.onResize.Store(&fn)
 }
 
 func (adm *AddaptiveBufferManager) GetBufferSize() int {
 	return int(adm.bufferSize.Load())
 }
 
+// GetSubscriberBytes is the byte budget for a single subscriber buffer.
+func (adm *AddaptiveBufferManager) GetSubscriberBytes() int64 {
+	return adm.subscriberBytes.Load()
+}
+
+// Reserve claims n bytes of the global buffer budget, failing rather than
+// going over it. Every successful Reserve must be matched by a Release.
+func (adm *AddaptiveBufferManager) Reserve(n int64) bool {
+	for {
+		current := adm.bytesInFlight.Load()
+		if current+n > adm.budget.Total {
+			adm.refused.Add(1)
+			return false
+		}
+		if adm.bytesInFlight.CompareAndSwap(current, current+n) {
+			return true
+		}
+	}
+}
+
+// Charge claims n bytes even past the budget, for memory that has to be
+// held regardless, such as the newest message of a topic ring.
+func (adm *AddaptiveBufferManager) Charge(n int64) {
+	adm.bytesInFlight.Add(n)
+}
+
+// MaxSubscriberBytes is the configured ceiling on a subscriber's byte
+// budget, however few subscribers there are.
+func (adm *AddaptiveBufferManager) MaxSubscriberBytes() int64 {
+	return adm.budget.PerSubscriber
+}
+
+func (adm *AddaptiveBufferManager) Release(n int64) {
+	adm.bytesInFlight.Add(-n)
+}
+
+// BytesInFlight is the encoded size of every message currently buffered
+// for a subscriber.
+func (adm *AddaptiveBufferManager) BytesInFlight() int64 {
+	return adm.bytesInFlight.Load()
+}
+
+func (adm *AddaptiveBufferManager) GetMetrics() map[string]interface{} {
+	return map[string]interface{}{
+		"subscribers":            adm.suncriberCount.Load(),
+		"buffer_size":            adm.bufferSize.Load(),
+		"bytes_in_flight":        adm.bytesInFlight.Load(),
+		"byte_budget":            adm.budget.Total,
+		"subscriber_byte_budget": adm.subscriberBytes.Load(),
+		"reservations_refused":   adm.refused.Load(),
+		"live_resizes":           adm.resizes.Load(),
+	}
+}
+
 func (adm *AddaptiveBufferManager) AddNewSubscriber() {
 	adm.suncriberCount.Add(1)
 }
@@ -0,0 +1,105 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
+)
+
+// fakeSampler reports whatever memory use the test sets.
+type fakeSampler struct {
+	sample load.Sample
+}
+
+func (s *fakeSampler) Sample() load.Sample { return s.sample }
+
+func TestReserveChargeRelease(t *testing.T) {
+	adm := NewAdaptiveBufferManager(1<<30, ByteBudget{Total: 1000, PerSubscriber: 500}, &fakeSampler{})
+
+	if !adm.Reserve(600) || !adm.Reserve(400) {
+		t.Fatal("reservation within the budget refused")
+	}
+	if adm.Reserve(1) {
+		t.Fatal("reservation past the budget granted")
+	}
+
+	// Charge goes past the budget, and then nothing more can be reserved
+	// until enough is released.
+	adm.Charge(200)
+	if got := adm.BytesInFlight(); got != 1200 {
+		t.Fatalf("%d bytes in flight, want 1200", got)
+	}
+	adm.Release(400)
+	if adm.Reserve(201) {
+		t.Fatal("reservation granted while over the budget")
+	}
+	if !adm.Reserve(200) {
+		t.Fatal("reservation refused with room for it")
+	}
+
+	adm.Release(600)
+	adm.Release(200)
+	adm.Release(200)
+	if got := adm.BytesInFlight(); got != 0 {
+		t.Fatalf("%d bytes in flight after releasing everything", got)
+	}
+	if got := adm.GetMetrics()["reservations_refused"]; got != int64(2) {
+		t.Fatalf("%v refusals counted, want 2", got)
+	}
+}
+
+func TestRecalculate(t *testing.T) {
+	sampler := &fakeSampler{}
+	adm := NewAdaptiveBufferManager(100<<20, ByteBudget{Total: 40 << 20, PerSubscriber: 8 << 20}, sampler)
+
+	var resizes [][2]int64
+	adm.SetResizeHandler(func(slots int, subscriberBytes int64) {
+		resizes = append(resizes, [2]int64{int64(slots), subscriberBytes})
+	})
+
+	// Nothing to share between no subscribers.
+	adm.recalculate()
+	if len(resizes) != 0 || adm.GetSubscriberBytes() != 8<<20 {
+		t.Fatalf("recalculated with no subscribers: %v", resizes)
+	}
+
+	for i := 0; i < 10; i++ {
+		adm.AddNewSubscriber()
+	}
+
+	tests := []struct {
+		name       string
+		memoryUsed uint64
+		limit      uint64
+		slots      int
+		bytes      int64
+	}{
+		// 40MB of budget between ten is 4MB each; 100MB free is 10MB
+		// each, or more slots than the maximum.
+		{"budget bound", 0, 0, MaxBufferSize, 4 << 20},
+		// 5MB free is 512KB each, which also bounds the slots.
+		{"memory bound", 95 << 20, 0, 512 << 10 / AvgMessSize, 512 << 10},
+		// The sampler's limit applies when it is tighter.
+		{"sampler limit", 0, 20 << 20, MaxBufferSize, 2 << 20},
+		// Memory all but gone still leaves the minimums.
+		{"floors", 100 << 20, 0, MinBifferSize, MinSubscriberBytes},
+	}
+	for _, tt := range tests {
+		sampler.sample = load.Sample{MemoryUsed: tt.memoryUsed, MemoryLimit: tt.limit}
+		before := len(resizes)
+		adm.recalculate()
+
+		if adm.GetBufferSize() != tt.slots || adm.GetSubscriberBytes() != tt.bytes {
+			t.Fatalf("%s: %d slots, %d bytes; want %d, %d", tt.name, adm.GetBufferSize(), adm.GetSubscriberBytes(), tt.slots, tt.bytes)
+		}
+		if len(resizes) != before+1 || resizes[before] != [2]int64{int64(tt.slots), tt.bytes} {
+			t.Fatalf("%s: live subscribers resized to %v", tt.name, resizes[before:])
+		}
+	}
+
+	// Limits that did not change resize no one.
+	adm.recalculate()
+	if len(resizes) != len(tests) {
+		t.Fatalf("%d resizes, want %d", len(resizes), len(tests))
+	}
+}
@@ -0,0 +1,339 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+// cborCodec encodes CBOR (RFC 8949). Envelopes are maps with the same keys
+// as JSON; non-JSON payloads are byte strings. The encoder writes definite
+// lengths only; the decoder also accepts indefinite lengths, and skips tags
+// to the value they wrap.
+type cborCodec struct{}
+
+func (cborCodec) Name() string        { return "cbor" }
+func (cborCodec) ContentType() string { return "application/cbor" }
+func (cborCodec) Binary() bool        { return true }
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return appendCBOR(nil, v)
+}
+
+func (cborCodec) Unmarshal(data []byte) (any, error) {
+	d := cborDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("cbor: %d trailing bytes", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+func (c cborCodec) MarshalEnvelope(e Envelope) ([]byte, error) {
+	obj, err := envelopeObject(e)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(obj)
+}
+
+// WithDelivery rewrites the map header for the added entries and appends
+// them.
+func (cborCodec) WithDelivery(envelope []byte, attempt int, subscription string) ([]byte, error) {
+	fields := deliveryFields(attempt, subscription)
+	if len(fields) == 0 {
+		return envelope, nil
+	}
+
+	d := cborDecoder{data: envelope}
+	major, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborMap || indefinite {
+		return nil, fmt.Errorf("cbor: expected a definite length map")
+	}
+
+	data := appendCBORHead(make([]byte, 0, len(envelope)+len(subscription)+32), cborMap, n+uint64(len(fields)))
+	data = append(data, envelope[d.pos:]...)
+	for _, field := range fields {
+		data = appendCBORHead(data, cborText, uint64(len(field.Key)))
+		data = append(data, field.Key...)
+		if data, err = appendCBOR(data, field.Value); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func appendCBOR(buf []byte, v any) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		return append(buf, cborSimple|22), nil
+	case bool:
+		if v {
+			return append(buf, cborSimple|21), nil
+		}
+		return append(buf, cborSimple|20), nil
+	case json.Number:
+		i, u, f, kind := number(v)
+		switch {
+		case kind == 'u':
+			return appendCBORHead(buf, cborUint, u), nil
+		case kind == 'f':
+			return binary.BigEndian.AppendUint64(append(buf, cborSimple|27), math.Float64bits(f)), nil
+		case i >= 0:
+			return appendCBORHead(buf, cborUint, uint64(i)), nil
+		default:
+			return appendCBORHead(buf, cborNegInt, uint64(-1-i)), nil
+		}
+	case string:
+		buf = appendCBORHead(buf, cborText, uint64(len(v)))
+		return append(buf, v...), nil
+	case []byte:
+		buf = appendCBORHead(buf, cborBytes, uint64(len(v)))
+		return append(buf, v...), nil
+	case []any:
+		buf = appendCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if buf, err = appendCBOR(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case Object:
+		buf = appendCBORHead(buf, cborMap, uint64(len(v)))
+		for _, field := range v {
+			buf = appendCBORHead(buf, cborText, uint64(len(field.Key)))
+			buf = append(buf, field.Key...)
+			if buf, err = appendCBOR(buf, field.Value); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("cbor: cannot encode %T", v)
+	}
+}
+
+// appendCBORHead writes a major type and its argument in the shortest form.
+func appendCBORHead(buf []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+	}
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.pos) < n {
+		return nil, fmt.Errorf("cbor: %w", errTruncated)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads a major type and its argument, reporting an indefinite length
+// separately. For major type 7 the argument is the raw float bits or simple
+// value.
+func (d *cborDecoder) head() (major byte, n uint64, indefinite bool, err error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major, info := b[0]&0xe0, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info <= 27:
+		raw, err := d.take(uint64(1) << (info - 24))
+		if err != nil {
+			return 0, 0, false, err
+		}
+		for _, c := range raw {
+			n = n<<8 | uint64(c)
+		}
+		return major, n, false, nil
+	case info == cborIndefinite && major != cborUint && major != cborNegInt && major != cborTag:
+		return major, 0, true, nil
+	default:
+		return 0, 0, false, fmt.Errorf("cbor: invalid initial byte 0x%02x", b[0])
+	}
+}
+
+func (d *cborDecoder) atBreak() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == cborBreak {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("cbor: nested too deeply")
+	}
+	initial := d.pos
+	major, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case cborNegInt:
+		if n == math.MaxUint64 {
+			return json.Number("-18446744073709551616"), nil
+		}
+		return json.Number("-" + strconv.FormatUint(n+1, 10)), nil
+	case cborBytes, cborText:
+		raw, err := d.chunks(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(raw), nil
+		}
+		return raw, nil
+	case cborArray:
+		list := []any{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && d.atBreak() {
+				break
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case cborMap:
+		obj := Object{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && d.atBreak() {
+				break
+			}
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("cbor: map keys must be text strings")
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, Field{Key: name, Value: v})
+		}
+		return obj, nil
+	case cborTag:
+		return d.value(depth + 1)
+	}
+
+	switch info := d.data[initial] & 0x1f; info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return floatNumber(float16(uint16(n)))
+	case 26:
+		return floatNumber(float64(math.Float32frombits(uint32(n))))
+	case 27:
+		return floatNumber(math.Float64frombits(n))
+	default:
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", n)
+	}
+}
+
+// chunks reads a byte or text string, joining the chunks of an indefinite
+// length one.
+func (d *cborDecoder) chunks(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		raw, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), raw...), nil
+	}
+
+	var joined []byte
+	for !d.atBreak() {
+		chunkMajor, size, chunkIndefinite, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkIndefinite {
+			return nil, fmt.Errorf("cbor: invalid chunk in indefinite length string")
+		}
+		raw, err := d.take(size)
+		if err != nil {
+			return nil, err
+		}
+		joined = append(joined, raw...)
+	}
+	return joined, nil
+}
+
+// float16 widens an IEEE 754 half precision float.
+func float16(bits uint16) float64 {
+	sign := 1.0
+	if bits&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(bits>>10) & 0x1f
+	frac := float64(bits & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(frac+1024, exp-25)
+	}
+}
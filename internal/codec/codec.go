@@ -0,0 +1,185 @@
+// Package codec encodes messages and control frames for the wire. JSON is
+// the default; MessagePack, CBOR and Protobuf envelopes are negotiated by
+// clients that want compact binary frames.
+//
+// Every codec speaks the same documents as JSON. Control frames are
+// transcoded through a value tree (see FromJSON), so a frame type added for
+// JSON clients works with every codec. Message envelopes are encoded
+// directly, with non-JSON payloads as raw bytes rather than base64.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SubprotocolPrefix is prepended to a codec's name to form the WebSocket
+// subprotocol that selects it, e.g. clevr.msgpack.
+const SubprotocolPrefix = "clevr."
+
+// Codec encodes documents and message envelopes in one wire format.
+type Codec interface {
+	Name() string
+	ContentType() string
+
+	// Binary reports whether encodings go in binary WebSocket frames.
+	Binary() bool
+
+	// Marshal encodes a value tree as built by FromJSON, and Unmarshal
+	// decodes one.
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte) (any, error)
+
+	// MarshalEnvelope encodes a message without its per-delivery fields,
+	// and WithDelivery adds them to such an encoding, so the shared part
+	// is encoded once per message.
+	MarshalEnvelope(e Envelope) ([]byte, error)
+	WithDelivery(envelope []byte, attempt int, subscription string) ([]byte, error)
+}
+
+// Envelope is a message as codecs see it.
+type Envelope struct {
+	ID          string
+	Offset      uint64
+	Topic       string
+	TenantID    string
+	ContentType string
+	Headers     map[string]string
+
+	// Data is the payload: JSON text when JSON is set, the raw bytes
+	// otherwise.
+	Data      []byte
+	JSON      bool
+	Timestamp time.Time
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+	CBOR    Codec = cborCodec{}
+	Proto   Codec = protoCodec{}
+)
+
+var registry = struct {
+	sync.RWMutex
+	codecs []Codec
+}{codecs: []Codec{JSON, MsgPack, CBOR, Proto}}
+
+// Register adds a codec, selectable by its name, subprotocol and content
+// type. It panics if the name is already taken.
+func Register(c Codec) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, existing := range registry.codecs {
+		if existing.Name() == c.Name() {
+			panic(fmt.Sprintf("codec: %s registered twice", c.Name()))
+		}
+	}
+	registry.codecs = append(registry.codecs, c)
+}
+
+func Lookup(name string) (Codec, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for _, c := range registry.codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Subprotocol is the WebSocket subprotocol that selects c.
+func Subprotocol(c Codec) string {
+	return SubprotocolPrefix + c.Name()
+}
+
+// Subprotocols lists every codec's subprotocol in registration order, which
+// is the order of preference when a client offers several.
+func Subprotocols() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	protocols := make([]string, 0, len(registry.codecs))
+	for _, c := range registry.codecs {
+		protocols = append(protocols, Subprotocol(c))
+	}
+	return protocols
+}
+
+// ForSubprotocol is the codec a negotiated subprotocol selects; no
+// subprotocol means JSON.
+func ForSubprotocol(protocol string) (Codec, bool) {
+	if protocol == "" {
+		return JSON, true
+	}
+	name, ok := strings.CutPrefix(protocol, SubprotocolPrefix)
+	if !ok {
+		return nil, false
+	}
+	return Lookup(name)
+}
+
+// ForContentType is the codec whose media type contentType names.
+func ForContentType(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for _, c := range registry.codecs {
+		if c.ContentType() == mediaType {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// IsJSONContentType reports whether payloads of contentType are JSON; an
+// empty content type means JSON.
+func IsJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Encode writes v, anything encoding/json can marshal, in c's format.
+func Encode(c Codec, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || c == JSON {
+		return data, err
+	}
+	tree, err := FromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(tree)
+}
+
+// Decode reads data in c's format into v as encoding/json would.
+func Decode(c Codec, data []byte, v any) error {
+	if c != JSON {
+		tree, err := c.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+		if data, err = ToJSON(tree); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,251 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var binaryCodecs = []Codec{MsgPack, CBOR, Proto}
+
+// compact normalises a JSON document so encodings can be compared.
+func compact(t *testing.T, data []byte) string {
+	t.Helper()
+	tree, err := FromJSON(data)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	out, err := ToJSON(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestControlFrameRoundTrip(t *testing.T) {
+	docs := []string{
+		`{}`,
+		`{"type":"subscribe","topic":"orders","ack":true,"filter":null}`,
+		`{"type":"published","offset":18446744073709551615}`,
+		`{"type":"ack","offset":9007199254740993}`,
+		`{"min":-9223372036854775808,"neg":-1,"zero":0,"small":23,"byte":255,"word":65536}`,
+		`{"price":101.25,"tiny":-0.0625,"big":1e+300}`,
+		`{"nested":{"list":[1,"two",[3],{"four":4},[],{}],"empty":""},"name":"ünï ✓"}`,
+	}
+
+	for _, c := range append([]Codec{JSON}, binaryCodecs...) {
+		for _, doc := range docs {
+			tree, err := FromJSON([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := c.Marshal(tree)
+			if err != nil {
+				t.Fatalf("%s: marshal %s: %v", c.Name(), doc, err)
+			}
+			decoded, err := c.Unmarshal(encoded)
+			if err != nil {
+				t.Fatalf("%s: unmarshal %s: %v", c.Name(), doc, err)
+			}
+			got, err := ToJSON(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := compact(t, []byte(doc)); string(got) != want {
+				t.Errorf("%s: round trip of %s gave %s", c.Name(), want, got)
+			}
+		}
+	}
+}
+
+func TestEncodeDecodeKeepsLargeOffsets(t *testing.T) {
+	type frame struct {
+		Type   string `json:"type"`
+		Offset uint64 `json:"offset"`
+	}
+	for _, c := range binaryCodecs {
+		want := frame{Type: "published", Offset: 1<<63 + 1}
+		data, err := Encode(c, want)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		var got frame
+		if err := Decode(c, data, &got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if got != want {
+			t.Errorf("%s: decoded %+v, want %+v", c.Name(), got, want)
+		}
+	}
+}
+
+// TestEnvelopeMatchesJSON checks that every codec delivers a message, with
+// its per-delivery fields, as the same document the JSON codec does.
+func TestEnvelopeMatchesJSON(t *testing.T) {
+	envelopes := []Envelope{
+		{
+			ID:        "a1",
+			Offset:    1<<63 + 7,
+			Topic:     "orders",
+			TenantID:  "tenant",
+			Headers:   map[string]string{"trace": "t-1", "region": "eu"},
+			Data:      []byte(`{"symbol":"ACME","price":101.25,"qty":3}`),
+			JSON:      true,
+			Timestamp: time.Unix(1700000000, 123456789).UTC(),
+		},
+		{
+			ID:          "b2",
+			Topic:       "images",
+			TenantID:    "tenant",
+			ContentType: "image/png",
+			Data:        []byte{0x89, 'P', 'N', 'G', 0, 0xff},
+			Timestamp:   time.Unix(1700000001, 0).UTC(),
+		},
+	}
+	deliveries := []struct {
+		attempt      int
+		subscription string
+	}{
+		{0, ""},
+		{3, ""},
+		{0, "audit"},
+		{2, "audit"},
+	}
+
+	for _, e := range envelopes {
+		for _, d := range deliveries {
+			base, err := JSON.MarshalEnvelope(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delivered, err := JSON.WithDelivery(base, d.attempt, d.subscription)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := compact(t, delivered)
+
+			for _, c := range binaryCodecs {
+				base, err := c.MarshalEnvelope(e)
+				if err != nil {
+					t.Fatalf("%s: %v", c.Name(), err)
+				}
+				delivered, err := c.WithDelivery(base, d.attempt, d.subscription)
+				if err != nil {
+					t.Fatalf("%s: %v", c.Name(), err)
+				}
+				decoded, err := c.Unmarshal(delivered)
+				if err != nil {
+					t.Fatalf("%s: %v", c.Name(), err)
+				}
+				got, err := ToJSON(decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s: message %s delivered as %s, want %s", c.Name(), e.ID, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestUnmarshalRejectsMalformedInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		data  []byte
+	}{
+		{"json truncated", JSON, []byte(`{"a":`)},
+		{"json trailing value", JSON, []byte(`{} {}`)},
+
+		{"msgpack empty", MsgPack, nil},
+		{"msgpack truncated string", MsgPack, []byte{0xa5, 'a', 'b'}},
+		{"msgpack trailing bytes", MsgPack, []byte{0x80, 0x00}},
+		{"msgpack unused type", MsgPack, []byte{0xc1}},
+		{"msgpack non-string key", MsgPack, []byte{0x81, 0x01, 0x02}},
+
+		{"cbor empty", CBOR, nil},
+		{"cbor truncated text", CBOR, []byte{0x65, 'a', 'b'}},
+		{"cbor truncated argument", CBOR, []byte{0x1b, 0, 0, 0}},
+		{"cbor trailing bytes", CBOR, []byte{0xa0, 0x00}},
+		{"cbor reserved additional info", CBOR, []byte{0x1c}},
+		{"cbor lone break", CBOR, []byte{0xff}},
+		{"cbor non-text key", CBOR, []byte{0xa1, 0x01, 0x02}},
+		{"cbor unterminated indefinite array", CBOR, []byte{0x9f, 0x01}},
+		{"cbor mismatched chunk", CBOR, []byte{0x7f, 0x41, 'a', 0xff}},
+		{"cbor nested indefinite chunk", CBOR, []byte{0x7f, 0x7f, 0xff, 0xff}},
+		{"cbor NaN", CBOR, []byte{0xf9, 0x7e, 0x00}},
+		// A definite length that the decoder once mistook for an
+		// indefinite one.
+		{"cbor huge definite array", CBOR, []byte{0x9b, 0x1f, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xff}},
+
+		{"proto empty frame", Proto, nil},
+		{"proto unterminated tag", Proto, []byte{0x80}},
+		{"proto truncated control", Proto, []byte{0x12, 0x05, 0x0a}},
+		{"proto unsupported wire type", Proto, []byte{0x0b}},
+		{"proto truncated fixed64", Proto, []byte{0x12, 0x03, 0x11, 0x01, 0x02}},
+	}
+
+	for _, tt := range tests {
+		if v, err := tt.codec.Unmarshal(tt.data); err == nil {
+			t.Errorf("%s: decoded % x as %v, want an error", tt.name, tt.data, v)
+		}
+	}
+}
+
+func TestUnmarshalLimitsNesting(t *testing.T) {
+	doc := strings.Repeat(`{"a":`, maxDepth+10) + "1" + strings.Repeat("}", maxDepth+10)
+	tree, err := FromJSON([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range binaryCodecs {
+		data, err := c.Marshal(tree)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if _, err := c.Unmarshal(data); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Errorf("%s: decoding %d levels gave %v, want a nesting error", c.Name(), maxDepth+10, err)
+		}
+	}
+}
+
+func TestCBORWithDeliveryNeedsDefiniteMap(t *testing.T) {
+	if _, err := CBOR.WithDelivery([]byte{0xbf, 0xff}, 1, "audit"); err == nil {
+		t.Error("added delivery fields to an indefinite length map")
+	}
+
+	// 31<<56 entries is a definite length like any other.
+	envelope := []byte{0xbb, 0x1f, 0, 0, 0, 0, 0, 0, 0}
+	data, err := CBOR.WithDelivery(envelope, 0, "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := cborDecoder{data: data}
+	major, n, indefinite, err := d.head()
+	if err != nil || major != cborMap || indefinite || n != 31<<56+1 {
+		t.Fatalf("map head after delivery is %#x %d %v %v", major, n, indefinite, err)
+	}
+}
+
+func TestIsJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"", true},
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/cloudevents+json", true},
+		{"APPLICATION/JSON", true},
+		{"text/plain", false},
+		{"application/octet-stream", false},
+		{"application/jsonx", false},
+		{"not a media type;;", false},
+	}
+	for _, tt := range tests {
+		if got := IsJSONContentType(tt.contentType); got != tt.want {
+			t.Errorf("IsJSONContentType(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
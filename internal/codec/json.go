@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+func (jsonCodec) Binary() bool        { return false }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return ToJSON(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (any, error) {
+	return FromJSON(data)
+}
+
+// jsonEnvelope is the JSON layout of a message without its per-delivery
+// fields.
+type jsonEnvelope struct {
+	ID          string            `json:"id"`
+	Offset      uint64            `json:"offset"`
+	Topic       string            `json:"topic"`
+	TenantID    string            `json:"tenant_id"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Data        json.RawMessage   `json:"data"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+func (jsonCodec) MarshalEnvelope(e Envelope) ([]byte, error) {
+	data := json.RawMessage(e.Data)
+	if !e.JSON {
+		encoded, err := json.Marshal(e.Data)
+		if err != nil {
+			return nil, err
+		}
+		data = encoded
+	}
+
+	return json.Marshal(jsonEnvelope{
+		ID:          e.ID,
+		Offset:      e.Offset,
+		Topic:       e.Topic,
+		TenantID:    e.TenantID,
+		ContentType: e.ContentType,
+		Headers:     e.Headers,
+		Data:        data,
+		Timestamp:   e.Timestamp,
+	})
+}
+
+// WithDelivery appends attempt and subscription inside the closing brace,
+// which gives the same bytes as encoding them with the rest since they are
+// the envelope's last fields.
+func (jsonCodec) WithDelivery(envelope []byte, attempt int, subscription string) ([]byte, error) {
+	if attempt == 0 && subscription == "" {
+		return envelope, nil
+	}
+
+	data := make([]byte, 0, len(envelope)+len(subscription)+40)
+	data = append(data, envelope[:len(envelope)-1]...)
+	if attempt != 0 {
+		data = append(data, `,"attempt":`...)
+		data = strconv.AppendInt(data, int64(attempt), 10)
+	}
+	if subscription != "" {
+		tag, err := json.Marshal(subscription)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, `,"subscription":`...)
+		data = append(data, tag...)
+	}
+	return append(data, '}'), nil
+}
@@ -0,0 +1,363 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// msgpackCodec encodes MessagePack. Envelopes are maps with the same keys
+// as JSON; non-JSON payloads are bin values.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string        { return "msgpack" }
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+func (msgpackCodec) Binary() bool        { return true }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return appendMsgpack(nil, v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (any, error) {
+	d := msgpackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+func (c msgpackCodec) MarshalEnvelope(e Envelope) ([]byte, error) {
+	obj, err := envelopeObject(e)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(obj)
+}
+
+// WithDelivery rewrites the map header for the added entries and appends
+// them.
+func (msgpackCodec) WithDelivery(envelope []byte, attempt int, subscription string) ([]byte, error) {
+	fields := deliveryFields(attempt, subscription)
+	if len(fields) == 0 {
+		return envelope, nil
+	}
+
+	d := msgpackDecoder{data: envelope}
+	n, err := d.mapHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	data := appendMsgpackHeader(make([]byte, 0, len(envelope)+len(subscription)+32), 0x80, 0xde, 0xdf, n+len(fields))
+	data = append(data, envelope[d.pos:]...)
+	for _, field := range fields {
+		if data, err = appendMsgpack(data, field.Key); err != nil {
+			return nil, err
+		}
+		if data, err = appendMsgpack(data, field.Value); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func appendMsgpack(buf []byte, v any) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		return appendMsgpackNumber(buf, v)
+	case string:
+		buf = appendMsgpackString(buf, len(v))
+		return append(buf, v...), nil
+	case []byte:
+		switch n := len(v); {
+		case n <= math.MaxUint8:
+			buf = append(buf, 0xc4, byte(n))
+		case n <= math.MaxUint16:
+			buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(n))
+		default:
+			buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(n))
+		}
+		return append(buf, v...), nil
+	case []any:
+		buf = appendMsgpackHeader(buf, 0x90, 0xdc, 0xdd, len(v))
+		for _, item := range v {
+			if buf, err = appendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case Object:
+		buf = appendMsgpackHeader(buf, 0x80, 0xde, 0xdf, len(v))
+		for _, field := range v {
+			buf = appendMsgpackString(buf, len(field.Key))
+			buf = append(buf, field.Key...)
+			if buf, err = appendMsgpack(buf, field.Value); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("msgpack: cannot encode %T", v)
+	}
+}
+
+func appendMsgpackNumber(buf []byte, n json.Number) ([]byte, error) {
+	i, u, f, kind := number(n)
+	switch kind {
+	case 'u':
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), u), nil
+	case 'f':
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f)), nil
+	}
+
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(buf, byte(i)), nil
+	case i < 0 && i >= -32:
+		return append(buf, byte(int8(i))), nil
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, 0xcc, byte(i)), nil
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(i)), nil
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(i)), nil
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(i)), nil
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(int8(i))), nil
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(int16(i))), nil
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(int32(i))), nil
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i)), nil
+	}
+}
+
+func appendMsgpackString(buf []byte, n int) []byte {
+	switch {
+	case n < 32:
+		return append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+}
+
+// appendMsgpackHeader writes an array or map header: the fix form for up to
+// 15 entries, otherwise the 16 or 32 bit one.
+func appendMsgpackHeader(buf []byte, fix, code16, code32 byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, code32), uint32(n))
+	}
+}
+
+// maxDepth bounds how deeply the binary decoders nest, so a hostile frame
+// cannot exhaust the stack.
+const maxDepth = 256
+
+var errTruncated = errors.New("truncated input")
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) take(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("msgpack: %w", errTruncated)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *msgpackDecoder) mapHeader() (int, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, err
+	}
+	switch code := b[0]; {
+	case code&0xf0 == 0x80:
+		return int(code & 0x0f), nil
+	case code == 0xde:
+		n, err := d.uint(2)
+		return int(n), err
+	case code == 0xdf:
+		n, err := d.uint(4)
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("msgpack: expected a map, got 0x%02x", code)
+	}
+}
+
+func (d *msgpackDecoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("msgpack: nested too deeply")
+	}
+	b, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+
+	code := b[0]
+	switch {
+	case code <= 0x7f:
+		return json.Number(strconv.Itoa(int(code))), nil
+	case code >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(code)))), nil
+	case code&0xf0 == 0x80:
+		return d.object(int(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return d.array(int(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return d.str(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.take(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), raw...), nil
+	case 0xca:
+		bits, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return floatNumber(float64(math.Float32frombits(uint32(bits))))
+	case 0xcb:
+		bits, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return floatNumber(math.Float64frombits(bits))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (code - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatUint(u, 10)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return json.Number(strconv.FormatInt(int64(u<<shift)>>shift, 10)), nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", code)
+}
+
+func (d *msgpackDecoder) str(n int) (string, error) {
+	b, err := d.take(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) array(n, depth int) ([]any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("msgpack: %w", errTruncated)
+	}
+	list := make([]any, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (d *msgpackDecoder) object(n, depth int) (Object, error) {
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("msgpack: %w", errTruncated)
+	}
+	obj := make(Object, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map keys must be strings")
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, Field{Key: name, Value: v})
+	}
+	return obj, nil
+}
@@ -0,0 +1,409 @@
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Field numbers from clevr.proto.
+const (
+	frameMessage = 1
+	frameControl = 2
+
+	envelopeID           = 1
+	envelopeOffset       = 2
+	envelopeTopic        = 3
+	envelopeTenantID     = 4
+	envelopeContentType  = 5
+	envelopeData         = 6
+	envelopeTimestamp    = 7
+	envelopeAttempt      = 8
+	envelopeSubscription = 9
+	envelopeHeaders      = 10
+
+	structFields = 1
+	entryKey     = 1
+	entryValue   = 2
+
+	valueNull   = 1
+	valueNumber = 2
+	valueString = 3
+	valueBool   = 4
+	valueStruct = 5
+	valueList   = 6
+	valueUint   = 7
+	valueInt    = 8
+
+	listValues = 1
+)
+
+// protoCodec encodes the Frame message of clevr.proto: a message as a typed
+// Envelope, anything else as a Struct. Struct mirrors google.protobuf.Struct
+// but carries integers as varints, so offsets in control frames are exact
+// over the whole uint64 range.
+type protoCodec struct{}
+
+func (protoCodec) Name() string        { return "proto" }
+func (protoCodec) ContentType() string { return "application/x-protobuf" }
+func (protoCodec) Binary() bool        { return true }
+
+// Marshal encodes an object as a control frame.
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	obj, ok := v.(Object)
+	if !ok {
+		return nil, fmt.Errorf("proto: frames must be objects, not %T", v)
+	}
+	control, err := appendProtoStruct(nil, obj)
+	if err != nil {
+		return nil, err
+	}
+	return appendProtoBytes(nil, frameControl, control), nil
+}
+
+// Unmarshal decodes a frame; an Envelope comes back as the object its JSON
+// encoding would give.
+func (protoCodec) Unmarshal(data []byte) (any, error) {
+	var result any
+	err := readProtoFields(data, func(field int, wire byte, value []byte, _ uint64) error {
+		if wire != wireBytes {
+			return nil
+		}
+		var err error
+		switch field {
+		case frameMessage:
+			result, err = readProtoEnvelope(value)
+		case frameControl:
+			result, err = readProtoStruct(value, 0)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("proto: empty frame")
+	}
+	return result, nil
+}
+
+func (protoCodec) MarshalEnvelope(e Envelope) ([]byte, error) {
+	buf := appendProtoString(nil, envelopeID, e.ID)
+	buf = appendProtoVarint(buf, envelopeOffset, e.Offset)
+	buf = appendProtoString(buf, envelopeTopic, e.Topic)
+	buf = appendProtoString(buf, envelopeTenantID, e.TenantID)
+	buf = appendProtoString(buf, envelopeContentType, e.ContentType)
+	buf = appendProtoBytes(buf, envelopeData, e.Data)
+	buf = appendProtoVarint(buf, envelopeTimestamp, uint64(e.Timestamp.UnixNano()))
+	for _, header := range headerObject(e.Headers) {
+		entry := appendProtoString(nil, entryKey, header.Key)
+		entry = appendProtoString(entry, entryValue, header.Value.(string))
+		buf = appendProtoBytes(buf, envelopeHeaders, entry)
+	}
+	return appendProtoBytes(nil, frameMessage, buf), nil
+}
+
+// WithDelivery appends the fields to the Envelope and frames it again;
+// protobuf fields may come in any order, so nothing else is touched.
+func (protoCodec) WithDelivery(envelope []byte, attempt int, subscription string) ([]byte, error) {
+	if attempt == 0 && subscription == "" {
+		return envelope, nil
+	}
+
+	var body []byte
+	err := readProtoFields(envelope, func(field int, wire byte, value []byte, _ uint64) error {
+		if field == frameMessage && wire == wireBytes {
+			body = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	extended := make([]byte, 0, len(body)+len(subscription)+16)
+	extended = append(extended, body...)
+	if attempt != 0 {
+		extended = appendProtoVarint(extended, envelopeAttempt, uint64(attempt))
+	}
+	extended = appendProtoString(extended, envelopeSubscription, subscription)
+	return appendProtoBytes(make([]byte, 0, len(extended)+8), frameMessage, extended), nil
+}
+
+func appendProtoTag(buf []byte, field int, wire byte) []byte {
+	return binary.AppendUvarint(buf, uint64(field)<<3|uint64(wire))
+}
+
+func appendProtoVarint(buf []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return buf
+	}
+	return binary.AppendUvarint(appendProtoTag(buf, field, wireVarint), v)
+}
+
+func appendProtoString(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = binary.AppendUvarint(appendProtoTag(buf, field, wireBytes), uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendProtoBytes writes a length-delimited field, even an empty one, so
+// an empty Struct or payload is still present.
+func appendProtoBytes(buf []byte, field int, b []byte) []byte {
+	buf = binary.AppendUvarint(appendProtoTag(buf, field, wireBytes), uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendProtoStruct(buf []byte, obj Object) ([]byte, error) {
+	for _, field := range obj {
+		value, err := appendProtoValue(nil, field.Value)
+		if err != nil {
+			return nil, err
+		}
+		entry := appendProtoString(nil, entryKey, field.Key)
+		entry = appendProtoBytes(entry, entryValue, value)
+		buf = appendProtoBytes(buf, structFields, entry)
+	}
+	return buf, nil
+}
+
+// appendProtoValue encodes a Value. Integers go in the uint or sint64 kind
+// and only other numbers as doubles. Bytes have no Value kind and are
+// written as base64 strings, as in JSON.
+func appendProtoValue(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return binary.AppendUvarint(appendProtoTag(buf, valueNull, wireVarint), 0), nil
+	case bool:
+		var b uint64
+		if v {
+			b = 1
+		}
+		return binary.AppendUvarint(appendProtoTag(buf, valueBool, wireVarint), b), nil
+	case json.Number:
+		i, u, f, kind := number(v)
+		switch {
+		case kind == 'u':
+			return binary.AppendUvarint(appendProtoTag(buf, valueUint, wireVarint), u), nil
+		case kind == 'i' && i >= 0:
+			return binary.AppendUvarint(appendProtoTag(buf, valueUint, wireVarint), uint64(i)), nil
+		case kind == 'i':
+			return binary.AppendVarint(appendProtoTag(buf, valueInt, wireVarint), i), nil
+		}
+		if _, err := v.Float64(); err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.AppendUint64(appendProtoTag(buf, valueNumber, wireFixed64), math.Float64bits(f)), nil
+	case string:
+		return appendProtoBytes(buf, valueString, []byte(v)), nil
+	case []byte:
+		return appendProtoBytes(buf, valueString, []byte(base64.StdEncoding.EncodeToString(v))), nil
+	case []any:
+		var list []byte
+		for _, item := range v {
+			value, err := appendProtoValue(nil, item)
+			if err != nil {
+				return nil, err
+			}
+			list = appendProtoBytes(list, listValues, value)
+		}
+		return appendProtoBytes(buf, valueList, list), nil
+	case Object:
+		nested, err := appendProtoStruct(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtoBytes(buf, valueStruct, nested), nil
+	default:
+		return nil, fmt.Errorf("proto: cannot encode %T", v)
+	}
+}
+
+// readProtoFields calls fn for each field in a message: value is the
+// payload of a length-delimited field and n the value of any other.
+func readProtoFields(data []byte, fn func(field int, wire byte, value []byte, n uint64) error) error {
+	for len(data) > 0 {
+		tag, size := binary.Uvarint(data)
+		if size <= 0 {
+			return fmt.Errorf("proto: bad field tag")
+		}
+		data = data[size:]
+		field, wire := int(tag>>3), byte(tag&7)
+
+		var value []byte
+		var n uint64
+		switch wire {
+		case wireVarint:
+			n, size = binary.Uvarint(data)
+			if size <= 0 {
+				return fmt.Errorf("proto: bad varint")
+			}
+			data = data[size:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("proto: %w", errTruncated)
+			}
+			n, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("proto: %w", errTruncated)
+			}
+			n, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, size := binary.Uvarint(data)
+			if size <= 0 || uint64(len(data)-size) < length {
+				return fmt.Errorf("proto: %w", errTruncated)
+			}
+			value, data = data[size:size+int(length)], data[size+int(length):]
+		default:
+			return fmt.Errorf("proto: unsupported wire type %d", wire)
+		}
+
+		if err := fn(field, wire, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readProtoEnvelope(data []byte) (Object, error) {
+	var e Envelope
+	var attempt uint64
+	var subscription string
+	err := readProtoFields(data, func(field int, wire byte, value []byte, n uint64) error {
+		switch field {
+		case envelopeID:
+			e.ID = string(value)
+		case envelopeOffset:
+			e.Offset = n
+		case envelopeTopic:
+			e.Topic = string(value)
+		case envelopeTenantID:
+			e.TenantID = string(value)
+		case envelopeContentType:
+			e.ContentType = string(value)
+		case envelopeData:
+			e.Data = append([]byte(nil), value...)
+		case envelopeTimestamp:
+			e.Timestamp = time.Unix(0, int64(n)).UTC()
+		case envelopeAttempt:
+			attempt = n
+		case envelopeSubscription:
+			subscription = string(value)
+		case envelopeHeaders:
+			var name, headerValue string
+			err := readProtoFields(value, func(field int, _ byte, raw []byte, _ uint64) error {
+				switch field {
+				case entryKey:
+					name = string(raw)
+				case entryValue:
+					headerValue = string(raw)
+				}
+				return nil
+			})
+			if e.Headers == nil {
+				e.Headers = make(map[string]string)
+			}
+			e.Headers[name] = headerValue
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.JSON = IsJSONContentType(e.ContentType)
+	if e.JSON && len(e.Data) == 0 {
+		e.Data = []byte("null")
+	}
+	obj, err := envelopeObject(e)
+	if err != nil {
+		return nil, err
+	}
+	return append(obj, deliveryFields(int(attempt), subscription)...), nil
+}
+
+func readProtoStruct(data []byte, depth int) (Object, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("proto: nested too deeply")
+	}
+	obj := Object{}
+	err := readProtoFields(data, func(field int, wire byte, entry []byte, _ uint64) error {
+		if field != structFields || wire != wireBytes {
+			return nil
+		}
+		var key string
+		var value any
+		err := readProtoFields(entry, func(field int, wire byte, raw []byte, _ uint64) error {
+			var err error
+			switch field {
+			case entryKey:
+				key = string(raw)
+			case entryValue:
+				value, err = readProtoValue(raw, depth+1)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		obj = append(obj, Field{Key: key, Value: value})
+		return nil
+	})
+	return obj, err
+}
+
+func readProtoValue(data []byte, depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("proto: nested too deeply")
+	}
+	var result any
+	err := readProtoFields(data, func(field int, wire byte, raw []byte, n uint64) error {
+		var err error
+		switch field {
+		case valueNull:
+			result = nil
+		case valueNumber:
+			result, err = floatNumber(math.Float64frombits(n))
+		case valueUint:
+			result = json.Number(strconv.FormatUint(n, 10))
+		case valueInt:
+			// sint64 is zigzag encoded.
+			result = json.Number(strconv.FormatInt(int64(n>>1)^-int64(n&1), 10))
+		case valueString:
+			result = string(raw)
+		case valueBool:
+			result = n != 0
+		case valueStruct:
+			result, err = readProtoStruct(raw, depth+1)
+		case valueList:
+			list := []any{}
+			err = readProtoFields(raw, func(field int, wire byte, item []byte, _ uint64) error {
+				if field != listValues {
+					return nil
+				}
+				v, err := readProtoValue(item, depth+1)
+				list = append(list, v)
+				return err
+			})
+			result = list
+		}
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,194 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A value tree is what the binary codecs encode and decode: nil, bool,
+// json.Number, string, []byte, []any or Object. Numbers stay json.Number
+// so integers survive the trip exactly, and []byte becomes a base64 string
+// in JSON, as encoding/json writes it.
+
+// Object is a map that keeps its keys in order, so a document transcoded
+// from JSON lists its fields as the JSON did.
+type Object []Field
+
+type Field struct {
+	Key   string
+	Value any
+}
+
+func (o Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// FromJSON decodes a JSON document into a value tree.
+func FromJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := readJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("codec: trailing data after JSON value")
+	}
+	return v, nil
+}
+
+func readJSONValue(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			obj := Object{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, Field{Key: key.(string), Value: value})
+			}
+			_, err := dec.Token()
+			return obj, err
+		case '[':
+			list := []any{}
+			for dec.More() {
+				value, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+		return nil, fmt.Errorf("codec: unexpected %v", token)
+	default:
+		return token, nil
+	}
+}
+
+// ToJSON encodes a value tree as JSON.
+func ToJSON(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// envelopeObject lays an envelope out with the same fields, in the same
+// order, as its JSON encoding, for the codecs that encode maps.
+func envelopeObject(e Envelope) (Object, error) {
+	var data any = e.Data
+	if e.JSON {
+		tree, err := FromJSON(e.Data)
+		if err != nil {
+			return nil, err
+		}
+		data = tree
+	}
+
+	obj := Object{
+		{Key: "id", Value: e.ID},
+		{Key: "offset", Value: json.Number(strconv.FormatUint(e.Offset, 10))},
+		{Key: "topic", Value: e.Topic},
+		{Key: "tenant_id", Value: e.TenantID},
+	}
+	if e.ContentType != "" {
+		obj = append(obj, Field{Key: "content_type", Value: e.ContentType})
+	}
+	if len(e.Headers) > 0 {
+		obj = append(obj, Field{Key: "headers", Value: headerObject(e.Headers)})
+	}
+	return append(obj,
+		Field{Key: "data", Value: data},
+		Field{Key: "timestamp", Value: e.Timestamp.Format(time.RFC3339Nano)},
+	), nil
+}
+
+// headerObject lists headers sorted by name, as encoding/json writes a map.
+func headerObject(headers map[string]string) Object {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	obj := make(Object, 0, len(names))
+	for _, name := range names {
+		obj = append(obj, Field{Key: name, Value: headers[name]})
+	}
+	return obj
+}
+
+// deliveryFields are the per-delivery fields WithDelivery appends.
+func deliveryFields(attempt int, subscription string) Object {
+	var fields Object
+	if attempt != 0 {
+		fields = append(fields, Field{Key: "attempt", Value: json.Number(strconv.Itoa(attempt))})
+	}
+	if subscription != "" {
+		fields = append(fields, Field{Key: "subscription", Value: subscription})
+	}
+	return fields
+}
+
+// number is a json.Number as the narrowest type that holds it exactly.
+func number(n json.Number) (int64, uint64, float64, byte) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, 0, 0, 'i'
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return 0, u, 0, 'u'
+	}
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return 0, 0, f, 'f'
+}
+
+// floatNumber converts a decoded float for the value tree, formatted as
+// encoding/json would: exponents only for very large or small magnitudes.
+// JSON has no NaN or infinities.
+func floatNumber(f float64) (json.Number, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("codec: %v has no JSON representation", f)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	return json.Number(strconv.FormatFloat(f, format, -1, 64)), nil
+}
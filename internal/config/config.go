@@ -3,11 +3,42 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Address   string
 	MaxMemory int64
+	CacheSize int
+	RingSize  int
+
+	BufferMemory          int64
+	SubscriberBufferBytes int64
+
+	WALDir           string
+	WALFsync         string
+	WALFsyncInterval time.Duration
+	WALSegmentBytes  int64
+	WALRetention     int64
+	WALRetentionAge  time.Duration
+
+	DeadLetterTopics []string
+
+	SpillDir           string
+	SpillMaxBytes      int64
+	SpillQueueMaxBytes int64
+	SpillFallback      string
+
+	AuthAPIKeysFile  string
+	AuthHMACSecret   string
+	AuthJWTSecret    string
+	AuthJWTPublicKey string
+	AuthJWTIssuer    string
+	AuthJWTAudience  string
+
+	ACLFile   string
+	QuotaFile string
 }
 
 func getEnv(key, defaultValue string) string {
@@ -26,12 +57,65 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func LoadConfig() Config {
 	address := getEnv("ADDRESS", ":8080")
 	maxMemoryMB := getEnvInt("MAX_MEMORY_MB", 2048)
+	cacheSize := getEnvInt("CACHE_SIZE", 100)
+	ringSize := getEnvInt("RING_SIZE", 4096)
+	bufferMemoryMB := getEnvInt("BUFFER_MEMORY_MB", maxMemoryMB/2)
+	subscriberBufferMB := getEnvInt("SUBSCRIBER_BUFFER_MB", 64)
+
+	walDir := getEnv("WAL_DIR", "")
+	walFsync := getEnv("WAL_FSYNC", "interval")
+	walFsyncIntervalMS := getEnvInt("WAL_FSYNC_INTERVAL_MS", 1000)
+	walSegmentMB := getEnvInt("WAL_SEGMENT_MB", 64)
+	walRetentionMB := getEnvInt("WAL_RETENTION_MB", 0)
+	walRetentionHours := getEnvInt("WAL_RETENTION_HOURS", 0)
+
+	spillMaxMB := getEnvInt("SPILL_MAX_MB", 4096)
+	spillSubscriberMB := getEnvInt("SPILL_SUBSCRIBER_MB", 256)
 
 	return Config{
 		Address:   address,
 		MaxMemory: int64(maxMemoryMB) * 1024 * 1024,
+		CacheSize: cacheSize,
+		RingSize:  ringSize,
+
+		BufferMemory:          int64(bufferMemoryMB) * 1024 * 1024,
+		SubscriberBufferBytes: int64(subscriberBufferMB) * 1024 * 1024,
+
+		WALDir:           walDir,
+		WALFsync:         walFsync,
+		WALFsyncInterval: time.Duration(walFsyncIntervalMS) * time.Millisecond,
+		WALSegmentBytes:  int64(walSegmentMB) * 1024 * 1024,
+		WALRetention:     int64(walRetentionMB) * 1024 * 1024,
+		WALRetentionAge:  time.Duration(walRetentionHours) * time.Hour,
+
+		DeadLetterTopics: getEnvList("DEAD_LETTER_TOPICS"),
+
+		SpillDir:           getEnv("SPILL_DIR", ""),
+		SpillMaxBytes:      int64(spillMaxMB) * 1024 * 1024,
+		SpillQueueMaxBytes: int64(spillSubscriberMB) * 1024 * 1024,
+		SpillFallback:      getEnv("SPILL_FALLBACK", "newest"),
+
+		AuthAPIKeysFile:  getEnv("AUTH_API_KEYS_FILE", ""),
+		AuthHMACSecret:   getEnv("AUTH_HMAC_SECRET", ""),
+		AuthJWTSecret:    getEnv("AUTH_JWT_HS256_SECRET", ""),
+		AuthJWTPublicKey: getEnv("AUTH_JWT_RS256_PUBLIC_KEY", ""),
+		AuthJWTIssuer:    getEnv("AUTH_JWT_ISSUER", ""),
+		AuthJWTAudience:  getEnv("AUTH_JWT_AUDIENCE", ""),
+
+		ACLFile:   getEnv("ACL_FILE", ""),
+		QuotaFile: getEnv("QUOTA_FILE", ""),
 	}
 }
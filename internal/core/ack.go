@@ -0,0 +1,175 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AckConfig enables at-least-once delivery for a subscriber: every message
+// stays in flight until the client acks it, and is redelivered after Timeout
+// until MaxAttempts is reached.
+type AckConfig struct {
+	Timeout     time.Duration
+	MaxAttempts int
+	MaxInFlight int
+}
+
+func DefaultAckConfig() AckConfig {
+	return AckConfig{
+		Timeout:     30 * time.Second,
+		MaxAttempts: 5,
+		MaxInFlight: 100,
+	}
+}
+
+type inFlightEntry struct {
+	msg      Message
+	attempts int
+	deadline time.Time
+}
+
+type inFlightTracker struct {
+	config  AckConfig
+	entries map[string]*inFlightEntry
+	mu      sync.Mutex
+
+	// space is signalled whenever an ack frees a slot in a full window.
+	space chan struct{}
+}
+
+func newInFlightTracker(config AckConfig) *inFlightTracker {
+	defaults := DefaultAckConfig()
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = defaults.MaxInFlight
+	}
+
+	return &inFlightTracker{
+		config:  config,
+		entries: make(map[string]*inFlightEntry),
+		space:   make(chan struct{}, 1),
+	}
+}
+
+// checkInterval is how often the send loop looks for expired deliveries.
+func (t *inFlightTracker) checkInterval() time.Duration {
+	interval := t.config.Timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+func (t *inFlightTracker) full() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries) >= t.config.MaxInFlight
+}
+
+func (t *inFlightTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+// track records a first delivery attempt and returns the attempt number.
+func (t *inFlightTracker) track(msg Message) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, exists := t.entries[msg.Id]
+	if !exists {
+		entry = &inFlightEntry{msg: msg}
+		t.entries[msg.Id] = entry
+	}
+	entry.attempts++
+	entry.deadline = time.Now().Add(t.config.Timeout)
+	return entry.attempts
+}
+
+func (t *inFlightTracker) ack(id string) bool {
+	t.mu.Lock()
+	_, exists := t.entries[id]
+	delete(t.entries, id)
+	t.mu.Unlock()
+
+	if exists {
+		t.signalSpace()
+	}
+	return exists
+}
+
+func (t *inFlightTracker) ackOffset(offset uint64) bool {
+	t.mu.Lock()
+	found := false
+	for id, entry := range t.entries {
+		if entry.msg.Offset == offset {
+			delete(t.entries, id)
+			found = true
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	if found {
+		t.signalSpace()
+	}
+	return found
+}
+
+func (t *inFlightTracker) signalSpace() {
+	select {
+	case t.space <- struct{}{}:
+	default:
+	}
+}
+
+// expired splits overdue deliveries into those that should be redelivered
+// and those that have used up MaxAttempts; the latter are removed.
+func (t *inFlightTracker) expired(now time.Time) ([]inFlightEntry, []inFlightEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var retry, exhausted []inFlightEntry
+	for id, entry := range t.entries {
+		if now.Before(entry.deadline) {
+			continue
+		}
+		if entry.attempts >= t.config.MaxAttempts {
+			exhausted = append(exhausted, *entry)
+			delete(t.entries, id)
+			continue
+		}
+		retry = append(retry, *entry)
+	}
+
+	sort.Slice(retry, func(i, j int) bool { return retry[i].msg.Offset < retry[j].msg.Offset })
+	if len(exhausted) > 0 {
+		t.signalSpace()
+	}
+	return retry, exhausted
+}
+
+// pending removes and returns every unacked message, oldest first.
+func (t *inFlightTracker) pending() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msgs := make([]Message, 0, len(t.entries))
+	for _, entry := range t.entries {
+		msgs = append(msgs, entry.msg)
+	}
+	t.entries = make(map[string]*inFlightEntry)
+
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Offset < msgs[j].Offset })
+	return msgs
+}
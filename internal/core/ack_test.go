@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+)
+
+// sentTransport records every message it is handed, redeliveries included.
+type sentTransport struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (t *sentTransport) Send(ctx context.Context, msg Message) error {
+	t.mu.Lock()
+	t.sent = append(t.sent, msg)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *sentTransport) Name() string { return "sent" }
+
+func (t *sentTransport) messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Message(nil), t.sent...)
+}
+
+// waitFor returns the messages sent once there are at least n of them.
+func (t *sentTransport) waitFor(tb testing.TB, n int) []Message {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := t.messages(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tb.Fatalf("%d messages sent, want %d", len(t.messages()), n)
+	return nil
+}
+
+func ackSubscriber(t *testing.T, topic *Topic, config AckConfig) (*Subscriber, *sentTransport) {
+	t.Helper()
+	transport := &sentTransport{}
+	sub := NewSubscriber("acker", topic.tenantID, topic.name, transport, context.Background(), 16)
+	sub.SetAckMode(config)
+	if err := topic.Subscribe(sub); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Close)
+	return sub, transport
+}
+
+func publishN(t *testing.T, topic *Topic, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := topic.Publish(NewMessage(topic.name, topic.tenantID, json.RawMessage(`{}`))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAckRedeliversUntilAcked(t *testing.T) {
+	topic := NewTopic("jobs", "tenant", 16, 16, nil, nil)
+	sub, transport := ackSubscriber(t, topic, AckConfig{Timeout: 30 * time.Millisecond, MaxAttempts: 100})
+	publishN(t, topic, 1)
+
+	sent := transport.waitFor(t, 2)
+	for i, msg := range sent[:2] {
+		if msg.Offset != 0 || msg.Attempt != i+1 {
+			t.Fatalf("delivery %d was offset %d attempt %d, want offset 0 attempt %d", i, msg.Offset, msg.Attempt, i+1)
+		}
+	}
+
+	sub.handleAck(ClientFrame{Type: "ack", Offsets: []uint64{0}})
+	if n := sub.acks.count(); n != 0 {
+		t.Fatalf("%d messages in flight after the ack", n)
+	}
+	if sub.messagesAcked.Load() != 1 {
+		t.Fatalf("%d acks counted", sub.messagesAcked.Load())
+	}
+
+	// A redelivery already under way may still land; nothing after it.
+	time.Sleep(10 * time.Millisecond)
+	settled := len(transport.messages())
+	time.Sleep(150 * time.Millisecond)
+	if n := len(transport.messages()); n != settled {
+		t.Fatalf("%d deliveries after the ack, want none", n-settled)
+	}
+}
+
+func TestAckWindowBlocksPulls(t *testing.T) {
+	topic := NewTopic("jobs", "tenant", 16, 16, nil, nil)
+	sub, transport := ackSubscriber(t, topic, AckConfig{Timeout: time.Minute, MaxInFlight: 2})
+	publishN(t, topic, 5)
+
+	transport.waitFor(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(transport.messages()); n != 2 {
+		t.Fatalf("%d messages sent with a window of 2", n)
+	}
+
+	// Each ack opens the window by one.
+	sub.handleAck(ClientFrame{Type: "ack", Id: transport.messages()[0].Id})
+	sent := transport.waitFor(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(transport.messages()); n != 3 {
+		t.Fatalf("%d messages sent after one ack", n)
+	}
+	for i, msg := range sent {
+		if msg.Offset != uint64(i) || msg.Attempt != 1 {
+			t.Fatalf("delivery %d was offset %d attempt %d", i, msg.Offset, msg.Attempt)
+		}
+	}
+}
+
+func TestAckExhaustedIsDeadLettered(t *testing.T) {
+	tm := newTestTopicManager(t, TopicManagerConfig{DeadLetterTopics: []string{"jobs"}})
+	id := auth.Identity{TenantID: "tenant", Principal: "svc"}
+
+	dlq := &sentTransport{}
+	if err := tm.Subscribe(id, "jobs.dlq", "dlq", NewSubscriber("dlq", "tenant", "jobs.dlq", dlq, context.Background(), 16)); err != nil {
+		t.Fatal(err)
+	}
+	transport := &sentTransport{}
+	sub := NewSubscriber("acker", "tenant", "jobs", transport, context.Background(), 16)
+	sub.SetAckMode(AckConfig{Timeout: 20 * time.Millisecond, MaxAttempts: 2})
+	if err := tm.Subscribe(id, "jobs", "acker", sub); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tm.Publish(id, "jobs", NewMessage("jobs", "tenant", json.RawMessage(`{"n":1}`))); err != nil {
+		t.Fatal(err)
+	}
+
+	dead := dlq.waitFor(t, 1)[0]
+	var envelope struct {
+		Reason         string  `json:"reason"`
+		SubscriberID   string  `json:"subscriber_id"`
+		Attempts       int     `json:"attempts"`
+		OriginalTopic  string  `json:"original_topic"`
+		OriginalOffset uint64  `json:"original_offset"`
+		Message        Message `json:"message"`
+	}
+	if err := json.Unmarshal(dead.Data, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Reason != ReasonMaxRedelivery || envelope.SubscriberID != "acker" || envelope.Attempts != 2 ||
+		envelope.OriginalTopic != "jobs" || envelope.OriginalOffset != 0 {
+		t.Fatalf("dead letter %+v", envelope)
+	}
+	if string(envelope.Message.Data) != `{"n":1}` {
+		t.Fatalf("dead letter carries %s", envelope.Message.Data)
+	}
+
+	if sent := transport.messages(); len(sent) != 2 || sent[1].Attempt != 2 {
+		t.Fatalf("%d deliveries before giving up, want 2", len(sent))
+	}
+	if sub.acks.count() != 0 || sub.messagesAckExhausted.Load() != 1 {
+		t.Fatalf("%d in flight, %d exhausted", sub.acks.count(), sub.messagesAckExhausted.Load())
+	}
+}
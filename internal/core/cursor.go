@@ -0,0 +1,296 @@
+package core
+
+import "sync"
+
+// ringCursor is a broadcast subscriber's read position in its topic's ring.
+// Everything from next up to the ring's head, less any skipped ranges, is the
+// subscriber's backlog: the messages a private buffer would be holding. The
+// drop strategy keeps that backlog within the subscriber's limits by moving
+// next forward (dropping the oldest) or by skipping ranges it will never
+// read (dropping the newest). decided marks how far the backlog has been
+// checked, so a message once kept is never dropped later.
+//
+// When the ring has to evict messages the cursor has not read, a strategy
+// that keeps the oldest moves them into held, read before anything still in
+// the ring and charged to the global budget until sent.
+type ringCursor struct {
+	ring  *topicRing
+	start uint64
+
+	mu        sync.Mutex
+	next      uint64
+	decided   uint64
+	skips     []offsetRange
+	held      []Message
+	heldBytes int64
+}
+
+type offsetRange struct {
+	from, to uint64
+}
+
+func newRingCursor(ring *topicRing, from uint64) *ringCursor {
+	return &ringCursor{
+		ring:    ring,
+		start:   from,
+		next:    from,
+		decided: from,
+	}
+}
+
+// advance returns the next message to send and moves past it.
+func (c *ringCursor) advance() (Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.held) > 0 {
+		msg := c.held[0]
+		c.held[0] = Message{}
+		c.held = c.held[1:]
+		c.release(msg.encodedBytes())
+		return msg, true
+	}
+
+	if len(c.skips) > 0 && c.next >= c.skips[0].from {
+		c.next = max(c.next, c.skips[0].to)
+		c.skips = c.skips[1:]
+	}
+
+	msg, ok := c.ring.read(c.next)
+	if !ok {
+		return Message{}, false
+	}
+	c.next++
+	return msg, true
+}
+
+// hasNext reports whether a message is waiting at or beyond the cursor.
+func (c *ringCursor) hasNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.held) > 0 {
+		return true
+	}
+	_, head := c.ring.bounds()
+	if len(c.skips) > 0 && c.next >= c.skips[0].from {
+		return c.skips[0].to < head
+	}
+	return c.next < head
+}
+
+// backlog is the number and encoded size of the messages still to be read.
+func (c *ringCursor) backlog() (int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, head := c.ring.bounds()
+	slots, bytes := c.backlogBetween(c.next, head)
+	return slots + int64(len(c.held)), bytes + c.heldBytes
+}
+
+// backlogBetween counts [from, to) less skipped ranges. Callers hold mu.
+func (c *ringCursor) backlogBetween(from, to uint64) (int64, int64) {
+	if from >= to {
+		return 0, 0
+	}
+
+	slots := int64(to - from)
+	bytes := c.ring.bytesBetween(from, to)
+	for _, skip := range c.skips {
+		lo, hi := max(skip.from, from), min(skip.to, to)
+		if lo < hi {
+			slots -= int64(hi - lo)
+			bytes -= c.ring.bytesBetween(lo, hi)
+		}
+	}
+	return slots, bytes
+}
+
+// position is the next offset the cursor will read.
+func (c *ringCursor) position() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.next
+}
+
+// received is how many messages reached the ring since the cursor started.
+func (c *ringCursor) received() int64 {
+	_, head := c.ring.bounds()
+	if head < c.start {
+		return 0
+	}
+	return int64(head - c.start)
+}
+
+// enforce applies strategy to the backlog, held messages included, so it
+// holds at most slots messages and maxBytes. It returns how many messages
+// were lost and, when collect is set, which, to report as dead letters.
+func (c *ringCursor) enforce(strategy DropStrategy, slots, maxBytes int64, collect bool) ([]Message, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tail, head := c.ring.bounds()
+
+	var lost int64
+	if c.next < tail {
+		lost, _ = c.backlogBetween(c.next, tail)
+		c.next = tail
+		c.trimSkips()
+	}
+	c.decided = max(c.decided, c.next)
+	if c.decided >= head {
+		return nil, lost
+	}
+
+	var dropped []Message
+	switch strategy {
+	case DROP_OLDEST:
+		// Held messages are the oldest, so they go first; if the ring
+		// alone is over the limits, all of them do.
+		keepFrom := c.ring.fitBackward(head, slots, maxBytes)
+		drop := len(c.held)
+		if keepFrom <= c.next {
+			ringSlots, ringBytes := int64(head-c.next), c.ring.bytesBetween(c.next, head)
+			heldSlots, heldBytes := int64(len(c.held)), c.heldBytes
+			drop = 0
+			for heldSlots+ringSlots > slots || heldBytes+ringBytes > maxBytes {
+				// As with an empty buffer, one message is always kept.
+				if heldSlots == 0 || (heldSlots == 1 && ringSlots == 0) {
+					break
+				}
+				heldSlots--
+				heldBytes -= c.held[drop].encodedBytes()
+				drop++
+			}
+		}
+		if drop > 0 {
+			lost += int64(drop)
+			held := c.dropHeld(drop)
+			if collect {
+				dropped = held
+			}
+		}
+		if keepFrom > c.next {
+			lost += int64(keepFrom - c.next)
+			if collect {
+				dropped = append(dropped, c.ring.messages(c.next, keepFrom)...)
+			}
+			c.next = keepFrom
+		}
+
+	default:
+		keptSlots, keptBytes := c.backlogBetween(c.next, c.decided)
+		keptSlots += int64(len(c.held))
+		keptBytes += c.heldBytes
+		keepTo := c.ring.fitForward(c.decided, slots-keptSlots, maxBytes-keptBytes, keptSlots == 0)
+		if keepTo < head {
+			lost += int64(head - keepTo)
+			if collect {
+				dropped = c.ring.messages(keepTo, head)
+			}
+			c.skip(keepTo, head)
+		}
+	}
+
+	c.decided = head
+	return dropped, lost
+}
+
+// overrun runs before the ring evicts everything below evictTo, moving what
+// the cursor still needs there into held. The caller applies the drop
+// strategy first, so held never takes the backlog past its limits.
+func (c *ringCursor) overrun(evictTo uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.next >= evictTo {
+		return
+	}
+
+	from := c.next
+	for _, skip := range c.skips {
+		if skip.from >= evictTo {
+			break
+		}
+		c.hold(c.ring.messages(from, skip.from))
+		from = max(from, skip.to)
+	}
+	if from < evictTo {
+		c.hold(c.ring.messages(from, evictTo))
+	}
+
+	c.next = evictTo
+	c.decided = max(c.decided, c.next)
+	c.trimSkips()
+}
+
+// hold keeps msgs, charging them to the global budget. Callers hold mu.
+func (c *ringCursor) hold(msgs []Message) {
+	for _, msg := range msgs {
+		size := msg.encodedBytes()
+		c.held = append(c.held, msg)
+		c.heldBytes += size
+		if c.ring.budget != nil {
+			c.ring.budget.Charge(size)
+		}
+	}
+}
+
+// dropHeld removes the oldest n held messages and returns them. Callers
+// hold mu.
+func (c *ringCursor) dropHeld(n int) []Message {
+	dropped := make([]Message, n)
+	copy(dropped, c.held)
+	for i := 0; i < n; i++ {
+		c.release(c.held[i].encodedBytes())
+		c.held[i] = Message{}
+	}
+	c.held = c.held[n:]
+	return dropped
+}
+
+// heldSize is the encoded size of the messages kept in held.
+func (c *ringCursor) heldSize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.heldBytes
+}
+
+// discard drops everything held, for a subscriber that is going away.
+func (c *ringCursor) discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.release(c.heldBytes)
+	c.held = nil
+}
+
+// release hands back the budget of held messages that have left. Callers
+// hold mu.
+func (c *ringCursor) release(size int64) {
+	c.heldBytes -= size
+	if c.ring.budget != nil {
+		c.ring.budget.Release(size)
+	}
+}
+
+// skip marks [from, to) as never to be read. Callers hold mu.
+func (c *ringCursor) skip(from, to uint64) {
+	if n := len(c.skips); n > 0 && c.skips[n-1].to == from {
+		c.skips[n-1].to = to
+		return
+	}
+	c.skips = append(c.skips, offsetRange{from: from, to: to})
+}
+
+// trimSkips forgets skipped ranges the cursor has already passed. Callers
+// hold mu.
+func (c *ringCursor) trimSkips() {
+	for len(c.skips) > 0 && c.skips[0].to <= c.next {
+		c.skips = c.skips[1:]
+	}
+	if len(c.skips) > 0 && c.skips[0].from < c.next {
+		c.skips[0].from = c.next
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const DeadLetterSuffix = ".dlq"
+
+const (
+	ReasonDroppedOldest  = "dropped_oldest"
+	ReasonDroppedNewest  = "dropped_newest"
+	ReasonCircuitBreaker = "circuit_breaker"
+	ReasonMaxRedelivery  = "max_redelivery"
+	ReasonUndeliverable  = "undeliverable"
+)
+
+// DeadLetter describes a message that was lost on its way to a subscriber.
+type DeadLetter struct {
+	Message      Message
+	Reason       string
+	SubscriberID string
+	Attempts     int
+}
+
+func DeadLetterTopic(topic string) string {
+	return topic + DeadLetterSuffix
+}
+
+func IsDeadLetterTopic(topic string) bool {
+	return len(topic) > len(DeadLetterSuffix) && topic[len(topic)-len(DeadLetterSuffix):] == DeadLetterSuffix
+}
+
+// toMessage wraps the lost message in an envelope published on the
+// dead-letter topic.
+func (dl DeadLetter) toMessage() (Message, error) {
+	topic := DeadLetterTopic(dl.Message.Topic)
+
+	data, err := json.Marshal(map[string]interface{}{
+		"reason":           dl.Reason,
+		"subscriber_id":    dl.SubscriberID,
+		"attempts":         dl.Attempts,
+		"original_topic":   dl.Message.Topic,
+		"original_offset":  dl.Message.Offset,
+		"dead_lettered_at": time.Now(),
+		"message":          dl.Message,
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	// The original headers come along, so correlation IDs and the like
+	// still apply to the dead letter.
+	msg := NewMessage(topic, dl.Message.TenantID, data)
+	msg.Headers = dl.Message.Headers
+	return msg, nil
+}
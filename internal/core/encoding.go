@@ -0,0 +1,299 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+	"github.com/AadityaChoubey68/clevr-live/internal/filter"
+)
+
+// Wire formats a message is cached in for delivery. Binary codecs cache
+// their envelope under the codec's name.
+const (
+	wireJSON   = "json"
+	wireSSE    = "sse"
+	wireNDJSON = "ndjson"
+
+	// wireSSETopic is an SSE event whose id is qualified by its topic, for
+	// wildcard subscriptions, where offsets of different topics interleave.
+	wireSSETopic = "sse-topic"
+
+	// wireBinary is the header of a binary WebSocket frame and wirePayload
+	// the decoded payload that follows it.
+	wireBinary  = "binary"
+	wirePayload = "payload"
+)
+
+// encodedForms holds a message's encodings by wire format. A message is
+// published once and delivered to many subscribers, so each format is
+// produced on first use and every later delivery writes the same bytes.
+// Cached bytes are shared and must not be modified.
+type encodedForms struct {
+	mu    sync.Mutex
+	forms map[string][]byte
+
+	// document is the message as subscription filters read it, built on
+	// first use like the encodings.
+	document *filter.Document
+
+	// projections are the payload as each distinct projection shapes it,
+	// keyed by Projection.key, each with encodings of its own.
+	projections map[string]*projectedForms
+}
+
+// projectedForms is a message's payload trimmed by one projection, and that
+// shape's encodings.
+type projectedForms struct {
+	data  json.RawMessage
+	forms *encodedForms
+}
+
+func newEncodedForms() *encodedForms {
+	return &encodedForms{forms: make(map[string][]byte, 1)}
+}
+
+// withJSON seeds the cache with an encoding that is already at hand, such
+// as a record read back from the log.
+func (e *encodedForms) withJSON(data []byte) *encodedForms {
+	e.forms[wireJSON] = data
+	return e
+}
+
+func (e *encodedForms) get(format string, encode func() ([]byte, error)) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if data, ok := e.forms[format]; ok {
+		return data, nil
+	}
+	data, err := encode()
+	if err != nil {
+		return nil, err
+	}
+	e.forms[format] = data
+	return data, nil
+}
+
+// projected returns the payload as the projection named key shapes it,
+// projecting it on first use.
+func (e *encodedForms) projected(key string, project func() (json.RawMessage, error)) (*projectedForms, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if shaped, ok := e.projections[key]; ok {
+		return shaped, nil
+	}
+	data, err := project()
+	if err != nil {
+		return nil, err
+	}
+	if e.projections == nil {
+		e.projections = make(map[string]*projectedForms, 1)
+	}
+	shaped := &projectedForms{data: data, forms: newEncodedForms()}
+	e.projections[key] = shaped
+	return shaped, nil
+}
+
+// filterDocument is the message as subscription filters see it. Copies of a
+// published message share one, so its payload is decoded at most once
+// however many subscribers filter it.
+func (m Message) filterDocument() *filter.Document {
+	build := func() *filter.Document {
+		var data json.RawMessage
+		if m.IsJSON() {
+			data = m.Data
+		}
+		return filter.NewDocument(m.Topic, m.ContentType, m.Headers, data)
+	}
+	if m.encoded == nil {
+		return build()
+	}
+
+	m.encoded.mu.Lock()
+	defer m.encoded.mu.Unlock()
+	if m.encoded.document == nil {
+		m.encoded.document = build()
+	}
+	return m.encoded.document
+}
+
+// wireMessage has Message's fields without its methods, so marshaling it
+// does not come back through MarshalJSON.
+type wireMessage Message
+
+// MarshalJSON returns the cached encoding, so a message embedded in a
+// larger document, such as a poll response, is not encoded again either.
+func (m Message) MarshalJSON() ([]byte, error) {
+	return m.encodeJSON()
+}
+
+// encodeJSON is the message's JSON envelope. The shared part is encoded
+// once; the per-delivery fields, attempt and subscription, are appended to
+// it, which gives the same bytes as encoding the whole struct since they
+// are its last fields.
+func (m Message) encodeJSON() ([]byte, error) {
+	base, err := m.baseJSON()
+	if err != nil {
+		return nil, err
+	}
+	return m.withDeliveryFields(base)
+}
+
+// withDeliveryFields appends attempt and subscription to an encoded object
+// that ends with the fields they follow.
+func (m Message) withDeliveryFields(base []byte) ([]byte, error) {
+	return codec.JSON.WithDelivery(base, m.Attempt, m.Subscription)
+}
+
+// baseJSON encodes everything but the per-delivery fields.
+func (m Message) baseJSON() ([]byte, error) {
+	encode := func() ([]byte, error) {
+		shared := wireMessage(m)
+		shared.Attempt = 0
+		shared.Subscription = ""
+		shared.encoded = nil
+		return json.Marshal(shared)
+	}
+	if m.encoded == nil {
+		return encode()
+	}
+	return m.encoded.get(wireJSON, encode)
+}
+
+// encodeFramed is the JSON envelope wrapped for a streaming transport:
+// an SSE event carrying the offset (or topic:offset) as its id, or an
+// NDJSON line. Frames
+// without per-delivery fields are cached like the envelope itself.
+func (m Message) encodeFramed(format string) ([]byte, error) {
+	data, err := m.encodeJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	frame := func() ([]byte, error) {
+		switch format {
+		case wireSSE, wireSSETopic:
+			framed := make([]byte, 0, len(data)+len(m.Topic)+32)
+			framed = append(framed, "id: "...)
+			if format == wireSSETopic {
+				framed = append(framed, m.Topic...)
+				framed = append(framed, ':')
+			}
+			framed = strconv.AppendUint(framed, m.Offset, 10)
+			framed = append(framed, "\ndata: "...)
+			framed = append(framed, data...)
+			return append(framed, "\n\n"...), nil
+		default:
+			framed := make([]byte, 0, len(data)+1)
+			framed = append(framed, data...)
+			return append(framed, '\n'), nil
+		}
+	}
+
+	if m.encoded == nil || m.Attempt != 0 || m.Subscription != "" {
+		return frame()
+	}
+	return m.encoded.get(format, frame)
+}
+
+// binaryHeader describes the payload of a binary WebSocket frame: the
+// message envelope without data.
+type binaryHeader struct {
+	Id          string            `json:"id"`
+	Offset      uint64            `json:"offset"`
+	Topic       string            `json:"topic"`
+	TenantID    string            `json:"tenant_id"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+// encodeBinaryFrame lays a message out for a binary WebSocket frame: a
+// big-endian uint32 header length, the JSON header, then the raw payload.
+func (m Message) encodeBinaryFrame() ([]byte, error) {
+	encodeHeader := func() ([]byte, error) {
+		return json.Marshal(binaryHeader{
+			Id:          m.Id,
+			Offset:      m.Offset,
+			Topic:       m.Topic,
+			TenantID:    m.TenantID,
+			ContentType: m.ContentType,
+			Headers:     m.Headers,
+			Timestamp:   m.Timestamp,
+		})
+	}
+	header, payload := encodeHeader, m.Payload
+	if m.encoded != nil {
+		header = func() ([]byte, error) { return m.encoded.get(wireBinary, encodeHeader) }
+		payload = func() ([]byte, error) { return m.encoded.get(wirePayload, m.Payload) }
+	}
+
+	base, err := header()
+	if err != nil {
+		return nil, err
+	}
+	head, err := m.withDeliveryFields(base)
+	if err != nil {
+		return nil, err
+	}
+	body, err := payload()
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 4, 4+len(head)+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(head)))
+	frame = append(frame, head...)
+	return append(frame, body...), nil
+}
+
+// encodeWith is the message's envelope in a negotiated codec. As with JSON,
+// the shared part is encoded once per message and the per-delivery fields
+// are added to it.
+func (m Message) encodeWith(c codec.Codec) ([]byte, error) {
+	if c == codec.JSON {
+		return m.encodeJSON()
+	}
+
+	encode := func() ([]byte, error) {
+		envelope, err := m.envelope()
+		if err != nil {
+			return nil, err
+		}
+		return c.MarshalEnvelope(envelope)
+	}
+	var base []byte
+	var err error
+	if m.encoded == nil {
+		base, err = encode()
+	} else {
+		base, err = m.encoded.get(c.Name(), encode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.WithDelivery(base, m.Attempt, m.Subscription)
+}
+
+func (m Message) envelope() (codec.Envelope, error) {
+	data, err := m.Payload()
+	if err != nil {
+		return codec.Envelope{}, err
+	}
+	return codec.Envelope{
+		ID:          m.Id,
+		Offset:      m.Offset,
+		Topic:       m.Topic,
+		TenantID:    m.TenantID,
+		ContentType: m.ContentType,
+		Headers:     m.Headers,
+		Data:        data,
+		JSON:        m.IsJSON(),
+		Timestamp:   m.Timestamp,
+	}, nil
+}
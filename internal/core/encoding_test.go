@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+)
+
+// publishedMessage is a message as Topic.Publish leaves it, with a cache
+// for its encodings.
+func publishedMessage(msg Message) Message {
+	msg.Offset = 7
+	msg.Headers = map[string]string{"region": "eu"}
+	msg.encoded = newEncodedForms()
+	return msg
+}
+
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+func TestEncodedFormsAreCachedPerForm(t *testing.T) {
+	msg := publishedMessage(NewMessage("jobs", "tenant", json.RawMessage(`{"id":1,"name":"build"}`)))
+	uncached := msg
+	uncached.encoded = nil
+
+	type form struct {
+		name   string
+		encode func(Message) ([]byte, error)
+	}
+	forms := []form{
+		{"json", Message.encodeJSON},
+		{"sse", func(m Message) ([]byte, error) { return m.encodeFramed(wireSSE) }},
+		{"sse-topic", func(m Message) ([]byte, error) { return m.encodeFramed(wireSSETopic) }},
+		{"ndjson", func(m Message) ([]byte, error) { return m.encodeFramed(wireNDJSON) }},
+	}
+	for _, c := range []codec.Codec{codec.MsgPack, codec.CBOR, codec.Proto} {
+		forms = append(forms, form{c.Name(), func(m Message) ([]byte, error) { return m.encodeWith(c) }})
+	}
+
+	encoded := make(map[string][]byte)
+	for _, form := range forms {
+		first, err := form.encode(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", form.name, err)
+		}
+		want, err := form.encode(uncached)
+		if err != nil {
+			t.Fatalf("%s uncached: %v", form.name, err)
+		}
+		if !bytes.Equal(first, want) {
+			t.Fatalf("%s: cached %q, uncached %q", form.name, first, want)
+		}
+
+		// A copy of the message, as every subscriber gets, is handed the
+		// very bytes the first delivery produced.
+		again, err := form.encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameBytes(first, again) {
+			t.Fatalf("%s encoded again", form.name)
+		}
+		encoded[form.name] = first
+	}
+
+	// A delivery with per-delivery fields gets its own bytes and leaves the
+	// shared ones as they were.
+	redelivery := msg
+	redelivery.Attempt = 2
+	redelivery.Subscription = "sub-1"
+	for _, form := range forms {
+		data, err := form.encode(redelivery)
+		if err != nil {
+			t.Fatalf("%s redelivery: %v", form.name, err)
+		}
+		if bytes.Equal(data, encoded[form.name]) {
+			t.Fatalf("%s redelivery has no delivery fields", form.name)
+		}
+		shared, err := form.encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameBytes(shared, encoded[form.name]) || !bytes.Equal(shared, encoded[form.name]) {
+			t.Fatalf("%s: shared bytes changed after a redelivery: %q", form.name, shared)
+		}
+	}
+}
+
+func TestBinaryFrameIsBuiltFromCachedParts(t *testing.T) {
+	msg := publishedMessage(NewBinaryMessage("jobs", "tenant", "application/octet-stream", []byte{0, 1, 2, 0xff}))
+	uncached := msg
+	uncached.encoded = nil
+
+	first, err := msg.encodeBinaryFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := uncached.encodeBinaryFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := msg.encodeBinaryFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, want) || !bytes.Equal(again, want) {
+		t.Fatalf("frames %q and %q, want %q", first, again, want)
+	}
+
+	header := msg.encoded.forms[wireBinary]
+	payload := msg.encoded.forms[wirePayload]
+	if header == nil || !bytes.Equal(payload, []byte{0, 1, 2, 0xff}) {
+		t.Fatalf("header %q and payload %q cached", header, payload)
+	}
+}
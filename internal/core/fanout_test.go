@@ -0,0 +1,146 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// Publish cost on one topic at these subscriber counts, through the shared
+// ring and through a goroutine-per-subscriber fan-out over today's queues:
+//
+//	go test -run '^$' -bench Publish ./internal/core
+var benchSubscriberCounts = []int{1, 100, 10000}
+
+const benchBufferSize = 1000
+
+// discardTransport accepts every message, so only fan-out is measured.
+type discardTransport struct{}
+
+func (discardTransport) Send(ctx context.Context, msg Message) error { return nil }
+func (discardTransport) Name() string                                { return "discard" }
+
+func benchMessage() Message {
+	return NewMessage("bench", "bench", json.RawMessage(`{"symbol":"ACME","price":101.25}`))
+}
+
+// quietly runs setup with stdout discarded, since topics log every
+// subscribe.
+func quietly(b *testing.B, setup func()) {
+	b.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	setup()
+}
+
+func BenchmarkPublishRing(b *testing.B) {
+	for _, n := range benchSubscriberCounts {
+		b.Run(fmt.Sprintf("subscribers=%d", n), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			topic := NewTopic("bench", "bench", 100, DefaultRingSize, nil, nil)
+			quietly(b, func() {
+				for i := 0; i < n; i++ {
+					sub := NewSubscriber(fmt.Sprintf("sub-%d", i), "bench", "bench", discardTransport{}, ctx, benchBufferSize)
+					sub.SetStartPosition(StartPosition{Mode: StartLatest})
+					if err := topic.Subscribe(sub); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+
+			msg := benchMessage()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := topic.Publish(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			for _, sub := range topic.getSubscribersSnapshot() {
+				sub.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkPublishGoroutines(b *testing.B) {
+	for _, n := range benchSubscriberCounts {
+		b.Run(fmt.Sprintf("subscribers=%d", n), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Started directly rather than through the topic, these
+			// subscribers keep a queue of their own instead of reading
+			// the ring.
+			topic := NewTopic("bench", "bench", 100, DefaultRingSize, nil, nil)
+			subscribers := make([]*Subscriber, n)
+			for i := range subscribers {
+				subscribers[i] = NewSubscriber(fmt.Sprintf("sub-%d", i), "bench", "bench", discardTransport{}, ctx, benchBufferSize)
+				subscribers[i].Start(nil)
+			}
+
+			msg := benchMessage()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := topic.goroutinePublish(subscribers, msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			for _, sub := range subscribers {
+				sub.Close()
+			}
+		})
+	}
+}
+
+// goroutinePublish does Topic.Publish's offset, log and cache bookkeeping,
+// then hands the message to each subscriber's own queue from a goroutine of
+// its own and waits for all of them. That is the fan-out the ring replaced,
+// but over the current queue, encoding and drop handling, so the two
+// benchmarks differ only in how a message reaches its subscribers; it is
+// not the old code path.
+func (t *Topic) goroutinePublish(subscribers []*Subscriber, msg Message) (uint64, error) {
+	t.publishMu.Lock()
+	defer t.publishMu.Unlock()
+
+	msg.Offset = t.nextOffset.Load()
+	msg.encoded = newEncodedForms()
+	if err := t.appendToLog(&msg); err != nil {
+		return 0, err
+	}
+	t.nextOffset.Store(msg.Offset + 1)
+	if msg.encodedSize == 0 {
+		msg.encodedSize = msg.encodedBytes()
+	}
+
+	t.recentCache.Add(msg)
+	t.messagesPublished.Add(1)
+
+	var wg sync.WaitGroup
+	for _, sub := range subscribers {
+		wg.Add(1)
+		go func(s *Subscriber) {
+			defer wg.Done()
+			s.SendMessages(msg)
+		}(sub)
+	}
+	wg.Wait()
+	return msg.Offset, nil
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+	"github.com/coder/websocket"
+)
+
+// ClientFrame is a JSON control frame sent by a client over its WebSocket.
+// A connection that negotiated a binary codec may send the same frames
+// encoded with it in binary WebSocket frames.
+//
+//	{"type":"auth","token":"eyJhbGciOi..."}
+//	{"type":"subscribe","request_id":"1","topic":"orders.>","from":"latest"}
+//	{"type":"subscribe","request_id":"8","topic":"orders.eu","filter":"data.amount > 100"}
+//	{"type":"subscribe","request_id":"9","topic":"orders.eu","include":["id","total","status"]}
+//	{"type":"unsubscribe","request_id":"2","subscription":"s-1"}
+//	{"type":"ack","subscription":"s-1","offsets":[41,42]}
+//	{"type":"ping","request_id":"3"}
+//	{"type":"publish","request_id":"4","topic":"orders.eu","data":{"id":7}}
+//	{"type":"publish","request_id":"5","messages":[{"topic":"a","data":{}}]}
+//	{"type":"publish","request_id":"6","topic":"img","content_type":"image/png","data":"iVBORw0..."}
+//	{"type":"publish","request_id":"7","topic":"orders.eu","headers":{"correlation-id":"c-91"},"data":{"id":8}}
+type ClientFrame struct {
+	Type         string `json:"type"`
+	RequestID    string `json:"request_id,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+	Token        string `json:"token,omitempty"`
+
+	Id      string   `json:"id,omitempty"`
+	Ids     []string `json:"ids,omitempty"`
+	Offset  *uint64  `json:"offset,omitempty"`
+	Offsets []uint64 `json:"offsets,omitempty"`
+
+	Topic        string   `json:"topic,omitempty"`
+	From         string   `json:"from,omitempty"`
+	FromOffset   *uint64  `json:"from_offset,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	Balance      string   `json:"balance,omitempty"`
+	Drop         string   `json:"drop,omitempty"`
+	Ack          bool     `json:"ack,omitempty"`
+	AckTimeoutMs int      `json:"ack_timeout_ms,omitempty"`
+	MaxAttempts  int      `json:"max_attempts,omitempty"`
+	MaxInFlight  int      `json:"max_in_flight,omitempty"`
+	Payload      string   `json:"payload,omitempty"`
+	Filter       string   `json:"filter,omitempty"`
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Data        json.RawMessage   `json:"data,omitempty"`
+	Messages    []PublishItem     `json:"messages,omitempty"`
+}
+
+// PublishItem is one message of a batched publish frame.
+type PublishItem struct {
+	Topic       string            `json:"topic"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Data        json.RawMessage   `json:"data"`
+}
+
+// PublishResult reports the outcome of one published message.
+type PublishResult struct {
+	Success      bool    `json:"success"`
+	MessageId    string  `json:"message_id,omitempty"`
+	Offset       *uint64 `json:"offset,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	RetryAfterMs int64   `json:"retry_after_ms,omitempty"`
+}
+
+// ServerFrame answers a control frame.
+type ServerFrame struct {
+	Type         string `json:"type"`
+	RequestID    string `json:"request_id,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+	Topic        string `json:"topic,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	MessageId    string          `json:"message_id,omitempty"`
+	Offset       *uint64         `json:"offset,omitempty"`
+	Results      []PublishResult `json:"results,omitempty"`
+	RetryAfterMs int64           `json:"retry_after_ms,omitempty"`
+
+	// Set on gap frames: the first offset a replay asked for, and the
+	// oldest one still held, which the replay continues from.
+	FromOffset  *uint64 `json:"from_offset,omitempty"`
+	FirstOffset *uint64 `json:"first_offset,omitempty"`
+
+	// Set on throttle frames: the publish rate currently admitted, and
+	// whether the server is refusing publishes outright.
+	AdmissionRate float64 `json:"admission_rate,omitempty"`
+	Overloaded    bool    `json:"overloaded,omitempty"`
+}
+
+func (f ClientFrame) ids() []string {
+	if f.Id == "" {
+		return f.Ids
+	}
+	return append([]string{f.Id}, f.Ids...)
+}
+
+func (f ClientFrame) offsets() []uint64 {
+	if f.Offset == nil {
+		return f.Offsets
+	}
+	return append([]uint64{*f.Offset}, f.Offsets...)
+}
+
+// subscribeRequest reads the subscribe fields of a frame.
+func (f ClientFrame) subscribeRequest() subscribeRequest {
+	return subscribeRequest{
+		Topic:        f.Topic,
+		From:         f.From,
+		FromOffset:   f.FromOffset,
+		Group:        f.Group,
+		Balance:      f.Balance,
+		Drop:         f.Drop,
+		Ack:          f.Ack,
+		AckTimeoutMs: f.AckTimeoutMs,
+		MaxAttempts:  f.MaxAttempts,
+		MaxInFlight:  f.MaxInFlight,
+		Payload:      f.Payload,
+		Filter:       f.Filter,
+		Include:      f.Include,
+		Exclude:      f.Exclude,
+	}
+}
+
+// ReadClientFrame reads the next control frame from conn.
+func ReadClientFrame(ctx context.Context, conn *websocket.Conn, c codec.Codec) (ClientFrame, error) {
+	msgType, data, err := conn.Read(ctx)
+	if err != nil {
+		return ClientFrame{}, err
+	}
+	return decodeClientFrame(msgType, data, c)
+}
+
+// decodeClientFrame decodes a text frame as JSON and a binary one with the
+// connection's codec.
+func decodeClientFrame(msgType websocket.MessageType, data []byte, c codec.Codec) (ClientFrame, error) {
+	if msgType == websocket.MessageText {
+		c = codec.JSON
+	}
+
+	var frame ClientFrame
+	err := codec.Decode(c, data, &frame)
+	return frame, err
+}
+
+// WriteServerFrame writes frame to conn in the connection's codec.
+func WriteServerFrame(ctx context.Context, conn *websocket.Conn, c codec.Codec, frame ServerFrame) error {
+	data, err := codec.Encode(c, frame)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, frameType(c), data)
+}
+
+func frameType(c codec.Codec) websocket.MessageType {
+	if c.Binary() {
+		return websocket.MessageBinary
+	}
+	return websocket.MessageText
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type GroupBalance int
+
+const (
+	ROUND_ROBIN GroupBalance = iota
+	LEAST_LOADED
+)
+
+func ParseGroupBalance(value string) (GroupBalance, error) {
+	switch value {
+	case "", "round_robin":
+		return ROUND_ROBIN, nil
+	case "least_loaded":
+		return LEAST_LOADED, nil
+	default:
+		return ROUND_ROBIN, fmt.Errorf("invalid balance %q: expected round_robin or least_loaded", value)
+	}
+}
+
+func (b GroupBalance) String() string {
+	switch b {
+	case LEAST_LOADED:
+		return "least_loaded"
+	default:
+		return "round_robin"
+	}
+}
+
+// consumerGroup is a shared subscription: each message published to the topic
+// goes to exactly one of its members.
+type consumerGroup struct {
+	name    string
+	balance GroupBalance
+
+	members []*Subscriber
+	next    int
+	mu      sync.Mutex
+
+	delivered   atomic.Int64
+	undelivered atomic.Int64
+	rebalances  atomic.Int64
+}
+
+func newConsumerGroup(name string, balance GroupBalance) *consumerGroup {
+	return &consumerGroup{
+		name:    name,
+		balance: balance,
+	}
+}
+
+func (g *consumerGroup) add(sub *Subscriber) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.members = append(g.members, sub)
+	g.rebalances.Add(1)
+}
+
+func (g *consumerGroup) remove(subscriberID string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, member := range g.members {
+		if member.ID == subscriberID {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			if g.next > i {
+				g.next--
+			}
+			break
+		}
+	}
+
+	g.rebalances.Add(1)
+	return len(g.members)
+}
+
+// candidates returns the live members in the order they should be tried.
+func (g *consumerGroup) candidates() []*Subscriber {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := len(g.members)
+	if n == 0 {
+		return nil
+	}
+
+	ordered := make([]*Subscriber, 0, n)
+
+	switch g.balance {
+	case LEAST_LOADED:
+		for _, member := range g.members {
+			if !member.IsClosed() {
+				ordered = append(ordered, member)
+			}
+		}
+		for i := 1; i < len(ordered); i++ {
+			for j := i; j > 0 && ordered[j].BufferFill() < ordered[j-1].BufferFill(); j-- {
+				ordered[j], ordered[j-1] = ordered[j-1], ordered[j]
+			}
+		}
+
+	default:
+		start := g.next % n
+		g.next = (start + 1) % n
+		for i := 0; i < n; i++ {
+			member := g.members[(start+i)%n]
+			if !member.IsClosed() {
+				ordered = append(ordered, member)
+			}
+		}
+	}
+
+	return ordered
+}
+
+// deliver hands msg to the first member with buffer room. If every member is
+// full, the preferred member's drop strategy decides the message's fate.
+func (g *consumerGroup) deliver(msg Message) error {
+	candidates := g.candidates()
+	if len(candidates) == 0 {
+		g.undelivered.Add(1)
+		return fmt.Errorf("group %s has no live members", g.name)
+	}
+
+	for _, member := range candidates {
+		if member.trySend(msg) {
+			g.delivered.Add(1)
+			return nil
+		}
+	}
+
+	if err := candidates[0].SendMessages(msg); err != nil {
+		g.undelivered.Add(1)
+		return fmt.Errorf("group %s: %w", g.name, err)
+	}
+
+	g.delivered.Add(1)
+	return nil
+}
+
+func (g *consumerGroup) GetMetrics() map[string]interface{} {
+	g.mu.Lock()
+	members := make([]string, 0, len(g.members))
+	for _, member := range g.members {
+		members = append(members, member.ID)
+	}
+	g.mu.Unlock()
+
+	return map[string]interface{}{
+		"name":        g.name,
+		"balance":     g.balance.String(),
+		"members":     members,
+		"delivered":   g.delivered.Load(),
+		"undelivered": g.undelivered.Load(),
+		"rebalances":  g.rebalances.Load(),
+	}
+}
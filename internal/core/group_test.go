@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the offsets it delivers, taking delay over
+// each, and fails sends whose context ends first.
+type recordingTransport struct {
+	delay time.Duration
+
+	mu        sync.Mutex
+	delivered []uint64
+}
+
+func (t *recordingTransport) Send(ctx context.Context, msg Message) error {
+	select {
+	case <-time.After(t.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	t.mu.Lock()
+	t.delivered = append(t.delivered, msg.Offset)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *recordingTransport) Name() string { return "recording" }
+
+func (t *recordingTransport) offsets() []uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]uint64(nil), t.delivered...)
+}
+
+// TestGroupRebalanceDeliversEachOffsetOnce unsubscribes a slow group member
+// while messages are still being published to the group. Whatever it had
+// taken but not sent must reach the other member, and nothing may arrive
+// twice.
+func TestGroupRebalanceDeliversEachOffsetOnce(t *testing.T) {
+	const published = 2000
+
+	topic := NewTopic("jobs", "tenant", 16, 16, nil, nil)
+	slow := &recordingTransport{delay: 200 * time.Microsecond}
+	fast := &recordingTransport{}
+
+	leaving := NewSubscriber("leaving", "tenant", "jobs", slow, context.Background(), published)
+	leaving.SetGroup("workers", ROUND_ROBIN)
+	staying := NewSubscriber("staying", "tenant", "jobs", fast, context.Background(), published)
+	staying.SetGroup("workers", ROUND_ROBIN)
+	for _, sub := range []*Subscriber{leaving, staying} {
+		if err := topic.Subscribe(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer staying.Close()
+
+	msg := NewMessage("jobs", "tenant", json.RawMessage(`{"n":1}`))
+	for i := 0; i < published; i++ {
+		if i == published/4 {
+			// Leave mid-send, with a backlog still buffered.
+			for len(slow.offsets()) < 5 {
+				time.Sleep(100 * time.Microsecond)
+			}
+			go topic.Unsubscribe(leaving.ID)
+		}
+		if _, err := topic.Publish(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func() int { return len(slow.offsets()) + len(fast.offsets()) }
+	deadline := time.Now().Add(10 * time.Second)
+	for count() < published && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	seen := make(map[uint64]int, published)
+	for _, offset := range append(slow.offsets(), fast.offsets()...) {
+		seen[offset]++
+	}
+	for offset := uint64(0); offset < published; offset++ {
+		if n := seen[offset]; n != 1 {
+			t.Errorf("offset %d delivered %d times", offset, n)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ReservedHeaderPrefix marks headers only the server may set. Publishers
+// cannot send them, so subscribers can trust them.
+const ReservedHeaderPrefix = "clevr-"
+
+// Headers the server sets on every message a client publishes.
+const (
+	// HeaderPublisher is the principal that published the message.
+	HeaderPublisher = ReservedHeaderPrefix + "publisher"
+	// HeaderReceivedAt is when the server accepted the publish, in
+	// RFC 3339 with nanoseconds.
+	HeaderReceivedAt = ReservedHeaderPrefix + "received-at"
+)
+
+// Header limits, so metadata cannot outgrow the payload it describes.
+const (
+	MaxHeaders          = 64
+	MaxHeaderNameBytes  = 128
+	MaxHeaderValueBytes = 4096
+)
+
+// NormalizeHeaders validates publisher headers and returns them with
+// lowercase names, in a map of their own. Header names are case
+// insensitive, as in HTTP.
+func NormalizeHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(headers) > MaxHeaders {
+		return nil, fmt.Errorf("at most %d headers are allowed", MaxHeaders)
+	}
+
+	normalized := make(map[string]string, len(headers))
+	for name, value := range headers {
+		key := strings.ToLower(name)
+		switch {
+		case key == "":
+			return nil, fmt.Errorf("header names cannot be empty")
+		case len(key) > MaxHeaderNameBytes:
+			return nil, fmt.Errorf("header %q is longer than %d bytes", name, MaxHeaderNameBytes)
+		case len(value) > MaxHeaderValueBytes:
+			return nil, fmt.Errorf("header %q value is longer than %d bytes", name, MaxHeaderValueBytes)
+		case strings.HasPrefix(key, ReservedHeaderPrefix):
+			return nil, fmt.Errorf("header %q uses the reserved prefix %s", name, ReservedHeaderPrefix)
+		}
+		if _, exists := normalized[key]; exists {
+			return nil, fmt.Errorf("header %q is given more than once", name)
+		}
+		normalized[key] = value
+	}
+	return normalized, nil
+}
+
+// stampHeaders adds the server's headers to a copy of the message's own;
+// the map is shared by every copy of a published message, so it is never
+// modified in place.
+func stampHeaders(msg Message, principal string, receivedAt time.Time) Message {
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for name, value := range msg.Headers {
+		if !strings.HasPrefix(strings.ToLower(name), ReservedHeaderPrefix) {
+			headers[name] = value
+		}
+	}
+	headers[HeaderPublisher] = principal
+	headers[HeaderReceivedAt] = receivedAt.UTC().Format(time.RFC3339Nano)
+
+	msg.Headers = headers
+	return msg
+}
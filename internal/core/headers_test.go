@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func manyHeaders(n int) map[string]string {
+	headers := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		headers[fmt.Sprintf("h-%d", i)] = "v"
+	}
+	return headers
+}
+
+func TestNormalizeHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    map[string]string
+		err     string
+	}{
+		{"none", nil, nil, ""},
+		{"lowercased", map[string]string{"Region": "EU", "trace-ID": "abc"}, map[string]string{"region": "EU", "trace-id": "abc"}, ""},
+		{"at the limits", map[string]string{strings.Repeat("n", MaxHeaderNameBytes): strings.Repeat("v", MaxHeaderValueBytes)},
+			map[string]string{strings.Repeat("n", MaxHeaderNameBytes): strings.Repeat("v", MaxHeaderValueBytes)}, ""},
+		{"too many", manyHeaders(MaxHeaders + 1), nil, "at most 64 headers"},
+		{"name too long", map[string]string{strings.Repeat("n", MaxHeaderNameBytes+1): "v"}, nil, "longer than 128 bytes"},
+		{"value too long", map[string]string{"region": strings.Repeat("v", MaxHeaderValueBytes+1)}, nil, "value is longer than 4096 bytes"},
+		{"empty name", map[string]string{"": "v"}, nil, "cannot be empty"},
+		{"reserved", map[string]string{HeaderPublisher: "someone else"}, nil, "reserved prefix clevr-"},
+		{"reserved in capitals", map[string]string{"CLEVR-Received-At": "yesterday"}, nil, "reserved prefix clevr-"},
+		{"given twice", map[string]string{"Region": "EU", "region": "US"}, nil, "more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeHeaders(tt.headers)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if got, err := NormalizeHeaders(manyHeaders(MaxHeaders)); err != nil || len(got) != MaxHeaders {
+		t.Fatalf("%d headers, %v at the header limit", len(got), err)
+	}
+}
+
+func TestStampHeadersReplacesReservedOnes(t *testing.T) {
+	published := map[string]string{"region": "eu", "Clevr-Publisher": "forged"}
+	msg := Message{Headers: published}
+	receivedAt := time.Date(2026, 1, 2, 3, 4, 5, 6, time.FixedZone("", 3600))
+
+	stamped := stampHeaders(msg, "alice", receivedAt)
+	want := map[string]string{
+		"region":         "eu",
+		HeaderPublisher:  "alice",
+		HeaderReceivedAt: "2026-01-02T02:04:05.000000006Z",
+	}
+	if len(stamped.Headers) != len(want) {
+		t.Fatalf("stamped %v, want %v", stamped.Headers, want)
+	}
+	for name, value := range want {
+		if stamped.Headers[name] != value {
+			t.Fatalf("stamped %v, want %v", stamped.Headers, want)
+		}
+	}
+	if len(published) != 2 || published["Clevr-Publisher"] != "forged" {
+		t.Fatalf("published headers modified: %v", published)
+	}
+}
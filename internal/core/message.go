@@ -1,16 +1,46 @@
 package core
 
-import "time"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
 
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+	"github.com/google/uuid"
+)
+
+// Message is one published event. Data is kept as the raw JSON the publisher
+// sent, so it is never decoded and re-encoded on its way through. A payload
+// whose ContentType is not JSON is carried in Data as a base64 string.
+// Headers are string metadata from the publisher, plus the server's own
+// under ReservedHeaderPrefix.
 type Message struct {
-	Id        string                 `json:"id"`
-	Topic     string                 `json:"topic"`
-	TenantID  string                 `json:"tenant_id"`
-	Data      map[string]interface{} `json:"data"`
-	Timestamp time.Time              `json:"timestamp"`
+	Id           string            `json:"id"`
+	Offset       uint64            `json:"offset"`
+	Topic        string            `json:"topic"`
+	TenantID     string            `json:"tenant_id"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Data         json.RawMessage   `json:"data"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Attempt      int               `json:"attempt,omitempty"`
+	Subscription string            `json:"subscription,omitempty"`
+
+	// encodedSize is the length of the message's JSON encoding, recorded
+	// when it is published and charged against subscriber byte budgets.
+	encodedSize int64
+
+	// encoded caches the message's wire encodings. It is set at publish
+	// and shared by every copy, so each is produced once per message.
+	encoded *encodedForms
+
+	// binaryFrames is set per delivery for a subscriber that negotiated raw
+	// binary payloads.
+	binaryFrames bool
 }
 
-func NewMessage(topic, tenantID string, data map[string]interface{}) Message {
+func NewMessage(topic, tenantID string, data json.RawMessage) Message {
 	return Message{
 		Id:        GenerateId(),
 		Topic:     topic,
@@ -20,6 +50,61 @@ func NewMessage(topic, tenantID string, data map[string]interface{}) Message {
 	}
 }
 
+// NewBinaryMessage wraps a payload of any content type; it travels as
+// base64 wherever a JSON envelope carries it.
+func NewBinaryMessage(topic, tenantID, contentType string, payload []byte) Message {
+	data := make([]byte, 0, base64.StdEncoding.EncodedLen(len(payload))+2)
+	data = append(data, '"')
+	data = base64.StdEncoding.AppendEncode(data, payload)
+	data = append(data, '"')
+
+	msg := NewMessage(topic, tenantID, data)
+	msg.ContentType = contentType
+	return msg
+}
+
+func (m Message) IsJSON() bool {
+	return codec.IsJSONContentType(m.ContentType)
+}
+
+// Payload is the message body as the publisher sent it.
+func (m Message) Payload() ([]byte, error) {
+	if m.IsJSON() {
+		return m.Data, nil
+	}
+
+	var encoded string
+	if err := json.Unmarshal(m.Data, &encoded); err != nil {
+		return nil, fmt.Errorf("%s payload is not a base64 string", m.ContentType)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// size is the size of the payload as published, which is what byte quotas
+// count.
+func (m Message) size() (int, error) {
+	if m.IsJSON() {
+		return len(m.Data), nil
+	}
+	payload, err := m.Payload()
+	if err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// encodedBytes is what the message occupies in a subscriber buffer.
+func (m Message) encodedBytes() int64 {
+	if m.encodedSize > 0 {
+		return m.encodedSize
+	}
+	data, err := m.encodeJSON()
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
 func GenerateId() string {
-	return "msg-" + time.Now().Format("20060102-150405.000000")
+	return "msg-" + uuid.NewString()
 }
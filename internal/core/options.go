@@ -0,0 +1,204 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/filter"
+)
+
+// SubscribeOptions are the per-subscription settings a client may pass either
+// as /subscribe query parameters or in a subscribe control frame.
+type SubscribeOptions struct {
+	Topic        string
+	Start        StartPosition
+	Group        string
+	Balance      GroupBalance
+	DropStrategy DropStrategy
+	AckMode      bool
+	Ack          AckConfig
+
+	// BinaryPayloads delivers non-JSON payloads as binary WebSocket frames
+	// instead of base64 inside the JSON envelope.
+	BinaryPayloads bool
+
+	// Filter, compiled from the filter parameter, drops messages that do
+	// not match before they reach the subscriber's buffer.
+	Filter *filter.Filter
+
+	// Projection, from the include and exclude parameters, trims each JSON
+	// payload to the fields the subscriber wants before it is encoded.
+	Projection *Projection
+}
+
+// subscribeRequest is a subscribe as the client sent it, from /subscribe
+// query parameters or a subscribe frame, before it is validated. Zero
+// values mean the parameter was not given.
+type subscribeRequest struct {
+	Topic        string
+	From         string
+	FromOffset   *uint64
+	Group        string
+	Balance      string
+	Drop         string
+	Ack          bool
+	AckTimeoutMs int
+	MaxAttempts  int
+	MaxInFlight  int
+	Payload      string
+	Filter       string
+	Include      []string
+	Exclude      []string
+}
+
+func ParseSubscribeOptions(query url.Values) (SubscribeOptions, error) {
+	req := subscribeRequest{
+		Topic:   query.Get("topic"),
+		From:    query.Get("from"),
+		Group:   query.Get("group"),
+		Balance: query.Get("balance"),
+		Drop:    query.Get("drop"),
+		Ack:     query.Get("ack") == "true",
+		Payload: query.Get("payload"),
+		Filter:  query.Get("filter"),
+		Include: query["include"],
+		Exclude: query["exclude"],
+	}
+
+	if value := query.Get("from_offset"); value != "" {
+		offset, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return SubscribeOptions{}, fmt.Errorf("invalid from_offset %q", value)
+		}
+		req.FromOffset = &offset
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"ack_timeout_ms", &req.AckTimeoutMs},
+		{"max_attempts", &req.MaxAttempts},
+		{"max_in_flight", &req.MaxInFlight},
+	} {
+		value := query.Get(param.name)
+		if value == "" || !req.Ack {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return SubscribeOptions{}, fmt.Errorf("invalid %s %q", param.name, value)
+		}
+		*param.value = n
+	}
+
+	return req.options()
+}
+
+// options validates the request and builds the subscription's settings.
+func (r subscribeRequest) options() (SubscribeOptions, error) {
+	opts := SubscribeOptions{
+		Topic: r.Topic,
+		Group: r.Group,
+		Ack:   DefaultAckConfig(),
+	}
+
+	if opts.Topic == "" {
+		return opts, fmt.Errorf("topic is required")
+	}
+
+	if IsWildcard(opts.Topic) {
+		if err := ValidatePattern(opts.Topic); err != nil {
+			return opts, err
+		}
+		if opts.Group != "" {
+			return opts, fmt.Errorf("group is not supported with wildcard subscriptions")
+		}
+	}
+
+	var err error
+	if r.FromOffset != nil {
+		opts.Start = StartPosition{Mode: StartAtOffset, Offset: *r.FromOffset}
+	} else if opts.Start, err = ParseStartPosition(r.From, ""); err != nil {
+		return opts, err
+	}
+	if opts.Balance, err = ParseGroupBalance(r.Balance); err != nil {
+		return opts, err
+	}
+	if opts.DropStrategy, err = ParseDropStrategy(r.Drop); err != nil {
+		return opts, err
+	}
+	if opts.Ack, opts.AckMode, err = r.ackConfig(); err != nil {
+		return opts, err
+	}
+	switch r.Payload {
+	case "", "base64":
+	case "binary":
+		opts.BinaryPayloads = true
+	default:
+		return opts, fmt.Errorf("invalid payload %q: expected base64 or binary", r.Payload)
+	}
+	if r.Filter != "" {
+		if opts.Group != "" {
+			return opts, fmt.Errorf("filter is not supported with consumer groups")
+		}
+		if opts.Filter, err = filter.Compile(r.Filter); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Projection, err = ParseProjection(r.Include, r.Exclude); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// ackConfig reads the at-least-once options: ack enables the mode,
+// ack_timeout_ms, max_attempts and max_in_flight override the defaults.
+func (r subscribeRequest) ackConfig() (AckConfig, bool, error) {
+	config := DefaultAckConfig()
+
+	if !r.Ack {
+		return config, false, nil
+	}
+
+	if r.AckTimeoutMs < 0 {
+		return config, false, fmt.Errorf("invalid ack_timeout_ms %d", r.AckTimeoutMs)
+	}
+	if r.AckTimeoutMs > 0 {
+		config.Timeout = time.Duration(r.AckTimeoutMs) * time.Millisecond
+	}
+
+	if r.MaxAttempts < 0 {
+		return config, false, fmt.Errorf("invalid max_attempts %d", r.MaxAttempts)
+	}
+	if r.MaxAttempts > 0 {
+		config.MaxAttempts = r.MaxAttempts
+	}
+
+	if r.MaxInFlight < 0 {
+		return config, false, fmt.Errorf("invalid max_in_flight %d", r.MaxInFlight)
+	}
+	if r.MaxInFlight > 0 {
+		config.MaxInFlight = r.MaxInFlight
+	}
+
+	return config, true, nil
+}
+
+// ApplyOptions configures a subscriber before it is subscribed.
+func (s *Subscriber) ApplyOptions(opts SubscribeOptions) {
+	s.SetStartPosition(opts.Start)
+	s.SetDropStrategy(opts.DropStrategy)
+	if opts.Group != "" {
+		s.SetGroup(opts.Group, opts.Balance)
+	}
+	if opts.AckMode {
+		s.SetAckMode(opts.Ack)
+	}
+	s.binaryPayloads = opts.BinaryPayloads
+	s.filter = opts.Filter
+	s.projection = opts.Projection
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const DefaultReplayCount = 50
+
+type StartMode int
+
+const (
+	StartRecent StartMode = iota
+	StartEarliest
+	StartLatest
+	StartAtOffset
+)
+
+// StartPosition tells a topic where a new subscriber's stream begins.
+// The zero value replays the last DefaultReplayCount messages.
+type StartPosition struct {
+	Mode   StartMode
+	Offset uint64
+}
+
+// ParseStartPosition reads the from / from_offset subscribe parameters.
+// from_offset wins when both are given.
+func ParseStartPosition(from, fromOffset string) (StartPosition, error) {
+	if fromOffset != "" {
+		offset, err := strconv.ParseUint(fromOffset, 10, 64)
+		if err != nil {
+			return StartPosition{}, fmt.Errorf("invalid from_offset %q", fromOffset)
+		}
+		return StartPosition{Mode: StartAtOffset, Offset: offset}, nil
+	}
+
+	switch from {
+	case "":
+		return StartPosition{Mode: StartRecent}, nil
+	case "earliest":
+		return StartPosition{Mode: StartEarliest}, nil
+	case "latest":
+		return StartPosition{Mode: StartLatest}, nil
+	default:
+		return StartPosition{}, fmt.Errorf("invalid from %q: expected earliest or latest", from)
+	}
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+)
+
+// Limits on a projection, so a subscribe cannot make shaping every message
+// expensive.
+const (
+	MaxProjectionPaths     = 64
+	MaxProjectionPathBytes = 256
+)
+
+// Projection trims JSON payloads to the fields a subscriber asked for:
+// only the include paths when any are given, minus the exclude paths.
+// Paths are dotted field names (order.customer.id); a path that reaches an
+// array applies to each of its elements. Non-JSON payloads, and payloads
+// that are not objects or arrays, are delivered whole.
+type Projection struct {
+	include pathTree
+	exclude pathTree
+
+	// key names the projection by its sorted paths, so subscribers asking
+	// for the same fields in any order share the cached encodings.
+	key string
+}
+
+// pathTree holds a set of paths by field name. A nil child marks the end
+// of a path: the whole field is selected.
+type pathTree map[string]pathTree
+
+// ParseProjection builds a projection from comma-separated include and
+// exclude path lists; it returns nil when both are empty.
+func ParseProjection(include, exclude []string) (*Projection, error) {
+	includePaths, err := splitPaths("include", include)
+	if err != nil {
+		return nil, err
+	}
+	excludePaths, err := splitPaths("exclude", exclude)
+	if err != nil {
+		return nil, err
+	}
+	if len(includePaths) == 0 && len(excludePaths) == 0 {
+		return nil, nil
+	}
+	if len(includePaths)+len(excludePaths) > MaxProjectionPaths {
+		return nil, fmt.Errorf("projection has more than %d paths", MaxProjectionPaths)
+	}
+
+	p := &Projection{}
+	if len(includePaths) > 0 {
+		p.include = buildPathTree(includePaths)
+	}
+	if len(excludePaths) > 0 {
+		p.exclude = buildPathTree(excludePaths)
+	}
+	p.key = "include=" + strings.Join(includePaths, ",") + ";exclude=" + strings.Join(excludePaths, ",")
+	return p, nil
+}
+
+// splitPaths reads paths given as repeated values, each possibly a comma
+// separated list, and returns them sorted without duplicates.
+func splitPaths(name string, values []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, value := range values {
+		for _, path := range strings.Split(value, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" || seen[path] {
+				continue
+			}
+			if len(path) > MaxProjectionPathBytes {
+				return nil, fmt.Errorf("%s path is longer than %d bytes", name, MaxProjectionPathBytes)
+			}
+			for _, field := range strings.Split(path, ".") {
+				if field == "" {
+					return nil, fmt.Errorf("invalid %s path %q: empty field name", name, path)
+				}
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func buildPathTree(paths []string) pathTree {
+	tree := pathTree{}
+	for _, path := range paths {
+		node := tree
+		fields := strings.Split(path, ".")
+		for i, field := range fields {
+			child, exists := node[field]
+			if exists && child == nil {
+				// A shorter path already selects the whole field.
+				break
+			}
+			if i == len(fields)-1 {
+				node[field] = nil
+				break
+			}
+			if !exists {
+				child = pathTree{}
+				node[field] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+func (p *Projection) String() string {
+	return p.key
+}
+
+// apply shapes a message for delivery. A published message's projected
+// payload and its encodings are cached under the projection's key, so
+// they are produced once per distinct projection, not once per subscriber.
+func (p *Projection) apply(msg Message) (Message, error) {
+	if p == nil || !msg.IsJSON() {
+		return msg, nil
+	}
+
+	if msg.encoded == nil {
+		data, err := p.project(msg.Data)
+		if err != nil {
+			return msg, err
+		}
+		msg.Data = data
+		return msg, nil
+	}
+
+	shaped, err := msg.encoded.projected(p.key, func() (json.RawMessage, error) {
+		return p.project(msg.Data)
+	})
+	if err != nil {
+		return msg, err
+	}
+	msg.Data = shaped.data
+	msg.encoded = shaped.forms
+	return msg, nil
+}
+
+// project trims a JSON document, keeping its fields in their original
+// order.
+func (p *Projection) project(data json.RawMessage) (json.RawMessage, error) {
+	tree, err := codec.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("projecting payload: %w", err)
+	}
+	switch tree.(type) {
+	case codec.Object, []any:
+	default:
+		return data, nil
+	}
+
+	if p.include != nil {
+		tree, _ = includeFields(tree, p.include)
+	}
+	if p.exclude != nil {
+		tree = excludeFields(tree, p.exclude)
+	}
+	return codec.ToJSON(tree)
+}
+
+// includeFields keeps the fields of value named by tree. It reports false
+// for a value that cannot hold them, which its parent then leaves out.
+func includeFields(value any, tree pathTree) (any, bool) {
+	switch v := value.(type) {
+	case codec.Object:
+		kept := codec.Object{}
+		for _, field := range v {
+			child, selected := tree[field.Key]
+			if !selected {
+				continue
+			}
+			if child == nil {
+				kept = append(kept, field)
+				continue
+			}
+			if projected, ok := includeFields(field.Value, child); ok {
+				kept = append(kept, codec.Field{Key: field.Key, Value: projected})
+			}
+		}
+		return kept, true
+	case []any:
+		kept := make([]any, 0, len(v))
+		for _, element := range v {
+			if projected, ok := includeFields(element, tree); ok {
+				kept = append(kept, projected)
+			}
+		}
+		return kept, true
+	}
+	return nil, false
+}
+
+// excludeFields removes the fields of value named by tree.
+func excludeFields(value any, tree pathTree) any {
+	switch v := value.(type) {
+	case codec.Object:
+		kept := make(codec.Object, 0, len(v))
+		for _, field := range v {
+			child, selected := tree[field.Key]
+			switch {
+			case !selected:
+				kept = append(kept, field)
+			case child != nil:
+				kept = append(kept, codec.Field{Key: field.Key, Value: excludeFields(field.Value, child)})
+			}
+		}
+		return kept
+	case []any:
+		kept := make([]any, len(v))
+		for i, element := range v {
+			kept[i] = excludeFields(element, tree)
+		}
+		return kept
+	}
+	return value
+}
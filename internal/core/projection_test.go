@@ -0,0 +1,141 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numberedPaths(n int) []string {
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("f%d", i)
+	}
+	return paths
+}
+
+func TestParseProjection(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		key     string
+		err     string
+	}{
+		{"sorted", []string{"b,a"}, nil, "include=a,b;exclude=", ""},
+		{"deduplicated", []string{"a, b", " a ", "b,a"}, []string{"c", "c"}, "include=a,b;exclude=c", ""},
+		{"empty entries", []string{",a,,"}, []string{""}, "include=a;exclude=", ""},
+		{"at the path limit", numberedPaths(MaxProjectionPaths / 2), numberedPaths(MaxProjectionPaths / 2), "", ""},
+		{"duplicates count once", append(numberedPaths(MaxProjectionPaths), "f0", "f1"), nil, "", ""},
+		{"too many paths", numberedPaths(MaxProjectionPaths), []string{"extra"}, "", "more than 64 paths"},
+		{"path at the byte limit", []string{strings.Repeat("a", MaxProjectionPathBytes)}, nil, "", ""},
+		{"path too long", nil, []string{strings.Repeat("a", MaxProjectionPathBytes+1)}, "", "exclude path is longer than 256 bytes"},
+		{"empty field", []string{"order..id"}, nil, "", `invalid include path "order..id": empty field name`},
+		{"trailing dot", nil, []string{"order."}, "", "empty field name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseProjection(tt.include, tt.exclude)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.key != "" && p.key != tt.key {
+				t.Fatalf("key %q, want %q", p.key, tt.key)
+			}
+		})
+	}
+
+	if p, err := ParseProjection([]string{" , "}, nil); p != nil || err != nil {
+		t.Fatalf("empty projection gave %v, %v", p, err)
+	}
+}
+
+func TestProjectNested(t *testing.T) {
+	const payload = `{"id":1,"order":{"customer":{"id":7,"name":"Ann"},"total":9.5,"items":[{"sku":"a","qty":1},{"sku":"b","qty":2}]},"note":"x"}`
+
+	tests := []struct {
+		name    string
+		payload string
+		include string
+		exclude string
+		want    string
+	}{
+		{"include nested", payload, "id,order.customer.id", "",
+			`{"id":1,"order":{"customer":{"id":7}}}`},
+		{"include through an array", payload, "order.items.sku", "",
+			`{"order":{"items":[{"sku":"a"},{"sku":"b"}]}}`},
+		{"shorter include wins", payload, "order.customer.id,order", "",
+			`{"order":{"customer":{"id":7,"name":"Ann"},"total":9.5,"items":[{"sku":"a","qty":1},{"sku":"b","qty":2}]}}`},
+		{"include missing", payload, "missing,id.deeper", "",
+			`{}`},
+		{"exclude nested", payload, "", "order.customer,note",
+			`{"id":1,"order":{"total":9.5,"items":[{"sku":"a","qty":1},{"sku":"b","qty":2}]}}`},
+		{"exclude through an array", payload, "", "order.items.qty,order.customer.name",
+			`{"id":1,"order":{"customer":{"id":7},"total":9.5,"items":[{"sku":"a"},{"sku":"b"}]},"note":"x"}`},
+		{"exclude missing", payload, "", "order.missing.id,note.deeper",
+			payload},
+		{"include then exclude", payload, "order", "order.items,order.customer.id",
+			`{"order":{"customer":{"name":"Ann"},"total":9.5}}`},
+		{"top-level array", `[{"a":1,"b":2},{"b":3},5]`, "a", "",
+			`[{"a":1},{}]`},
+		{"scalar", `"just a string"`, "a", "",
+			`"just a string"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var include, exclude []string
+			if tt.include != "" {
+				include = []string{tt.include}
+			}
+			if tt.exclude != "" {
+				exclude = []string{tt.exclude}
+			}
+			p, err := ParseProjection(include, exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.project(json.RawMessage(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectionIsSharedAcrossSubscribers(t *testing.T) {
+	msg := publishedMessage(NewMessage("jobs", "tenant", json.RawMessage(`{"id":1,"secret":"s"}`)))
+
+	first, err := ParseProjection(nil, []string{"secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ParseProjection(nil, []string{"secret,secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := first.apply(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := second.apply(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a.Data) != `{"id":1}` || a.encoded != b.encoded || !sameBytes(a.Data, b.Data) {
+		t.Fatalf("projected %s and %s separately", a.Data, b.Data)
+	}
+	if string(msg.Data) != `{"id":1,"secret":"s"}` {
+		t.Fatalf("published payload changed to %s", msg.Data)
+	}
+}
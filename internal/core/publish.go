@@ -0,0 +1,77 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+)
+
+// ValidatePublish applies the checks every publish path shares. data is the
+// payload as a JSON envelope carries it: any JSON value for a JSON content
+// type, a base64 string for anything else.
+func ValidatePublish(topic, contentType string, data json.RawMessage) error {
+	if err := ValidatePublishTopic(topic); err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return fmt.Errorf("data needed")
+	}
+	if codec.IsJSONContentType(contentType) {
+		return nil
+	}
+
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("data for content type %s must be a base64 string", contentType)
+	}
+	if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+		return fmt.Errorf("data for content type %s is not valid base64: %v", contentType, err)
+	}
+	return nil
+}
+
+// ValidatePublishTopic checks only the topic, for paths that vet the
+// payload themselves.
+func ValidatePublishTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("Topic Needed")
+	}
+	if IsWildcard(topic) {
+		return fmt.Errorf("Topic cannot contain wildcards")
+	}
+	return nil
+}
+
+// NewPayloadMessage builds a message from a raw request body: JSON content
+// types are kept as JSON, anything else is wrapped as binary.
+func NewPayloadMessage(topic, tenantID, contentType string, body []byte) (Message, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if !codec.IsJSONContentType(contentType) {
+		return NewBinaryMessage(topic, tenantID, contentType, body), nil
+	}
+	if !json.Valid(body) {
+		return Message{}, fmt.Errorf("body is not valid JSON")
+	}
+
+	return NewEnvelopeMessage(topic, tenantID, contentType, body), nil
+}
+
+// NewEnvelopeMessage builds a message from a JSON publish request whose data
+// ValidatePublish has accepted.
+func NewEnvelopeMessage(topic, tenantID, contentType string, data json.RawMessage) Message {
+	msg := NewMessage(topic, tenantID, data)
+
+	// Plain JSON is the default, so it is not spelled out on the message.
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "application/json" {
+		msg.ContentType = contentType
+	}
+	return msg
+}
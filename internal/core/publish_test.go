@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// allBytes is every byte value, so nothing is lost to text handling.
+func allBytes() []byte {
+	payload := make([]byte, 256)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	return payload
+}
+
+func TestBinaryPayloadRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"every byte", allBytes()},
+		{"padding", []byte{0xff, 0xfe}},
+		{"invalid UTF-8", []byte("\xc3\x28 not text \x00")},
+		{"empty", []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewPayloadMessage("jobs", "tenant", "", tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.ContentType != "application/octet-stream" || msg.IsJSON() {
+				t.Fatalf("content type %q", msg.ContentType)
+			}
+			payload, err := msg.Payload()
+			if err != nil || !bytes.Equal(payload, tt.payload) {
+				t.Fatalf("payload %x, %v; want %x", payload, err, tt.payload)
+			}
+			if size, err := msg.size(); err != nil || size != len(tt.payload) {
+				t.Fatalf("size %d, %v; want %d", size, err, len(tt.payload))
+			}
+
+			// A subscriber reads the base64 string out of the JSON envelope
+			// and can publish it back as is.
+			envelope, err := msg.encodeJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var received Message
+			if err := json.Unmarshal(envelope, &received); err != nil {
+				t.Fatal(err)
+			}
+			if err := ValidatePublish("jobs", received.ContentType, received.Data); err != nil {
+				t.Fatal(err)
+			}
+			republished := NewEnvelopeMessage("jobs", "tenant", received.ContentType, received.Data)
+			payload, err = republished.Payload()
+			if err != nil || !bytes.Equal(payload, tt.payload) {
+				t.Fatalf("republished payload %x, %v; want %x", payload, err, tt.payload)
+			}
+
+			// A binary frame carries the raw bytes after its header.
+			frame, err := msg.encodeBinaryFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			headerLen := binary.BigEndian.Uint32(frame)
+			if body := frame[4+headerLen:]; !bytes.Equal(body, tt.payload) {
+				t.Fatalf("frame payload %x, want %x", body, tt.payload)
+			}
+		})
+	}
+}
+
+func TestValidateBinaryPublish(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"base64", `"AAEC/w=="`, ""},
+		{"not a string", `[0,1,2]`, "must be a base64 string"},
+		{"not base64", `"not base64!"`, "not valid base64"},
+		{"unpadded", `"AAEC/w"`, "not valid base64"},
+		{"null", `null`, "data needed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePublish("jobs", "image/png", json.RawMessage(tt.data))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCorruptBinaryPayload(t *testing.T) {
+	msg := NewEnvelopeMessage("jobs", "tenant", "image/png", json.RawMessage(`{"not":"base64"}`))
+	if _, err := msg.Payload(); err == nil || !strings.Contains(err.Error(), "not a base64 string") {
+		t.Fatalf("payload of a non-string: %v", err)
+	}
+	if _, err := msg.size(); err == nil {
+		t.Fatal("size of an undecodable payload")
+	}
+}
@@ -0,0 +1,136 @@
+package core
+
+import "sync"
+
+// messageQueue is a bounded FIFO ring whose capacity can change while it is
+// in use, so the buffer manager can resize live subscriber buffers. ready
+// holds a token whenever the queue has something to pop. Its storage is only
+// allocated on first push, so a subscriber reading its topic's ring pays for
+// the limit alone.
+type messageQueue struct {
+	mu    sync.Mutex
+	items []Message
+	limit int
+	head  int
+	count int
+	ready chan struct{}
+}
+
+func newMessageQueue(capacity int) *messageQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &messageQueue{
+		limit: capacity,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push appends msg, reporting false when the queue is full.
+func (q *messageQueue) push(msg Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count == q.limit {
+		return false
+	}
+	if q.items == nil {
+		q.items = make([]Message, q.limit)
+	}
+	q.items[(q.head+q.count)%len(q.items)] = msg
+	q.count++
+	q.signal()
+	return true
+}
+
+// pop removes the oldest message.
+func (q *messageQueue) pop() (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count == 0 {
+		return Message{}, false
+	}
+	msg := q.items[q.head]
+	q.items[q.head] = Message{}
+	q.head = (q.head + 1) % len(q.items)
+	q.count--
+	if q.count > 0 {
+		q.signal()
+	}
+	return msg, true
+}
+
+// popNewest removes the most recently pushed message.
+func (q *messageQueue) popNewest() (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count == 0 {
+		return Message{}, false
+	}
+	tail := (q.head + q.count - 1) % len(q.items)
+	msg := q.items[tail]
+	q.items[tail] = Message{}
+	q.count--
+	return msg, true
+}
+
+// resize changes the capacity, returning the messages that no longer fit,
+// taken from the front when evictOldest is set and from the back otherwise.
+func (q *messageQueue) resize(capacity int, evictOldest bool) []Message {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if capacity == q.limit {
+		return nil
+	}
+	q.limit = capacity
+	if q.items == nil {
+		return nil
+	}
+
+	kept := make([]Message, 0, q.count)
+	for i := 0; i < q.count; i++ {
+		kept = append(kept, q.items[(q.head+i)%len(q.items)])
+	}
+
+	var overflow []Message
+	if excess := len(kept) - capacity; excess > 0 {
+		if evictOldest {
+			overflow, kept = kept[:excess], kept[excess:]
+		} else {
+			kept, overflow = kept[:capacity], kept[capacity:]
+		}
+	}
+
+	q.items = make([]Message, capacity)
+	q.head = 0
+	q.count = copy(q.items, kept)
+	return overflow
+}
+
+func (q *messageQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+func (q *messageQueue) capacity() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit
+}
+
+// signal leaves a token on ready without blocking. It is also used to wake
+// the reader for messages waiting outside the queue, such as on disk.
+func (q *messageQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
@@ -74,3 +74,37 @@ func (c *RecentMessageCache) GetCount() int {
 	defer c.mu.RUnlock()
 	return c.count
 }
+
+// OldestOffset is the offset of the oldest cached message.
+func (c *RecentMessageCache) OldestOffset() (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.count == 0 {
+		return 0, false
+	}
+	return c.messages[(c.index-c.count+c.size)%c.size].Offset, true
+}
+
+// GetRange returns cached messages with start <= Offset < end. The bool
+// reports whether the cache still holds start, i.e. the range is complete.
+func (c *RecentMessageCache) GetRange(start, end uint64) ([]Message, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.count == 0 {
+		return []Message{}, false
+	}
+
+	oldest := (c.index - c.count + c.size) % c.size
+	covered := c.messages[oldest].Offset <= start
+
+	result := make([]Message, 0)
+	for i := 0; i < c.count; i++ {
+		msg := c.messages[(oldest+i)%c.size]
+		if msg.Offset >= start && msg.Offset < end {
+			result = append(result, msg)
+		}
+	}
+	return result, covered
+}
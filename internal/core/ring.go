@@ -0,0 +1,284 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+)
+
+// DefaultRingSize is how many messages a topic ring holds unless configured.
+const DefaultRingSize = 4096
+
+// topicRing is a topic's shared fan-out buffer. Publish writes each message
+// into it once, at its offset; broadcast subscribers read it through their
+// own cursor, so publishing costs the same for one subscriber or ten
+// thousand and a message is held in memory once, not once per subscriber.
+//
+// The ring keeps at most size messages and maxBytes of encoded payload,
+// evicting from the tail, and is trimmed to the slowest reader so it only
+// holds what someone still needs. It charges what it holds to the global buffer
+// budget. Its slots grow on demand up to size, so a quiet topic stays small.
+// Before a publish evicts messages a reader has not reached, the topic hands
+// them to that reader's cursor, which applies its drop strategy; the global
+// budget never evicts, so memory pressure reaches readers through their own
+// shrinking limits instead.
+type topicRing struct {
+	mu    sync.RWMutex
+	slots []ringSlot
+	size  int
+	head  uint64
+	tail  uint64
+	empty bool
+
+	// total is the running sum of encoded sizes up to head, and base the
+	// same sum just before tail, so the bytes between any two retained
+	// offsets is a subtraction of cumulative sums.
+	total    int64
+	base     int64
+	maxBytes int64
+
+	budget  *buffer.AddaptiveBufferManager
+	notify  chan struct{}
+	evicted atomic.Int64
+}
+
+type ringSlot struct {
+	msg        Message
+	cumulative int64
+}
+
+const initialRingSlots = 64
+
+func newTopicRing(size int, maxBytes int64, budget *buffer.AddaptiveBufferManager) *topicRing {
+	if size < 1 {
+		size = DefaultRingSize
+	}
+	if maxBytes <= 0 {
+		maxBytes = math.MaxInt64
+	}
+	return &topicRing{
+		slots:    make([]ringSlot, min(size, initialRingSlots)),
+		size:     size,
+		empty:    true,
+		maxBytes: maxBytes,
+		budget:   budget,
+		notify:   make(chan struct{}),
+	}
+}
+
+// append stores msg at its offset and wakes every waiting reader. Offsets
+// must arrive in order; the topic's publish lock guarantees that.
+func (r *topicRing) append(msg Message) {
+	size := msg.encodedBytes()
+
+	r.mu.Lock()
+	if r.empty {
+		r.head, r.tail = msg.Offset, msg.Offset
+		r.empty = false
+	}
+
+	for r.head-r.tail >= uint64(r.size) || (r.head > r.tail && r.total-r.base+size > r.maxBytes) {
+		r.evictTail()
+		r.evicted.Add(1)
+	}
+	if r.head-r.tail >= uint64(len(r.slots)) {
+		r.grow()
+	}
+	if r.budget != nil && !r.budget.Reserve(size) {
+		// Everything held is still owed to a reader, so the message is
+		// kept past the budget rather than evicting for it.
+		r.budget.Charge(size)
+	}
+
+	r.total += size
+	r.slots[msg.Offset%uint64(len(r.slots))] = ringSlot{msg: msg, cumulative: r.total}
+	r.head = msg.Offset + 1
+
+	notify := r.notify
+	r.notify = make(chan struct{})
+	r.mu.Unlock()
+
+	close(notify)
+}
+
+// evictionPoint is the tail the ring will have once a message of size bytes
+// is appended: everything below it is evicted to make room.
+func (r *topicRing) evictionPoint(size int64) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.empty {
+		return r.tail
+	}
+	tail := r.tail
+	for tail < r.head && (r.head-tail >= uint64(r.size) || r.total-r.cumulativeBefore(tail)+size > r.maxBytes) {
+		tail++
+	}
+	return tail
+}
+
+// evictTail drops the oldest message. Callers hold mu.
+func (r *topicRing) evictTail() {
+	slot := &r.slots[r.tail%uint64(len(r.slots))]
+	size := slot.cumulative - r.base
+	r.base = slot.cumulative
+	*slot = ringSlot{}
+	r.tail++
+	if r.budget != nil {
+		r.budget.Release(size)
+	}
+}
+
+// trim releases every message below offset, which no reader still needs.
+func (r *topicRing) trim(offset uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.tail < offset && r.tail < r.head {
+		r.evictTail()
+	}
+}
+
+// grow doubles the slots, up to size. Callers hold mu.
+func (r *topicRing) grow() {
+	slots := make([]ringSlot, min(2*len(r.slots), r.size))
+	for offset := r.tail; offset < r.head; offset++ {
+		slots[offset%uint64(len(slots))] = r.slots[offset%uint64(len(r.slots))]
+	}
+	r.slots = slots
+}
+
+// changed returns a channel closed by the next append. Take it before
+// looking for new messages so an append in between is not missed.
+func (r *topicRing) changed() <-chan struct{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.notify
+}
+
+// bounds returns the oldest retained offset and the next offset to be
+// written.
+func (r *topicRing) bounds() (tail, head uint64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tail, r.head
+}
+
+func (r *topicRing) read(offset uint64) (Message, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.empty || offset < r.tail || offset >= r.head {
+		return Message{}, false
+	}
+	return r.slots[offset%uint64(len(r.slots))].msg, true
+}
+
+// messages copies out [from, to), clamped to what the ring still holds.
+func (r *topicRing) messages(from, to uint64) []Message {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	from, to = r.clamp(from), r.clamp(to)
+	msgs := make([]Message, 0, to-from)
+	for offset := from; offset < to; offset++ {
+		msgs = append(msgs, r.slots[offset%uint64(len(r.slots))].msg)
+	}
+	return msgs
+}
+
+// bytesBetween is the encoded size of [from, to).
+func (r *topicRing) bytesBetween(from, to uint64) int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cumulativeBefore(r.clamp(to)) - r.cumulativeBefore(r.clamp(from))
+}
+
+// fitForward is the largest end such that [from, end) holds at most slots
+// messages and maxBytes. With atLeastOne it always takes the first message,
+// however large, as an empty subscriber buffer would.
+func (r *topicRing) fitForward(from uint64, slots, maxBytes int64, atLeastOne bool) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	from = r.clamp(from)
+	if slots <= 0 {
+		return from
+	}
+	limit := r.head
+	if limit-from > uint64(slots) {
+		limit = from + uint64(slots)
+	}
+	start := r.cumulativeBefore(from)
+	n := sort.Search(int(limit-from), func(i int) bool {
+		return r.cumulativeBefore(from+uint64(i)+1)-start > maxBytes
+	})
+	if n == 0 && atLeastOne && limit > from {
+		n = 1
+	}
+	return from + uint64(n)
+}
+
+// fitBackward is the smallest start such that [start, to) holds at most
+// slots messages and maxBytes, but always at least one message when there is
+// one.
+func (r *topicRing) fitBackward(to uint64, slots, maxBytes int64) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	to = r.clamp(to)
+	lowest := r.tail
+	if slots > 0 && to-lowest > uint64(slots) {
+		lowest = to - uint64(slots)
+	}
+	end := r.cumulativeBefore(to)
+	n := sort.Search(int(to-lowest), func(i int) bool {
+		return end-r.cumulativeBefore(lowest+uint64(i)) <= maxBytes
+	})
+	start := lowest + uint64(n)
+	if start == to && to > r.tail {
+		start = to - 1
+	}
+	return start
+}
+
+// Bytes is the encoded size of everything the ring holds.
+func (r *topicRing) Bytes() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.total - r.base
+}
+
+func (r *topicRing) GetMetrics() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return map[string]interface{}{
+		"size":     r.size,
+		"messages": r.head - r.tail,
+		"bytes":    r.total - r.base,
+		"evicted":  r.evicted.Load(),
+	}
+}
+
+// cumulativeBefore is the running total just before offset. Callers hold mu
+// and pass an offset in [tail, head].
+func (r *topicRing) cumulativeBefore(offset uint64) int64 {
+	if offset <= r.tail {
+		return r.base
+	}
+	return r.slots[(offset-1)%uint64(len(r.slots))].cumulative
+}
+
+func (r *topicRing) clamp(offset uint64) uint64 {
+	if offset < r.tail {
+		return r.tail
+	}
+	if offset > r.head {
+		return r.head
+	}
+	return offset
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledTransport holds the first message it is given until released,
+// standing in for a client that has stopped reading, and records what it
+// delivers.
+type stalledTransport struct {
+	sending chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu        sync.Mutex
+	delivered []uint64
+}
+
+func newStalledTransport() *stalledTransport {
+	return &stalledTransport{sending: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (t *stalledTransport) Send(ctx context.Context, msg Message) error {
+	t.once.Do(func() { close(t.sending) })
+	select {
+	case <-t.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	t.mu.Lock()
+	t.delivered = append(t.delivered, msg.Offset)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *stalledTransport) Name() string { return "stalled" }
+
+func (t *stalledTransport) offsets() []uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]uint64(nil), t.delivered...)
+}
+
+func offsetsBetween(from, to uint64) []uint64 {
+	var offsets []uint64
+	for offset := from; offset < to; offset++ {
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+func equalOffsets(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRingOverrunAppliesDropStrategy stalls a ring reader with a 100 slot
+// buffer on a 16 message ring and publishes past both. The ring's eviction
+// must not decide what the reader loses: it gets the messages its own
+// strategy keeps, and every loss is counted and dead-lettered.
+func TestRingOverrunAppliesDropStrategy(t *testing.T) {
+	const (
+		ringSize  = 16
+		slots     = 100
+		published = 300
+	)
+
+	tests := []struct {
+		strategy  DropStrategy
+		reason    string
+		delivered []uint64
+		lost      []uint64
+	}{
+		{
+			strategy:  DROP_NEWEST,
+			reason:    ReasonDroppedNewest,
+			delivered: offsetsBetween(0, 1+slots),
+			lost:      offsetsBetween(1+slots, published),
+		},
+		{
+			strategy:  DROP_OLDEST,
+			reason:    ReasonDroppedOldest,
+			delivered: append([]uint64{0}, offsetsBetween(published-slots, published)...),
+			lost:      offsetsBetween(1, published-slots),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			var mu sync.Mutex
+			var lost []uint64
+			topic := NewTopic("orders", "tenant", 16, ringSize, nil, nil)
+			topic.deadLetter = func(dl DeadLetter) {
+				mu.Lock()
+				defer mu.Unlock()
+				if dl.Reason != tt.reason {
+					t.Errorf("offset %d dead-lettered as %s, want %s", dl.Message.Offset, dl.Reason, tt.reason)
+				}
+				lost = append(lost, dl.Message.Offset)
+			}
+
+			transport := newStalledTransport()
+			sub := NewSubscriber("reader", "tenant", "orders", transport, context.Background(), slots)
+			sub.SetDropStrategy(tt.strategy)
+			if err := topic.Subscribe(sub); err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+
+			publish := func() {
+				if _, err := topic.Publish(NewMessage("orders", "tenant", json.RawMessage(`{"n":1}`))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			publish()
+			<-transport.sending
+			for i := 1; i < published; i++ {
+				publish()
+			}
+			close(transport.release)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for len(transport.offsets()) < len(tt.delivered) && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			if got := transport.offsets(); !equalOffsets(got, tt.delivered) {
+				t.Fatalf("delivered %d messages %v..., want %d", len(got), got[:min(len(got), 5)], len(tt.delivered))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !equalOffsets(lost, tt.lost) {
+				t.Fatalf("dead-lettered %d messages, want %d", len(lost), len(tt.lost))
+			}
+			if dropped := sub.droppedCount.Load(); dropped != int64(len(tt.lost)) {
+				t.Fatalf("counted %d drops, want %d", dropped, len(tt.lost))
+			}
+		})
+	}
+}
+
+// TestRingOverrunTripsCircuitBreaker checks that losses a lagging reader
+// takes when the ring turns over still count towards its circuit breaker.
+func TestRingOverrunTripsCircuitBreaker(t *testing.T) {
+	topic := NewTopic("orders", "tenant", 16, 16, nil, nil)
+	transport := newStalledTransport()
+	defer close(transport.release)
+
+	sub := NewSubscriber("reader", "tenant", "orders", transport, context.Background(), 50)
+	sub.SetDropStrategy(CIRCUIT_BREAKER)
+	if err := topic.Subscribe(sub); err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	msg := NewMessage("orders", "tenant", json.RawMessage(`{"n":1}`))
+	topic.Publish(msg)
+	<-transport.sending
+	for i := 0; i < 200; i++ {
+		topic.Publish(msg)
+	}
+
+	if !sub.IsClosed() {
+		t.Fatalf("reader still open after %d drops", sub.droppedCount.Load())
+	}
+}
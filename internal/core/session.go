@@ -0,0 +1,389 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/acl"
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+	"github.com/AadityaChoubey68/clevr-live/internal/quota"
+	"github.com/AadityaChoubey68/clevr-live/internal/throttle"
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+)
+
+// MaxFrameBytes bounds a single client frame, which may carry a publish batch.
+const MaxFrameBytes = 1024 * 1024
+
+// publishQueueSize bounds the publish frames a session holds while earlier
+// ones are still being published; past it, publishes are refused.
+const publishQueueSize = 64
+
+// Session owns one client WebSocket and multiplexes any number of
+// subscriptions over it. Each subscription is a regular Subscriber with its
+// own buffer and drop strategy; the session reads control frames and routes
+// them to the right one. Clients may also publish over the same socket.
+type Session struct {
+	ID       string
+	TenantID string
+	Identity auth.Identity
+
+	conn         *websocket.Conn
+	codec        codec.Codec
+	transport    *WebSocketTransport
+	ctx          context.Context
+	cancel       context.CancelFunc
+	topicManager *TopicManager
+	bufferSize   func() int
+
+	// publishes feeds publishLoop, so a slow publish does not hold up the
+	// acks, pings and subscribes read behind it.
+	publishes chan ClientFrame
+
+	subscriptions    map[string]*Subscriber
+	primary          string
+	nextSubscription int
+	mu               sync.Mutex
+}
+
+// NewSession serves conn in codec c, the one its subprotocol selected; nil
+// means JSON.
+func NewSession(id string, identity auth.Identity, conn *websocket.Conn, c codec.Codec, ctx context.Context, tm *TopicManager, bufferSize func() int) *Session {
+	ctx, cancel := context.WithCancel(ctx)
+	transport := NewWebSocketTransport(conn, c)
+
+	return &Session{
+		ID:            id,
+		TenantID:      identity.TenantID,
+		Identity:      identity,
+		conn:          conn,
+		codec:         transport.Codec(),
+		transport:     transport,
+		ctx:           ctx,
+		cancel:        cancel,
+		topicManager:  tm,
+		bufferSize:    bufferSize,
+		publishes:     make(chan ClientFrame, publishQueueSize),
+		subscriptions: make(map[string]*Subscriber),
+	}
+}
+
+// Run serves the session until the client disconnects. initial, if given, is
+// the subscription from the /subscribe query string; its frames are sent
+// untagged and the session ends with it, as a single-topic socket always has.
+func (s *Session) Run(initial *SubscribeOptions) {
+	defer s.conn.Close(websocket.StatusNormalClosure, "Subscriber Disconnected")
+	defer s.cancel()
+
+	s.conn.SetReadLimit(MaxFrameBytes)
+
+	if initial != nil {
+		_, err := s.subscribe(*initial, "")
+		if err != nil {
+			status := websocket.StatusInternalError
+			if errors.Is(err, acl.ErrDenied) {
+				status = websocket.StatusPolicyViolation
+			} else if errors.Is(err, quota.ErrExceeded) {
+				status = websocket.StatusTryAgainLater
+			}
+			s.conn.Close(status, fmt.Sprintf("Failed to subscribe: %v", err))
+			return
+		}
+	}
+
+	go s.pingLoop()
+	go s.publishLoop()
+	s.readLoop()
+}
+
+func (s *Session) subscribe(opts SubscribeOptions, tag string) (string, error) {
+	subscriberID := uuid.New().String()
+
+	key := tag
+	if key == "" {
+		key = subscriberID
+	}
+
+	sub := NewSubscriber(subscriberID, s.TenantID, opts.Topic, s.transport, s.ctx, s.bufferSize())
+	sub.ApplyOptions(opts)
+	sub.tag = tag
+
+	s.mu.Lock()
+	if _, exists := s.subscriptions[key]; exists {
+		s.mu.Unlock()
+		return "", fmt.Errorf("subscription %s already exists", key)
+	}
+	s.subscriptions[key] = sub
+	if tag == "" {
+		// Only the initial subscription is untagged; the session ends with
+		// it. It is recorded before watch starts, which reads it.
+		s.primary = key
+	}
+	s.mu.Unlock()
+
+	if err := s.topicManager.Subscribe(s.Identity, opts.Topic, subscriberID, sub); err != nil {
+		s.mu.Lock()
+		delete(s.subscriptions, key)
+		s.mu.Unlock()
+		return "", err
+	}
+
+	go s.watch(key, sub)
+	return key, nil
+}
+
+// watch tears a subscription down once it ends, whether the client asked for
+// it, the subscriber closed itself, or the whole session went away.
+func (s *Session) watch(key string, sub *Subscriber) {
+	<-sub.Context().Done()
+
+	s.mu.Lock()
+	if s.subscriptions[key] == sub {
+		delete(s.subscriptions, key)
+	}
+	primary := key == s.primary
+	s.mu.Unlock()
+
+	s.topicManager.Unsubscribe(s.TenantID, sub.Topic, sub.ID)
+	fmt.Printf("Subscriber %s disconnected from %s:%s\n", sub.ID, s.TenantID, sub.Topic)
+
+	// A subscription the ACL no longer allows is reported to the client:
+	// the socket closes with a policy violation if it was the primary one,
+	// otherwise the session carries on without it.
+	revoked := errors.Is(sub.Err(), acl.ErrDenied)
+
+	if primary {
+		if revoked {
+			s.conn.Close(websocket.StatusPolicyViolation, "Subscription no longer allowed")
+		}
+		s.cancel()
+		return
+	}
+	if revoked && s.ctx.Err() == nil {
+		s.reply(ServerFrame{Type: "error", Subscription: key, Topic: sub.Topic, Error: sub.Err().Error()})
+	}
+}
+
+func (s *Session) pingLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+			err := s.conn.Ping(ctx)
+			cancel()
+
+			if err != nil {
+				s.cancel()
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Session) readLoop() {
+	for {
+		msgType, data, err := s.conn.Read(s.ctx)
+		if err != nil {
+			return
+		}
+		if msgType == websocket.MessageBinary && !s.codec.Binary() {
+			continue
+		}
+
+		frame, err := decodeClientFrame(msgType, data, s.codec)
+		if err != nil {
+			s.reply(ServerFrame{Type: "error", Error: "invalid frame"})
+			continue
+		}
+		s.handleFrame(frame)
+	}
+}
+
+func (s *Session) handleFrame(frame ClientFrame) {
+	switch frame.Type {
+	case "subscribe":
+		opts, err := frame.subscribeRequest().options()
+		if err != nil {
+			s.replyError(frame, err)
+			return
+		}
+
+		tag := frame.Subscription
+		if tag == "" {
+			s.mu.Lock()
+			s.nextSubscription++
+			tag = fmt.Sprintf("s-%d", s.nextSubscription)
+			s.mu.Unlock()
+		}
+
+		if _, err := s.subscribe(opts, tag); err != nil {
+			s.replyError(frame, err)
+			return
+		}
+		s.reply(ServerFrame{Type: "subscribed", RequestID: frame.RequestID, Subscription: tag, Topic: opts.Topic})
+
+	case "unsubscribe":
+		s.mu.Lock()
+		sub, exists := s.subscriptions[frame.Subscription]
+		s.mu.Unlock()
+
+		if !exists {
+			s.replyError(frame, fmt.Errorf("subscription %s not found", frame.Subscription))
+			return
+		}
+		sub.Close()
+		s.reply(ServerFrame{Type: "unsubscribed", RequestID: frame.RequestID, Subscription: frame.Subscription, Topic: sub.Topic})
+
+	case "ack":
+		for _, sub := range s.ackTargets(frame.Subscription) {
+			sub.handleAck(frame)
+		}
+
+	case "publish":
+		select {
+		case s.publishes <- frame:
+		default:
+			s.reply(ServerFrame{Type: "error", RequestID: frame.RequestID, Topic: frame.Topic, Error: "too many publishes in flight"})
+		}
+
+	case "ping":
+		s.reply(ServerFrame{Type: "pong", RequestID: frame.RequestID})
+
+	case "auth":
+		s.replyError(frame, fmt.Errorf("session is already authenticated"))
+
+	default:
+		s.replyError(frame, fmt.Errorf("unknown frame type %q", frame.Type))
+	}
+}
+
+// publishLoop publishes the session's publish frames one at a time, in the
+// order they were read.
+func (s *Session) publishLoop() {
+	for {
+		select {
+		case frame := <-s.publishes:
+			s.handlePublish(frame)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// handlePublish runs a single or batched publish frame through the same
+// validation, admission and TopicManager.Publish path as POST /publish. A
+// publish the throttler refuses is answered with a throttle frame carrying
+// the backoff, after the batch results if it was part of a batch.
+func (s *Session) handlePublish(frame ClientFrame) {
+	if len(frame.Messages) == 0 {
+		result, throttled := s.publish(PublishItem{Topic: frame.Topic, ContentType: frame.ContentType, Headers: frame.Headers, Data: frame.Data})
+		if throttled != nil {
+			s.replyThrottle(frame.RequestID, frame.Topic, throttled)
+			return
+		}
+		if !result.Success {
+			s.reply(ServerFrame{Type: "error", RequestID: frame.RequestID, Topic: frame.Topic, Error: result.Error, RetryAfterMs: result.RetryAfterMs})
+			return
+		}
+		s.reply(ServerFrame{Type: "published", RequestID: frame.RequestID, Topic: frame.Topic, MessageId: result.MessageId, Offset: result.Offset})
+		return
+	}
+
+	var backoff *throttle.ThrottledError
+	results := make([]PublishResult, 0, len(frame.Messages))
+	for _, item := range frame.Messages {
+		result, throttled := s.publish(item)
+		if throttled != nil && (backoff == nil || throttled.RetryAfter > backoff.RetryAfter) {
+			backoff = throttled
+		}
+		results = append(results, result)
+	}
+	s.reply(ServerFrame{Type: "published", RequestID: frame.RequestID, Results: results})
+
+	if backoff != nil {
+		s.replyThrottle(frame.RequestID, "", backoff)
+	}
+}
+
+func (s *Session) publish(item PublishItem) (PublishResult, *throttle.ThrottledError) {
+	if err := ValidatePublish(item.Topic, item.ContentType, item.Data); err != nil {
+		return PublishResult{Error: err.Error()}, nil
+	}
+	headers, err := NormalizeHeaders(item.Headers)
+	if err != nil {
+		return PublishResult{Error: err.Error()}, nil
+	}
+
+	msg := NewEnvelopeMessage(item.Topic, s.TenantID, item.ContentType, item.Data)
+	msg.Headers = headers
+	offset, err := s.topicManager.Publish(s.Identity, item.Topic, msg)
+	if err != nil {
+		result := PublishResult{Error: fmt.Sprintf("Failed to publish: %v", err)}
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			result.RetryAfterMs = exceeded.RetryAfter.Milliseconds()
+		}
+		var throttled *throttle.ThrottledError
+		if errors.As(err, &throttled) {
+			result.RetryAfterMs = throttled.RetryAfterMs()
+			return result, throttled
+		}
+		return result, nil
+	}
+
+	return PublishResult{Success: true, MessageId: msg.Id, Offset: &offset}, nil
+}
+
+func (s *Session) replyThrottle(requestID, topic string, throttled *throttle.ThrottledError) {
+	s.reply(ServerFrame{
+		Type:          "throttle",
+		RequestID:     requestID,
+		Topic:         topic,
+		Error:         throttled.Error(),
+		RetryAfterMs:  throttled.RetryAfterMs(),
+		AdmissionRate: throttled.Rate,
+		Overloaded:    throttled.Shed,
+	})
+}
+
+// ackTargets picks the subscriptions an ack applies to: the named one, or all
+// of them when the client only sent message IDs.
+func (s *Session) ackTargets(key string) []*Subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key != "" {
+		if sub, exists := s.subscriptions[key]; exists {
+			return []*Subscriber{sub}
+		}
+		return nil
+	}
+
+	targets := make([]*Subscriber, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		targets = append(targets, sub)
+	}
+	return targets
+}
+
+func (s *Session) reply(frame ServerFrame) {
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	if err := WriteServerFrame(ctx, s.conn, s.codec, frame); err != nil {
+		s.cancel()
+	}
+}
+
+func (s *Session) replyError(frame ClientFrame, err error) {
+	s.reply(ServerFrame{Type: "error", RequestID: frame.RequestID, Subscription: frame.Subscription, Error: err.Error()})
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ">" matches one or more levels, as in NATS. "#" matches zero or more, as
+// in MQTT, so "orders.#" also matches "orders" itself.
+const (
+	SubjectSeparator  = "."
+	SingleWildcard    = "*"
+	MultiWildcard     = ">"
+	mqttMultiWildcard = "#"
+)
+
+// IsWildcard reports whether a topic name is a subscription pattern rather
+// than a concrete topic.
+func IsWildcard(topic string) bool {
+	for _, token := range strings.Split(topic, SubjectSeparator) {
+		if token == SingleWildcard || token == MultiWildcard || token == mqttMultiWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePattern splits a wildcard pattern into tokens. A multi-level wildcard
+// may only appear as the last token.
+func parsePattern(pattern string) ([]string, error) {
+	tokens := strings.Split(pattern, SubjectSeparator)
+	for i, token := range tokens {
+		switch token {
+		case "":
+			return nil, fmt.Errorf("invalid pattern %q: empty level", pattern)
+		case mqttMultiWildcard, MultiWildcard:
+			if i != len(tokens)-1 {
+				return nil, fmt.Errorf("invalid pattern %q: %s must be the last level", pattern, token)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// ValidatePattern checks that a wildcard subscription is well formed.
+func ValidatePattern(pattern string) error {
+	_, err := parsePattern(pattern)
+	return err
+}
+
+type trieNode struct {
+	children    map[string]*trieNode
+	subscribers map[string]*Subscriber
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{
+		children:    make(map[string]*trieNode),
+		subscribers: make(map[string]*Subscriber),
+	}
+}
+
+// subjectTrie indexes wildcard subscriptions by pattern level so a publish
+// only walks the branches that can match its topic.
+type subjectTrie struct {
+	root  *trieNode
+	count int
+	mu    sync.RWMutex
+}
+
+func newSubjectTrie() *subjectTrie {
+	return &subjectTrie{root: newTrieNode()}
+}
+
+func (t *subjectTrie) insert(pattern string, sub *Subscriber) error {
+	tokens, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := t.root
+	for _, token := range tokens {
+		child, exists := node.children[token]
+		if !exists {
+			child = newTrieNode()
+			node.children[token] = child
+		}
+		node = child
+	}
+
+	if _, exists := node.subscribers[sub.ID]; !exists {
+		t.count++
+	}
+	node.subscribers[sub.ID] = sub
+	return nil
+}
+
+func (t *subjectTrie) remove(pattern, subscriberID string) (*Subscriber, bool) {
+	tokens, err := parsePattern(pattern)
+	if err != nil {
+		return nil, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	path := []*trieNode{t.root}
+	node := t.root
+	for _, token := range tokens {
+		child, exists := node.children[token]
+		if !exists {
+			return nil, false
+		}
+		node = child
+		path = append(path, node)
+	}
+
+	sub, exists := node.subscribers[subscriberID]
+	if !exists {
+		return nil, false
+	}
+	delete(node.subscribers, subscriberID)
+	t.count--
+
+	// Prune branches left empty so the trie does not grow without bound.
+	for i := len(tokens) - 1; i >= 0; i-- {
+		child := path[i+1]
+		if len(child.children) > 0 || len(child.subscribers) > 0 {
+			break
+		}
+		delete(path[i].children, tokens[i])
+	}
+
+	return sub, true
+}
+
+func (t *subjectTrie) match(subject string) []*Subscriber {
+	tokens := strings.Split(subject, SubjectSeparator)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.count == 0 {
+		return nil
+	}
+
+	matched := make(map[string]*Subscriber)
+	t.root.collect(tokens, matched)
+
+	result := make([]*Subscriber, 0, len(matched))
+	for _, sub := range matched {
+		result = append(result, sub)
+	}
+	return result
+}
+
+func (n *trieNode) collect(tokens []string, matched map[string]*Subscriber) {
+	// "#" also matches the level it hangs off, with nothing after it.
+	if child, exists := n.children[mqttMultiWildcard]; exists {
+		for id, sub := range child.subscribers {
+			matched[id] = sub
+		}
+	}
+
+	if len(tokens) == 0 {
+		for id, sub := range n.subscribers {
+			matched[id] = sub
+		}
+		return
+	}
+
+	if child, exists := n.children[tokens[0]]; exists {
+		child.collect(tokens[1:], matched)
+	}
+	if child, exists := n.children[SingleWildcard]; exists {
+		child.collect(tokens[1:], matched)
+	}
+	if child, exists := n.children[MultiWildcard]; exists {
+		for id, sub := range child.subscribers {
+			matched[id] = sub
+		}
+	}
+}
+
+func (t *subjectTrie) snapshot() []*Subscriber {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var result []*Subscriber
+	var walk func(n *trieNode)
+	walk = func(n *trieNode) {
+		for _, sub := range n.subscribers {
+			result = append(result, sub)
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(t.root)
+	return result
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSubjectTrieMatch(t *testing.T) {
+	patterns := []string{"orders.*", "orders.>", "orders.#", "orders.eu.created", "*.eu.*", "#"}
+
+	trie := newSubjectTrie()
+	for _, pattern := range patterns {
+		if err := trie.insert(pattern, &Subscriber{ID: pattern}); err != nil {
+			t.Fatalf("insert %q: %v", pattern, err)
+		}
+	}
+
+	tests := []struct {
+		subject string
+		want    []string
+	}{
+		{"orders", []string{"#", "orders.#"}},
+		{"orders.eu", []string{"#", "orders.#", "orders.*", "orders.>"}},
+		{"orders.eu.created", []string{"#", "*.eu.*", "orders.#", "orders.>", "orders.eu.created"}},
+		{"payments.eu.failed", []string{"#", "*.eu.*"}},
+		{"payments", []string{"#"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, sub := range trie.match(tt.subject) {
+			got = append(got, sub.ID)
+		}
+		sort.Strings(got)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s matched %v, want %v", tt.subject, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s matched %v, want %v", tt.subject, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParsePatternRejectsMisplacedWildcards(t *testing.T) {
+	for _, pattern := range []string{"orders.>.eu", "#.orders", "orders..eu", ""} {
+		if err := ValidatePattern(pattern); err == nil {
+			t.Errorf("pattern %q was accepted", pattern)
+		}
+	}
+}
@@ -2,13 +2,20 @@ package core
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/coder/websocket"
-	"github.com/coder/websocket/wsjson"
+	"github.com/AadityaChoubey68/clevr-live/internal/acl"
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+	"github.com/AadityaChoubey68/clevr-live/internal/filter"
+	"github.com/AadityaChoubey68/clevr-live/internal/spill"
 )
 
 type DropStrategy int
@@ -17,114 +24,695 @@ const (
 	DROP_OLDEST DropStrategy = iota
 	DROP_NEWEST
 	CIRCUIT_BREAKER
+	SPILL_TO_DISK
 )
 
 type Subscriber struct {
 	ID               string
 	TenantID         string
 	Topic            string
-	messageChan      chan Message
-	conn             *websocket.Conn
+	Group            string
+	queue            *messageQueue
+	transport        Transport
 	ctx              context.Context
 	cancel           context.CancelFunc
 	dropStrategy     DropStrategy
 	droppedCount     atomic.Int64
+	resizeDrops      atomic.Int64
 	messagesRecieved atomic.Int64
 	messagesSent     atomic.Int64
 	lastActive       time.Time
 	done             chan struct{}
 	closeOnce        sync.Once
+
+	// stopped is closed when the send loop exits, after which the
+	// transport is no longer written to.
+	stopped chan struct{}
+
+	// unsent holds a group member's message that the send loop took from
+	// the buffer but failed to send, so a rebalance still hands it on. The
+	// send loop writes it; it is read once stopped is closed.
+	unsent []Message
+
+	startPosition StartPosition
+	groupBalance  GroupBalance
+	replay        func(send func(Message) error) error
+	lastOffset    atomic.Uint64
+
+	// startOffset is the first offset the subscription delivers, set by
+	// the topic before the send loop starts.
+	startOffset uint64
+
+	acks                 *inFlightTracker
+	messagesAcked        atomic.Int64
+	messagesRedelivered  atomic.Int64
+	messagesAckExhausted atomic.Int64
+
+	deadLetter func(DeadLetter)
+
+	// tag is the session subscription ID stamped on delivered frames.
+	tag string
+
+	// binaryPayloads asks the transport for raw binary frames for non-JSON
+	// payloads.
+	binaryPayloads bool
+
+	// filter, when set, keeps only matching messages; the rest are counted
+	// in messagesFiltered and never buffered.
+	filter           *filter.Filter
+	messagesFiltered atomic.Int64
+
+	// projection, when set, trims payloads before they are sent;
+	// bytesTrimmed is how much smaller that made them.
+	projection   *Projection
+	bytesTrimmed atomic.Int64
+
+	// Buffered messages are charged by encoded size against maxBytes and
+	// the manager's global budget. Once the subscriber closes its bytes are
+	// handed back in one go and nothing more is charged.
+	budget        *buffer.AddaptiveBufferManager
+	maxBytes      atomic.Int64
+	bytesMu       sync.Mutex
+	bufferedBytes atomic.Int64
+	bytesReleased bool
+
+	// Under SPILL_TO_DISK, overflow is paged to spillQueue and read back in
+	// order; spillFallback applies once the disk limits are reached.
+	spillQueue      *spill.Queue
+	spillFallback   DropStrategy
+	messagesSpilled atomic.Int64
+
+	// A plain broadcast subscriber reads its topic's shared ring through
+	// cursor instead of having messages copied into queue; the queue then
+	// only carries its slot limit and wake-ups.
+	cursor *ringCursor
+
+	// identity is who subscribed, kept so the ACL can be checked again on
+	// reload and, for wildcard patterns, per matched topic. aclTopics caches
+	// those per-topic answers for aclPolicy.
+	identity  auth.Identity
+	aclMu     sync.Mutex
+	aclPolicy *acl.Policy
+	aclTopics map[string]bool
+
+	// closeErr says why the server ended the subscription, when it did.
+	closeErr atomic.Pointer[error]
 }
 
-func NewSubscriber(id, tenantID, topic string, conn *websocket.Conn, ctx context.Context, bufferSize int) *Subscriber {
+// maxCachedACLTopics bounds a wildcard subscriber's per-topic ACL cache; it
+// is cleared and refilled past this.
+const maxCachedACLTopics = 4096
+
+// slowBacklog is the buffer fill past which a subscriber counts as slow even
+// before it has dropped anything.
+const slowBacklog = 0.5
+
+// alwaysReady is a closed channel, for selects that should not wait.
+var alwaysReady = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func NewSubscriber(id, tenantID, topic string, transport Transport, ctx context.Context, bufferSize int) *Subscriber {
 	ctx, cancel := context.WithCancel(ctx)
 
 	return &Subscriber{
 		ID:           id,
 		TenantID:     tenantID,
 		Topic:        topic,
-		messageChan:  make(chan Message, bufferSize),
-		conn:         conn,
+		queue:        newMessageQueue(bufferSize),
+		transport:    transport,
 		ctx:          ctx,
 		cancel:       cancel,
 		dropStrategy: DROP_OLDEST,
 		lastActive:   time.Now(),
 		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
 	}
 }
 
-func (s *Subscriber) Start() {
+// SetStartPosition chooses where the stream begins; call before subscribing.
+func (s *Subscriber) SetStartPosition(pos StartPosition) {
+	s.startPosition = pos
+}
+
+// SetGroup joins the subscriber to a shared subscription on its topic; the
+// balance is only used if this subscriber creates the group.
+func (s *Subscriber) SetGroup(name string, balance GroupBalance) {
+	s.Group = name
+	s.groupBalance = balance
+}
+
+// SetAckMode switches the subscriber to at-least-once delivery; call before
+// subscribing.
+func (s *Subscriber) SetAckMode(config AckConfig) {
+	s.acks = newInFlightTracker(config)
+}
+
+// Start launches the send loop. replay, if set, is drained straight to the
+// client before any buffered live message is sent.
+func (s *Subscriber) Start(replay func(send func(Message) error) error) {
+	s.replay = replay
 	go s.sendLoop()
 }
 
+// StartOffset is the offset the subscription started from; it is only
+// meaningful once subscribed to a single topic.
+func (s *Subscriber) StartOffset() uint64 {
+	return s.startOffset
+}
+
+// reportGap tells the client, when its transport can, that a replay is
+// skipping offsets no longer held. It runs on the send loop, so the notice
+// arrives just before the messages that follow the gap.
+func (s *Subscriber) reportGap(from, first uint64) error {
+	fmt.Printf("Subscriber %s: offsets %d to %d of %s are no longer retained\n", s.ID, from, first-1, s.Topic)
+
+	transport, ok := s.transport.(gapTransport)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+	return transport.SendGap(ctx, Gap{Topic: s.Topic, Subscription: s.tag, From: from, First: first})
+}
+
+// Stopped is closed once the send loop has exited. Close only asks the loop
+// to stop, so a handler whose transport writes to its response must wait
+// for this before returning; the loop may still be inside Send.
+func (s *Subscriber) Stopped() <-chan struct{} {
+	return s.stopped
+}
+
+// attachRing makes the subscriber read live messages from ring starting at
+// offset from; the topic calls it under its publish lock.
+func (s *Subscriber) attachRing(ring *topicRing, from uint64) {
+	s.cursor = newRingCursor(ring, from)
+}
+
+// readsRing reports whether the subscriber can share its topic's ring.
+// Consumer group members are handed individual messages, a spilling
+// subscriber must own its backlog to page it out, and a filtering one only
+// buffers what matches, so all three keep a queue.
+func (s *Subscriber) readsRing() bool {
+	return s.Group == "" && s.dropStrategy != SPILL_TO_DISK && s.filter == nil
+}
+
+// matches applies the subscriber's filter, counting what it drops.
+func (s *Subscriber) matches(msg Message) bool {
+	if s.filter == nil || s.filter.Match(msg.filterDocument()) {
+		return true
+	}
+	s.messagesFiltered.Add(1)
+	return false
+}
+
+// setByteBudget charges the subscriber's buffer against budget; the topic
+// manager calls it before the subscriber starts receiving.
+func (s *Subscriber) setByteBudget(budget *buffer.AddaptiveBufferManager) {
+	s.budget = budget
+	s.maxBytes.Store(budget.GetSubscriberBytes())
+}
+
+func ParseDropStrategy(value string) (DropStrategy, error) {
+	switch value {
+	case "", "oldest":
+		return DROP_OLDEST, nil
+	case "newest":
+		return DROP_NEWEST, nil
+	case "circuit_breaker":
+		return CIRCUIT_BREAKER, nil
+	case "spill":
+		return SPILL_TO_DISK, nil
+	default:
+		return DROP_OLDEST, fmt.Errorf("invalid drop strategy %q: expected oldest, newest, circuit_breaker or spill", value)
+	}
+}
+
+// ParseSpillFallback picks the strategy a spilling subscriber falls back to
+// at its disk limit. Dropping the oldest message is not offered: it would
+// have to come from the front of the spill file.
+func ParseSpillFallback(value string) (DropStrategy, error) {
+	switch value {
+	case "", "newest":
+		return DROP_NEWEST, nil
+	case "circuit_breaker":
+		return CIRCUIT_BREAKER, nil
+	default:
+		return DROP_NEWEST, fmt.Errorf("invalid spill fallback %q: expected newest or circuit_breaker", value)
+	}
+}
+
+func (s *Subscriber) SetDropStrategy(strategy DropStrategy) {
+	s.dropStrategy = strategy
+}
+
+// trySend enqueues msg only if there is room, without applying the drop
+// strategy. Consumer groups use it to probe members before falling back.
+func (s *Subscriber) trySend(msg Message) bool {
+	if !s.enqueue(msg) {
+		return false
+	}
+	s.messagesRecieved.Add(1)
+	return true
+}
+
+// enqueue buffers msg if there is both a free slot and room in the byte
+// budgets for it.
+func (s *Subscriber) enqueue(msg Message) bool {
+	return s.tryEnqueue(msg) == nil
+}
+
+// Reasons tryEnqueue leaves a message out of the buffer.
+var (
+	errBufferFull      = errors.New("subscriber buffer full")
+	errBudgetExhausted = errors.New("global byte budget exhausted")
+)
+
+// tryEnqueue is enqueue reporting what was in the way: errBufferFull for the
+// subscriber's own slot or byte limit, errBudgetExhausted for the global
+// budget shared by every subscriber.
+func (s *Subscriber) tryEnqueue(msg Message) error {
+	// While anything is on disk, new messages queue behind it.
+	if s.spilling() {
+		return errBufferFull
+	}
+
+	size := msg.encodedBytes()
+	if err := s.reserveBytes(size); err != nil {
+		return err
+	}
+
+	if !s.queue.push(msg) {
+		s.releaseBytes(size)
+		return errBufferFull
+	}
+	return nil
+}
+
+// dequeued hands back the bytes of a message that has left the buffer.
+func (s *Subscriber) dequeued(msg Message) {
+	s.releaseBytes(msg.encodedBytes())
+}
+
+// reserveBytes charges size to this subscriber and to the global budget. An
+// empty buffer always has room for one message from its own budget, so a
+// message larger than the budget is still delivered rather than lost.
+func (s *Subscriber) reserveBytes(size int64) error {
+	s.bytesMu.Lock()
+	defer s.bytesMu.Unlock()
+
+	if s.budget == nil || s.bytesReleased {
+		return nil
+	}
+
+	buffered := s.bufferedBytes.Load()
+	if buffered > 0 && buffered+size > s.maxBytes.Load() {
+		return errBufferFull
+	}
+	if !s.budget.Reserve(size) {
+		return errBudgetExhausted
+	}
+
+	s.bufferedBytes.Add(size)
+	return nil
+}
+
+func (s *Subscriber) releaseBytes(size int64) {
+	s.bytesMu.Lock()
+	defer s.bytesMu.Unlock()
+
+	if s.budget == nil || s.bytesReleased {
+		return
+	}
+
+	s.bufferedBytes.Add(-size)
+	s.budget.Release(size)
+}
+
+// releaseAllBytes returns everything still charged to a closed subscriber,
+// whose buffer will never be drained.
+func (s *Subscriber) releaseAllBytes() {
+	s.bytesMu.Lock()
+	defer s.bytesMu.Unlock()
+
+	if s.budget == nil || s.bytesReleased {
+		return
+	}
+
+	s.budget.Release(s.bufferedBytes.Swap(0))
+	s.bytesReleased = true
+}
+
+func (s *Subscriber) reportDeadLetter(msg Message, reason string, attempts int) {
+	if s.deadLetter == nil {
+		return
+	}
+	s.deadLetter(DeadLetter{
+		Message:      msg,
+		Reason:       reason,
+		SubscriberID: s.ID,
+		Attempts:     attempts,
+	})
+}
+
 func (s *Subscriber) SendMessages(msg Message) error {
+	if !s.matches(msg) {
+		return nil
+	}
 	s.messagesRecieved.Add(1)
 
-	select {
-	case s.messageChan <- msg:
+	if s.enqueue(msg) {
 		return nil
-	default:
-		return s.handleBackPressure(msg)
 	}
+	return s.handleBackPressure(msg)
 }
 
+// handleBackPressure runs when the buffer is out of slots or out of bytes.
 func (s *Subscriber) handleBackPressure(msg Message) error {
-	switch s.dropStrategy {
+	return s.applyDropStrategy(s.dropStrategy, msg)
+}
+
+func (s *Subscriber) applyDropStrategy(strategy DropStrategy, msg Message) error {
+	switch strategy {
 	case DROP_OLDEST:
-		select {
-		case <-s.messageChan:
-			s.droppedCount.Add(1)
-		default:
-		}
+		// A large message may need several small ones evicted to fit. That
+		// only helps while this subscriber's own limits are in the way;
+		// when the global budget is exhausted, evicting would empty this
+		// buffer without making room, so msg alone is dropped.
+		for {
+			err := s.tryEnqueue(msg)
+			if err == nil {
+				return nil
+			}
 
-		select {
-		case s.messageChan <- msg:
-			return nil
-		default:
+			oldest, ok := Message{}, false
+			if errors.Is(err, errBufferFull) {
+				oldest, ok = s.queue.pop()
+			}
+			if !ok {
+				s.droppedCount.Add(1)
+				s.reportDeadLetter(msg, ReasonDroppedOldest, 0)
+				return fmt.Errorf("buffer Still Full After dropping data for subscriber : %s", s.ID)
+			}
+			s.dequeued(oldest)
 			s.droppedCount.Add(1)
-			return fmt.Errorf("buffer Still Full After dropping data for subscriber : %s", s.ID)
+			s.reportDeadLetter(oldest, ReasonDroppedOldest, 0)
 		}
 
 	case DROP_NEWEST:
 		s.droppedCount.Add(1)
+		s.reportDeadLetter(msg, ReasonDroppedNewest, 0)
 		return fmt.Errorf("subscriber %s: buffer full, dropped new message", s.ID)
 
 	case CIRCUIT_BREAKER:
-		dropped := s.droppedCount.Load()
-		if dropped > 100 {
+		dropped := s.droppedCount.Add(1)
+		s.reportDeadLetter(msg, ReasonCircuitBreaker, 0)
+		if s.breakerTripped(dropped) {
 			s.Close()
 			return fmt.Errorf("subscriber %s: circuit breaker triggered", s.ID)
 		}
 		return nil
 
+	case SPILL_TO_DISK:
+		if s.spillMessage(msg) {
+			return nil
+		}
+		return s.applyDropStrategy(s.spillFallback, msg)
+
 	default:
 		s.droppedCount.Add(1)
 		return fmt.Errorf("subscriber %s: unknown drop strategy", s.ID)
 	}
 }
 
+// circuitBreakerLimit is how many messages a CIRCUIT_BREAKER subscriber may
+// lose before it is disconnected.
+const circuitBreakerLimit = 100
+
+// breakerTripped reports whether dropped, the subscriber's drop count, is
+// past the circuit breaker's limit. Drops forced by a resize are the
+// server's doing, not a sign of a slow client, so they are left out.
+func (s *Subscriber) breakerTripped(dropped int64) bool {
+	return dropped-s.resizeDrops.Load() > circuitBreakerLimit
+}
+
+// Resize applies new buffer limits to a live subscriber. Messages that no
+// longer fit, by slot or by byte, are dropped as the drop strategy says:
+// oldest first under DROP_OLDEST, newest first otherwise. They are counted
+// as Resize Drops and never trip the circuit breaker.
+func (s *Subscriber) Resize(slots int, maxBytes int64) {
+	if s.IsClosed() {
+		return
+	}
+
+	// A ring reader holds nothing of its own; the new limits apply to its
+	// backlog as soon as it wakes.
+	if s.cursor != nil {
+		s.queue.resize(slots, false)
+		s.maxBytes.Store(maxBytes)
+		s.queue.signal()
+		return
+	}
+
+	evictOldest := s.dropStrategy == DROP_OLDEST
+	s.maxBytes.Store(maxBytes)
+	overflow := s.queue.resize(slots, evictOldest)
+
+	remaining := s.bufferedBytes.Load()
+	for _, msg := range overflow {
+		remaining -= msg.encodedBytes()
+	}
+	// Evictions from the back are collected newest first; prepending keeps
+	// overflow in arrival order so it can be spilled as is.
+	for remaining > maxBytes && s.queue.len() > 1 {
+		var msg Message
+		var ok bool
+		if evictOldest {
+			msg, ok = s.queue.pop()
+		} else {
+			msg, ok = s.queue.popNewest()
+		}
+		if !ok {
+			break
+		}
+		remaining -= msg.encodedBytes()
+		if evictOldest {
+			overflow = append(overflow, msg)
+		} else {
+			overflow = append([]Message{msg}, overflow...)
+		}
+	}
+
+	if len(overflow) == 0 {
+		return
+	}
+
+	// An empty spill file can take the overflow without reordering anything;
+	// otherwise it would land behind newer messages, so it is dropped.
+	if s.dropStrategy == SPILL_TO_DISK && !s.spilling() {
+		for len(overflow) > 0 && s.spillMessage(overflow[0]) {
+			s.dequeued(overflow[0])
+			overflow = overflow[1:]
+		}
+		if len(overflow) == 0 {
+			return
+		}
+	}
+
+	reason := ReasonDroppedNewest
+	switch s.dropStrategy {
+	case DROP_OLDEST:
+		reason = ReasonDroppedOldest
+	case CIRCUIT_BREAKER:
+		reason = ReasonCircuitBreaker
+	}
+
+	for _, msg := range overflow {
+		s.dequeued(msg)
+		s.reportDeadLetter(msg, reason, 0)
+	}
+	// Resize drops are counted before the total, so the circuit breaker,
+	// which leaves them out, never sees them as the subscriber's own.
+	s.resizeDrops.Add(int64(len(overflow)))
+	s.droppedCount.Add(int64(len(overflow)))
+}
+
+// spilling reports whether messages are waiting on disk.
+func (s *Subscriber) spilling() bool {
+	return s.spillQueue != nil && s.spillQueue.Len() > 0
+}
+
+func (s *Subscriber) spillMessage(msg Message) bool {
+	if s.spillQueue == nil {
+		return false
+	}
+
+	record, err := msg.encodeJSON()
+	if err != nil {
+		return false
+	}
+	if err := s.spillQueue.Append(record); err != nil {
+		if !errors.Is(err, spill.ErrFull) {
+			fmt.Printf("Subscriber %s: %v\n", s.ID, err)
+		}
+		return false
+	}
+
+	s.messagesSpilled.Add(1)
+	s.queue.signal()
+	return true
+}
+
+// unspill reads the next message back from disk.
+func (s *Subscriber) unspill() (Message, bool) {
+	if s.spillQueue == nil {
+		return Message{}, false
+	}
+
+	record, ok, err := s.spillQueue.Next()
+	if err != nil {
+		fmt.Printf("Subscriber %s: %v\n", s.ID, err)
+		return Message{}, false
+	}
+	if !ok {
+		return Message{}, false
+	}
+
+	var msg Message
+	if err := json.Unmarshal(record, &msg); err != nil {
+		fmt.Printf("Subscriber %s: undecodable spilled message: %v\n", s.ID, err)
+		return Message{}, false
+	}
+	msg.encodedSize = int64(len(record))
+	msg.encoded = newEncodedForms().withJSON(record)
+	return msg, true
+}
+
+// discardSpill deletes the spill file and whatever is still in it.
+func (s *Subscriber) discardSpill() {
+	if s.spillQueue == nil {
+		return
+	}
+	if err := s.spillQueue.Close(); err != nil {
+		fmt.Printf("Subscriber %s: remove spill file: %v\n", s.ID, err)
+	}
+}
+
 func (s *Subscriber) sendLoop() {
-	ticker := time.NewTicker(30 * time.Second)
+	defer close(s.stopped)
+
+	var redeliverTick <-chan time.Time
+	var windowSpace chan struct{}
+	if s.acks != nil {
+		redeliverTicker := time.NewTicker(s.acks.checkInterval())
+		defer redeliverTicker.Stop()
+		redeliverTick = redeliverTicker.C
+		windowSpace = s.acks.space
+	}
+
+	if s.replay != nil {
+		err := s.replay(func(msg Message) error {
+			if !s.matches(msg) {
+				return nil
+			}
+			if err := s.waitForWindow(redeliverTick); err != nil {
+				return err
+			}
+			return s.deliver(msg)
+		})
+		if err != nil {
+			s.Close()
+			return
+		}
+	}
+
+	if s.cursor != nil {
+		s.readRing(redeliverTick, windowSpace)
+		return
+	}
 
 	for {
+		// A full in-flight window stops pulling from the buffer, so new
+		// messages queue up there and the drop strategy applies as usual.
+		ready := s.queue.ready
+		if s.acks != nil && s.acks.full() {
+			ready = nil
+		}
+
 		select {
-		case msg, ok := <-s.messageChan:
-			if !ok {
+		case <-ready:
+			// The in-memory buffer is always older than anything on disk.
+			msg, ok := s.queue.pop()
+			if ok {
+				s.dequeued(msg)
+			} else if msg, ok = s.unspill(); !ok {
+				continue
+			}
+			// The queue only signals for itself, so keep the loop coming
+			// back while the disk still has a backlog.
+			if s.spilling() {
+				s.queue.signal()
+			}
+			if err := s.deliver(msg); err != nil {
+				// Acked messages are already tracked as in flight.
+				if s.Group != "" && s.acks == nil {
+					s.unsent = append(s.unsent, msg)
+				}
+				s.Close()
+				return
+			}
+		case <-windowSpace:
+		case <-redeliverTick:
+			if err := s.redeliverExpired(); err != nil {
+				s.Close()
 				return
 			}
+		case <-s.done:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
 
-			if err := s.sendToClient(msg); err != nil {
+// readRing is the send loop of a ring reader: apply the drop strategy to
+// whatever has piled up since it last looked, then send the next message or
+// wait for a publish.
+func (s *Subscriber) readRing(redeliverTick <-chan time.Time, windowSpace chan struct{}) {
+	for {
+		// Taken before looking, so a publish in between still wakes us.
+		changed := s.cursor.ring.changed()
+
+		s.enforceBacklog()
+		if s.IsClosed() {
+			return
+		}
+
+		ready := changed
+		windowFull := s.acks != nil && s.acks.full()
+		if !windowFull && s.cursor.hasNext() {
+			ready = alwaysReady
+		}
+
+		select {
+		case <-ready:
+			if windowFull {
+				continue
+			}
+			msg, ok := s.cursor.advance()
+			if !ok {
+				continue
+			}
+			if err := s.deliver(msg); err != nil {
 				s.Close()
 				return
 			}
-			s.messagesSent.Add(1)
-			s.lastActive = time.Now()
-		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
-			err := s.conn.Ping(ctx)
-			cancel()
-
-			if err != nil {
+		case <-s.queue.ready:
+		case <-windowSpace:
+		case <-redeliverTick:
+			if err := s.redeliverExpired(); err != nil {
 				s.Close()
 				return
 			}
@@ -136,31 +724,299 @@ func (s *Subscriber) sendLoop() {
 	}
 }
 
+// enforceBacklog holds a ring reader's backlog to its buffer limits,
+// dropping and reporting as its drop strategy says.
+func (s *Subscriber) enforceBacklog() {
+	maxBytes := s.maxBytes.Load()
+	if maxBytes <= 0 {
+		maxBytes = math.MaxInt64
+	}
+
+	lost, count := s.cursor.enforce(s.dropStrategy, int64(s.queue.capacity()), maxBytes, s.deadLetter != nil)
+	if count == 0 {
+		return
+	}
+
+	reason := ReasonDroppedNewest
+	switch s.dropStrategy {
+	case DROP_OLDEST:
+		reason = ReasonDroppedOldest
+	case CIRCUIT_BREAKER:
+		reason = ReasonCircuitBreaker
+	}
+	for _, msg := range lost {
+		s.reportDeadLetter(msg, reason, 0)
+	}
+
+	dropped := s.droppedCount.Add(count)
+	if s.dropStrategy == CIRCUIT_BREAKER && s.breakerTripped(dropped) {
+		s.Close()
+	}
+}
+
+// overrun runs, under the topic's publish lock, before the ring evicts
+// everything below evictTo while this reader still needs some of it. The
+// drop strategy is applied first, as on the reader's next wake-up, and the
+// cursor then keeps what is left below evictTo, so the ring's own limits
+// never decide what the reader loses.
+func (s *Subscriber) overrun(evictTo uint64) {
+	if s.IsClosed() {
+		return
+	}
+	s.enforceBacklog()
+	s.cursor.overrun(evictTo)
+}
+
+func (s *Subscriber) deliver(msg Message) error {
+	if s.acks != nil {
+		msg.Attempt = s.acks.track(msg)
+	}
+
+	if err := s.sendToClient(msg); err != nil {
+		return err
+	}
+
+	s.messagesSent.Add(1)
+	s.lastOffset.Store(msg.Offset)
+	s.lastActive = time.Now()
+	return nil
+}
+
+// waitForWindow blocks replay while the in-flight window is full, still
+// servicing redeliveries so the window can drain.
+func (s *Subscriber) waitForWindow(redeliverTick <-chan time.Time) error {
+	if s.acks == nil {
+		return nil
+	}
+
+	for s.acks.full() {
+		select {
+		case <-s.acks.space:
+		case <-redeliverTick:
+			if err := s.redeliverExpired(); err != nil {
+				return err
+			}
+		case <-s.done:
+			return fmt.Errorf("subscriber %s closed", s.ID)
+		}
+	}
+	return nil
+}
+
+func (s *Subscriber) redeliverExpired() error {
+	retry, exhausted := s.acks.expired(time.Now())
+
+	for _, entry := range exhausted {
+		s.messagesAckExhausted.Add(1)
+		fmt.Printf("Subscriber %s: message %s unacked after %d attempts\n", s.ID, entry.msg.Id, entry.attempts)
+		s.reportDeadLetter(entry.msg, ReasonMaxRedelivery, entry.attempts)
+	}
+
+	for _, entry := range retry {
+		if err := s.deliver(entry.msg); err != nil {
+			return err
+		}
+		s.messagesRedelivered.Add(1)
+	}
+	return nil
+}
+
+func (s *Subscriber) handleAck(frame ClientFrame) {
+	if s.acks == nil {
+		return
+	}
+	for _, id := range frame.ids() {
+		if s.acks.ack(id) {
+			s.messagesAcked.Add(1)
+		}
+	}
+	for _, offset := range frame.offsets() {
+		if s.acks.ackOffset(offset) {
+			s.messagesAcked.Add(1)
+		}
+	}
+}
+
 func (s *Subscriber) sendToClient(msg Message) error {
 	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
 	defer cancel()
 
-	return wsjson.Write(ctx, s.conn, msg)
+	if s.projection != nil {
+		shaped, err := s.projection.apply(msg)
+		if err != nil {
+			return err
+		}
+		s.bytesTrimmed.Add(int64(len(msg.Data) - len(shaped.Data)))
+		msg = shaped
+	}
+
+	msg.Subscription = s.tag
+	msg.binaryFrames = s.binaryPayloads
+	return s.transport.Send(ctx, msg)
 }
 
+// Close ends this subscription only; the connection belongs to whoever
+// created the transport.
 func (s *Subscriber) Close() {
 	s.closeOnce.Do(func() {
 		s.cancel()
 
 		close(s.done)
+		s.releaseAllBytes()
+		if s.cursor != nil {
+			s.cursor.discard()
+		}
 
-		s.conn.Close(websocket.StatusNormalClosure, "Subscriber Disconnected")
-
-		close(s.messageChan)
+		// A group member's spill is kept for the rebalance, which reads it
+		// back through drainPending and then discards it.
+		if s.Group == "" {
+			s.discardSpill()
+		}
 	})
 }
 
+// revoke closes the subscription on the server's initiative, recording why
+// for Err.
+func (s *Subscriber) revoke(err error) {
+	s.closeErr.CompareAndSwap(nil, &err)
+	s.Close()
+}
+
+// Err reports why the server closed the subscription, or nil if it did not.
+func (s *Subscriber) Err() error {
+	if err := s.closeErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// mayRead reports whether the subscriber's identity may subscribe to topic,
+// a concrete topic its wildcard pattern matched. Answers are cached until
+// the ACL is reloaded.
+func (s *Subscriber) mayRead(store *acl.Store, topic string) bool {
+	policy := store.Policy()
+
+	s.aclMu.Lock()
+	defer s.aclMu.Unlock()
+
+	if s.aclPolicy != policy || len(s.aclTopics) >= maxCachedACLTopics {
+		s.aclPolicy = policy
+		s.aclTopics = make(map[string]bool)
+	}
+	allowed, cached := s.aclTopics[topic]
+	if !cached {
+		allowed, _ = policy.Evaluate(s.identity, acl.Subscribe, topic)
+		s.aclTopics[topic] = allowed
+	}
+	return allowed
+}
+
+func (s *Subscriber) IsClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// BufferFill is the fraction of the buffer currently occupied; for a ring
+// reader, how far its cursor lags the head against its slot limit.
+func (s *Subscriber) BufferFill() float64 {
+	if s.cursor != nil {
+		slots, _ := s.cursor.backlog()
+		return float64(slots) / float64(s.queue.capacity())
+	}
+	return float64(s.queue.len()) / float64(s.queue.capacity())
+}
+
+// BufferedBytes is the encoded size of the messages waiting in the buffer.
+// A ring reader's backlog is shared with the rest of its topic, so this is
+// not what it is charged against the global budget.
+func (s *Subscriber) BufferedBytes() int64 {
+	if s.cursor != nil {
+		_, bytes := s.cursor.backlog()
+		return bytes
+	}
+	return s.bufferedBytes.Load()
+}
+
+// received is how many live messages have been offered to the subscriber.
+func (s *Subscriber) received() int64 {
+	if s.cursor != nil {
+		return s.cursor.received()
+	}
+	return s.messagesRecieved.Load()
+}
+
+// drainPending empties the buffer of a closed subscriber and returns what was
+// still waiting to be sent, including deliveries that were never acked, a
+// message whose send failed and anything spilled to disk. Call it only once
+// the send loop has stopped.
+func (s *Subscriber) drainPending() []Message {
+	var pending []Message
+	if s.acks != nil {
+		pending = s.acks.pending()
+	}
+	pending = append(pending, s.unsent...)
+	s.unsent = nil
+	for {
+		msg, ok := s.queue.pop()
+		if !ok {
+			break
+		}
+		pending = append(pending, msg)
+	}
+	for {
+		msg, ok := s.unspill()
+		if !ok {
+			break
+		}
+		pending = append(pending, msg)
+	}
+	s.discardSpill()
+	return pending
+}
+
 func (s *Subscriber) GetMetrics() map[string]int64 {
-	return map[string]int64{
-		"Messages Recieved": s.messagesRecieved.Load(),
+	metrics := map[string]int64{
+		"Messages Recieved": s.received(),
 		"Messages Sent":     s.messagesSent.Load(),
 		"Messages Dropped":  s.droppedCount.Load(),
+		"Last Offset Sent":  int64(s.lastOffset.Load()),
+		"Buffer Capacity":   int64(s.queue.capacity()),
+		"Resize Drops":      s.resizeDrops.Load(),
+		"Bytes Buffered":    s.BufferedBytes(),
+		"Byte Budget":       s.maxBytes.Load(),
 	}
+
+	if s.cursor != nil {
+		lag, _ := s.cursor.backlog()
+		metrics["Cursor Lag"] = lag
+	}
+
+	if s.filter != nil {
+		metrics["Messages Filtered"] = s.messagesFiltered.Load()
+	}
+
+	if s.projection != nil {
+		metrics["Bytes Trimmed"] = s.bytesTrimmed.Load()
+	}
+
+	if s.spillQueue != nil {
+		metrics["Messages Spilled"] = s.messagesSpilled.Load()
+		metrics["Spilled Pending"] = int64(s.spillQueue.Len())
+		metrics["Spill Bytes"] = s.spillQueue.Bytes()
+	}
+
+	if s.acks != nil {
+		metrics["Messages Acked"] = s.messagesAcked.Load()
+		metrics["Messages Redelivered"] = s.messagesRedelivered.Load()
+		metrics["Messages Ack Exhausted"] = s.messagesAckExhausted.Load()
+		metrics["In Flight"] = int64(s.acks.count())
+	}
+
+	return metrics
 }
 
 func (s *Subscriber) IsHealthy() bool {
@@ -168,7 +1024,7 @@ func (s *Subscriber) IsHealthy() bool {
 		return false
 	}
 
-	messagesRecieved := s.messagesRecieved.Load()
+	messagesRecieved := s.received()
 	droppedCount := s.droppedCount.Load()
 
 	if messagesRecieved > 0 {
@@ -180,8 +1036,34 @@ func (s *Subscriber) IsHealthy() bool {
 	return true
 }
 
+// IsSlow reports a subscriber that has dropped messages or whose backlog is
+// past slowBacklog of its buffer.
 func (s *Subscriber) IsSlow() bool {
-	return s.droppedCount.Load() > 0
+	return s.droppedCount.Load() > 0 || s.BufferFill() > slowBacklog
+}
+
+// MessagesFiltered is how many messages the subscriber's filter dropped.
+func (s *Subscriber) MessagesFiltered() int64 {
+	return s.messagesFiltered.Load()
+}
+
+// BytesTrimmed is how many payload bytes the subscriber's projection has
+// kept off the wire.
+func (s *Subscriber) BytesTrimmed() int64 {
+	return s.bytesTrimmed.Load()
+}
+
+func (s *Subscriber) TransportName() string {
+	return s.transport.Name()
+}
+
+// CodecName is the codec the subscriber's messages are encoded in; only
+// WebSocket connections negotiate one.
+func (s *Subscriber) CodecName() string {
+	if ws, ok := s.transport.(*WebSocketTransport); ok {
+		return ws.Codec().Name()
+	}
+	return codec.JSON.Name()
 }
 
 func (s *Subscriber) Context() context.Context {
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
+)
+
+// messageBytes is the encoded size every test message claims.
+const messageBytes = 100
+
+func sizedMessage(offset uint64) Message {
+	return Message{Id: GenerateId(), Topic: "jobs", TenantID: "tenant", Offset: offset, encodedSize: messageBytes}
+}
+
+// bufferedSubscriber returns a subscriber whose send loop never runs, so
+// whatever it is sent stays buffered, holding n messages.
+func bufferedSubscriber(t *testing.T, strategy DropStrategy, slots, n int) (*Subscriber, *buffer.AddaptiveBufferManager) {
+	t.Helper()
+	budget := buffer.NewAdaptiveBufferManager(1<<30, buffer.ByteBudget{Total: 1 << 20, PerSubscriber: 1 << 20}, load.NewProcessSampler(1<<30, time.Second))
+	sub := NewSubscriber("sub", "tenant", "jobs", discardTransport{}, context.Background(), slots)
+	sub.SetDropStrategy(strategy)
+	sub.setByteBudget(budget)
+
+	for i := 0; i < n; i++ {
+		if err := sub.SendMessages(sizedMessage(uint64(i))); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	return sub, budget
+}
+
+// bufferedOffsets drains the buffer, returning the offsets it held.
+func bufferedOffsets(sub *Subscriber) []uint64 {
+	var offsets []uint64
+	for {
+		msg, ok := sub.queue.pop()
+		if !ok {
+			return offsets
+		}
+		sub.dequeued(msg)
+		offsets = append(offsets, msg.Offset)
+	}
+}
+
+func expectOffsets(t *testing.T, got []uint64, from, to uint64) {
+	t.Helper()
+	if uint64(len(got)) != to-from {
+		t.Fatalf("buffer held %v, want %d to %d", got, from, to-1)
+	}
+	for i, offset := range got {
+		if offset != from+uint64(i) {
+			t.Fatalf("buffer held %v, want %d to %d", got, from, to-1)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy DropStrategy
+		slots    int
+		maxBytes int64
+		from, to uint64
+	}{
+		{"fewer slots drops oldest", DROP_OLDEST, 4, 1 << 20, 6, 10},
+		{"fewer slots drops newest", DROP_NEWEST, 4, 1 << 20, 0, 4},
+		{"fewer bytes drops oldest", DROP_OLDEST, 10, 3 * messageBytes, 7, 10},
+		{"circuit breaker drops newest", CIRCUIT_BREAKER, 10, 3 * messageBytes, 0, 3},
+		{"both", DROP_OLDEST, 5, 2 * messageBytes, 8, 10},
+		{"one message always fits", DROP_NEWEST, 10, messageBytes / 2, 0, 1},
+		{"growing drops nothing", DROP_OLDEST, 20, 1 << 20, 0, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, budget := bufferedSubscriber(t, tt.strategy, 10, 10)
+			var lost []DeadLetter
+			sub.deadLetter = func(dl DeadLetter) { lost = append(lost, dl) }
+
+			sub.Resize(tt.slots, tt.maxBytes)
+
+			kept := int64(tt.to - tt.from)
+			if got := budget.BytesInFlight(); got != kept*messageBytes {
+				t.Fatalf("%d bytes charged, want %d", got, kept*messageBytes)
+			}
+			dropped := 10 - kept
+			if sub.resizeDrops.Load() != dropped || sub.droppedCount.Load() != dropped || int64(len(lost)) != dropped {
+				t.Fatalf("%d resize drops, %d drops, %d dead letters; want %d", sub.resizeDrops.Load(), sub.droppedCount.Load(), len(lost), dropped)
+			}
+			expectOffsets(t, bufferedOffsets(sub), tt.from, tt.to)
+			if got := budget.BytesInFlight(); got != 0 {
+				t.Fatalf("%d bytes charged after draining", got)
+			}
+		})
+	}
+}
+
+func TestResizeDropsDoNotTripTheBreaker(t *testing.T) {
+	sub, _ := bufferedSubscriber(t, CIRCUIT_BREAKER, 200, 200)
+
+	sub.Resize(10, 1<<20)
+	if sub.IsClosed() || sub.resizeDrops.Load() != 190 {
+		t.Fatalf("closed %v after %d resize drops", sub.IsClosed(), sub.resizeDrops.Load())
+	}
+
+	// The client's own drops still count, up to the limit.
+	for i := 0; i < circuitBreakerLimit; i++ {
+		sub.SendMessages(sizedMessage(uint64(200 + i)))
+	}
+	if sub.IsClosed() {
+		t.Fatal("breaker tripped at its limit")
+	}
+	sub.SendMessages(sizedMessage(300))
+	if !sub.IsClosed() {
+		t.Fatal("breaker did not trip past its limit")
+	}
+}
+
+func TestCloseReleasesBufferedBytes(t *testing.T) {
+	sub, budget := bufferedSubscriber(t, DROP_OLDEST, 10, 10)
+	if got := budget.BytesInFlight(); got != 10*messageBytes {
+		t.Fatalf("%d bytes charged, want %d", got, 10*messageBytes)
+	}
+
+	sub.Close()
+	if got := budget.BytesInFlight(); got != 0 {
+		t.Fatalf("%d bytes charged after close", got)
+	}
+
+	// Whatever the closed buffer still gives up is not released twice.
+	bufferedOffsets(sub)
+	if got := budget.BytesInFlight(); got != 0 {
+		t.Fatalf("%d bytes charged after draining a closed buffer", got)
+	}
+}
@@ -1,10 +1,15 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/wal"
 )
 
 type Topic struct {
@@ -12,23 +17,81 @@ type Topic struct {
 	tenantID string
 
 	subscribers map[string]*Subscriber
+	groups      map[string]*consumerGroup
 	subMutex    sync.RWMutex
 
+	// ring is read by broadcast subscribers through their own cursors;
+	// delivery lists everyone else Publish must hand a message to, rebuilt
+	// whenever membership changes so publishing never walks the map.
+	ring     *topicRing
+	delivery atomic.Pointer[deliverySet]
+
 	recentCache *RecentMessageCache
 
+	// publishMu orders publishes: offsets, the log, the ring and the cache.
+	// fanOutMu is handed over from it before it is released, so messages
+	// still reach queued subscribers and groups in offset order while the
+	// next publish is already writing its log record.
+	log        *wal.TopicLog
+	publishMu  sync.Mutex
+	fanOutMu   sync.Mutex
+	nextOffset atomic.Uint64
+
+	// retired is set, under publishMu, once the manager has dropped the
+	// topic; Publish and Subscribe then refuse it with errTopicRetired.
+	retired bool
+
+	matchWildcards func() []*Subscriber
+	deadLetter     func(DeadLetter)
+	deadLettered   atomic.Int64
+
+	// deadLettersDropped counts losses the dead-letter queue had no room for.
+	deadLettersDropped atomic.Int64
+
 	messagesPublished atomic.Int64
 	totalSubscribers  atomic.Int64
 	createdAt         time.Time
 }
 
-func NewTopic(name, tenantID string, cahcheSize int) *Topic {
-	return &Topic{
+// errTopicRetired is returned for a topic the manager dropped after its
+// caller looked it up; the caller looks the topic up again.
+var errTopicRetired = errors.New("topic retired")
+
+// deliverySet is who Publish hands each message to directly: subscribers
+// with a queue of their own, and consumer groups. readers are the ring
+// readers, looked at only when a publish is about to evict from the ring.
+type deliverySet struct {
+	queued  []*Subscriber
+	groups  []*consumerGroup
+	readers []*Subscriber
+}
+
+// NewTopic creates a topic whose ring holds up to ringSize messages. With a
+// budget, the ring is also capped at the largest subscriber byte budget and
+// charges what it holds against the global one.
+func NewTopic(name, tenantID string, cahcheSize, ringSize int, budget *buffer.AddaptiveBufferManager, log *wal.TopicLog) *Topic {
+	var ringBytes int64
+	if budget != nil {
+		ringBytes = budget.MaxSubscriberBytes()
+	}
+
+	t := &Topic{
 		name:        name,
 		tenantID:    tenantID,
 		subscribers: make(map[string]*Subscriber),
+		groups:      make(map[string]*consumerGroup),
+		ring:        newTopicRing(ringSize, ringBytes, budget),
 		recentCache: NewRecentMessageCache(cahcheSize),
+		log:         log,
 		createdAt:   time.Now(),
 	}
+	t.delivery.Store(&deliverySet{})
+
+	if log != nil {
+		t.nextOffset.Store(log.NextOffset())
+	}
+
+	return t
 }
 
 func (t *Topic) getSubscribersSnapshot() []*Subscriber {
@@ -43,42 +106,227 @@ func (t *Topic) getSubscribersSnapshot() []*Subscriber {
 	return snapshot
 }
 
-func (t *Topic) Publish(msg Message) error {
-	t.messagesPublished.Add(1)
+// refreshDelivery rebuilds the delivery set after a membership change.
+// Callers hold subMutex for writing.
+func (t *Topic) refreshDelivery() {
+	set := &deliverySet{
+		groups: make([]*consumerGroup, 0, len(t.groups)),
+	}
+	for _, sub := range t.subscribers {
+		if sub.cursor != nil {
+			set.readers = append(set.readers, sub)
+		} else if sub.Group == "" {
+			set.queued = append(set.queued, sub)
+		}
+	}
+	for _, group := range t.groups {
+		set.groups = append(set.groups, group)
+	}
+	t.delivery.Store(set)
+}
 
+func (t *Topic) Publish(msg Message) (uint64, error) {
+	t.publishMu.Lock()
+	if t.retired {
+		t.publishMu.Unlock()
+		return 0, errTopicRetired
+	}
+
+	// Encoded once here; the log, the byte budgets and every transport
+	// share the result.
+	msg.Offset = t.nextOffset.Load()
+	msg.encoded = newEncodedForms()
+	if err := t.appendToLog(&msg); err != nil {
+		t.publishMu.Unlock()
+		return 0, err
+	}
+	t.nextOffset.Store(msg.Offset + 1)
+	if msg.encodedSize == 0 {
+		msg.encodedSize = msg.encodedBytes()
+	}
+
+	// One write reaches every ring reader, however many there are; only
+	// subscribers with their own queue and groups are handed the message.
+	// None of these block, so they run inline.
+	t.overrunReaders(msg.encodedBytes())
+	t.ring.append(msg)
 	t.recentCache.Add(msg)
+	t.messagesPublished.Add(1)
 
-	subscribers := t.getSubscribersSnapshot()
+	t.fanOutMu.Lock()
+	t.publishMu.Unlock()
+	defer t.fanOutMu.Unlock()
 
-	if len(subscribers) == 0 {
+	delivery := t.delivery.Load()
+	for _, sub := range delivery.queued {
+		t.sendTo(sub, msg)
+	}
+	if t.matchWildcards != nil {
+		for _, sub := range t.matchWildcards() {
+			t.sendTo(sub, msg)
+		}
+	}
+	for _, group := range delivery.groups {
+		if err := group.deliver(msg); err != nil {
+			fmt.Printf("Failed to deliver to group %s: %v\n", group.name, err)
+			t.reportGroupDeadLetter(group, msg)
+		}
+	}
+
+	return msg.Offset, nil
+}
+
+func (t *Topic) sendTo(sub *Subscriber, msg Message) {
+	if err := sub.SendMessages(msg); err != nil {
+		fmt.Printf("Failed to send to subscriber %s: %v\n", sub.ID, err)
+	}
+}
+
+// appendToLog makes the message durable (when a log is attached) before it is
+// cached and fanned out, so the cache never holds anything the log is missing.
+// Callers must hold publishMu.
+func (t *Topic) appendToLog(msg *Message) error {
+	if t.log == nil {
 		return nil
 	}
 
-	var wg sync.WaitGroup
+	record, err := msg.encodeJSON()
+	if err != nil {
+		return fmt.Errorf("encode message %s: %w", msg.Id, err)
+	}
 
-	for _, sub := range subscribers {
-		wg.Add(1)
-		go func(s *Subscriber) {
-			defer wg.Done()
-			if err := s.SendMessages(msg); err != nil {
-				fmt.Printf("Failed to send to subscriber %s: %v\n", s.ID, err)
-			}
-		}(sub)
+	offset, err := t.log.Append(record)
+	if err != nil {
+		return fmt.Errorf("write ahead log: %w", err)
 	}
 
-	wg.Wait()
+	msg.Offset = offset
+	msg.encodedSize = int64(len(record))
 	return nil
 }
 
-func (t *Topic) sendRecentMessages(sub *Subscriber) {
-	recent := t.recentCache.GetLast(50)
+// recover rebuilds the recent cache from the tail of the attached log.
+func (t *Topic) recover() (int, error) {
+	if t.log == nil {
+		return 0, nil
+	}
+
+	t.publishMu.Lock()
+	defer t.publishMu.Unlock()
+
+	next := t.log.NextOffset()
+	from := t.log.FirstOffset()
+	if next-from > uint64(t.recentCache.size) {
+		from = next - uint64(t.recentCache.size)
+	}
+
+	restored := 0
+	err := t.log.ReadFrom(from, 0, func(offset uint64, record []byte) error {
+		msg, err := decodeRecord(offset, record)
+		if err != nil {
+			fmt.Printf("Skipping undecodable record %d in %s:%s: %v\n", offset, t.tenantID, t.name, err)
+			return nil
+		}
+		t.recentCache.Add(msg)
+		restored++
+		return nil
+	})
+
+	t.nextOffset.Store(next)
+	return restored, err
+}
+
+func decodeRecord(offset uint64, record []byte) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal(record, &msg); err != nil {
+		return msg, err
+	}
+	msg.Offset = offset
+	msg.encodedSize = int64(len(record))
+	msg.encoded = newEncodedForms().withJSON(record)
+	return msg, nil
+}
 
-	for _, msg := range recent {
-		if err := sub.SendMessages(msg); err != nil {
-			fmt.Printf("Failed to send recent message to %s: %v\n", sub.ID, err)
-			return
+// firstOffset is the oldest offset that can still be replayed.
+func (t *Topic) firstOffset() uint64 {
+	if t.log != nil {
+		return t.log.FirstOffset()
+	}
+	if oldest, ok := t.recentCache.OldestOffset(); ok {
+		return oldest
+	}
+	return t.nextOffset.Load()
+}
+
+func (t *Topic) resolveStart(pos StartPosition, liveFrom uint64) uint64 {
+	first := t.firstOffset()
+
+	var start uint64
+	switch pos.Mode {
+	case StartLatest:
+		return liveFrom
+	case StartEarliest:
+		start = first
+	case StartAtOffset:
+		start = pos.Offset
+	default:
+		start = first
+		if liveFrom > DefaultReplayCount && liveFrom-DefaultReplayCount > first {
+			start = liveFrom - DefaultReplayCount
 		}
 	}
+
+	if start > liveFrom {
+		start = liveFrom
+	}
+	return start
+}
+
+// replay sends every message in [start, end) in offset order, preferring the
+// recent cache and falling back to the write-ahead log for older offsets.
+// When the oldest offsets asked for are gone, gap is told before the rest
+// are sent, rather than the stream silently starting later.
+func (t *Topic) replay(start, end uint64, send func(Message) error, gap func(from, first uint64) error) error {
+	if start >= end {
+		return nil
+	}
+
+	msgs, covered := t.recentCache.GetRange(start, end)
+	if !covered && t.log != nil {
+		if first := t.log.FirstOffset(); first > start {
+			if err := gap(start, min(first, end)); err != nil {
+				return err
+			}
+			start = first
+		}
+		if start >= end {
+			return nil
+		}
+		return t.log.ReadFrom(start, int(end-start), func(offset uint64, record []byte) error {
+			msg, err := decodeRecord(offset, record)
+			if err != nil {
+				fmt.Printf("Skipping undecodable record %d in %s:%s: %v\n", offset, t.tenantID, t.name, err)
+				return nil
+			}
+			return send(msg)
+		})
+	}
+
+	if !covered {
+		first := end
+		if len(msgs) > 0 {
+			first = msgs[0].Offset
+		}
+		if err := gap(start, first); err != nil {
+			return err
+		}
+	}
+	for _, msg := range msgs {
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (t *Topic) Subscribe(sub *Subscriber) error {
@@ -88,17 +336,53 @@ func (t *Topic) Subscribe(sub *Subscriber) error {
 			sub.ID, sub.TenantID, t.tenantID)
 	}
 
+	// Registering under publishMu pins the boundary between replayed and live
+	// messages: everything below liveFrom is replayed, everything from it on
+	// arrives through the subscriber's buffer, with no gap or duplicate.
+	// Waiting for the fan-out as well keeps a new queued subscriber from
+	// being handed a message below liveFrom, which it also replays.
+	t.publishMu.Lock()
+	if t.retired {
+		t.publishMu.Unlock()
+		return errTopicRetired
+	}
+	t.fanOutMu.Lock()
+	liveFrom := t.nextOffset.Load()
 	t.subMutex.Lock()
 	t.subscribers[sub.ID] = sub
+	sub.deadLetter = t.deadLetter
+	if sub.readsRing() {
+		sub.attachRing(t.ring, liveFrom)
+	}
+	if sub.Group != "" {
+		group, exists := t.groups[sub.Group]
+		if !exists {
+			group = newConsumerGroup(sub.Group, sub.groupBalance)
+			t.groups[sub.Group] = group
+		}
+		group.add(sub)
+	}
+	t.refreshDelivery()
+	subCount := len(t.subscribers)
 	t.subMutex.Unlock()
+	t.fanOutMu.Unlock()
+	t.publishMu.Unlock()
 
 	t.totalSubscribers.Add(1)
 
-	sub.Start()
-	go t.sendRecentMessages(sub)
+	// Group members share one stream, so replaying history to each of them
+	// would deliver it more than once; they always start live.
+	start := liveFrom
+	if sub.Group == "" {
+		start = t.resolveStart(sub.startPosition, liveFrom)
+	}
+	sub.startOffset = start
+	sub.Start(func(send func(Message) error) error {
+		return t.replay(start, liveFrom, send, sub.reportGap)
+	})
 
-	fmt.Printf("Subscriber %s joined topic %s:%s (total: %d)\n",
-		sub.ID, t.tenantID, t.name, len(t.subscribers))
+	fmt.Printf("Subscriber %s joined topic %s:%s at offset %d (total: %d)\n",
+		sub.ID, t.tenantID, t.name, start, subCount)
 
 	return nil
 }
@@ -116,12 +400,78 @@ func (t *Topic) Unsubscribe(subscriberID string) error {
 
 	sub.Close()
 
+	if group, exists := t.groups[sub.Group]; exists && sub.Group != "" {
+		if group.remove(subscriberID) == 0 {
+			delete(t.groups, sub.Group)
+			sub.discardSpill()
+		} else {
+			go t.rebalance(group, sub)
+		}
+	}
+	t.refreshDelivery()
+
 	fmt.Printf("Subscriber %s left topic %s:%s (remaining: %d)\n",
 		subscriberID, t.tenantID, t.name, len(t.subscribers))
 
 	return nil
 }
 
+// retireIfIdle retires the topic if it has no log, no subscribers and has
+// never been published to, so dropping it loses nothing. The manager calls
+// it under its own lock, dropping the topic when it returns true.
+func (t *Topic) retireIfIdle() bool {
+	if t.log != nil {
+		return false
+	}
+
+	t.publishMu.Lock()
+	defer t.publishMu.Unlock()
+	t.subMutex.RLock()
+	defer t.subMutex.RUnlock()
+
+	if len(t.subscribers) > 0 || len(t.groups) > 0 || t.nextOffset.Load() > 0 {
+		return false
+	}
+	t.retired = true
+	return true
+}
+
+// reportGroupDeadLetter records a message no group member could take. Drops
+// made by a member's own drop strategy are reported by the member itself.
+func (t *Topic) reportGroupDeadLetter(group *consumerGroup, msg Message) {
+	if t.deadLetter == nil || len(group.candidates()) > 0 {
+		return
+	}
+	t.deadLetter(DeadLetter{
+		Message:      msg,
+		Reason:       ReasonUndeliverable,
+		SubscriberID: "group:" + group.name,
+	})
+}
+
+// rebalance hands messages still sitting in a departed member's buffer to
+// the rest of its group so they are not lost with the connection.
+func (t *Topic) rebalance(group *consumerGroup, departed *Subscriber) {
+	// Drain only once the departed member's send loop has stopped taking
+	// from its buffer, and once any fan-out that picked it before it left
+	// the group has finished handing it messages.
+	<-departed.Stopped()
+	t.fanOutMu.Lock()
+	t.fanOutMu.Unlock()
+
+	pending := departed.drainPending()
+	for _, msg := range pending {
+		if err := group.deliver(msg); err != nil {
+			fmt.Printf("Failed to rebalance message %d to group %s: %v\n", msg.Offset, group.name, err)
+			t.reportGroupDeadLetter(group, msg)
+		}
+	}
+
+	if len(pending) > 0 {
+		fmt.Printf("Rebalanced %d pending messages from %s to group %s\n", len(pending), departed.ID, group.name)
+	}
+}
+
 func (t *Topic) GetSubscriberCount() int {
 	t.subMutex.RLock()
 	defer t.subMutex.RUnlock()
@@ -142,6 +492,10 @@ func (t *Topic) GetSlowSubscriberCount() int {
 	return count
 }
 
+func (t *Topic) NextOffset() uint64 {
+	return t.nextOffset.Load()
+}
+
 func (t *Topic) GetTenantID() string {
 	return t.tenantID
 }
@@ -150,17 +504,95 @@ func (t *Topic) GetName() string {
 	return t.name
 }
 
+// overrunReaders lets every ring reader that has not reached the messages
+// the next append will evict apply its drop strategy to them first. The
+// ring is trimmed to its slowest reader, so this only happens once a reader
+// lags by a whole ring. Callers hold publishMu.
+func (t *Topic) overrunReaders(size int64) {
+	tail, _ := t.ring.bounds()
+	evictTo := t.ring.evictionPoint(size)
+	if evictTo <= tail {
+		return
+	}
+	for _, sub := range t.delivery.Load().readers {
+		if sub.cursor.position() < evictTo {
+			sub.overrun(evictTo)
+		}
+	}
+}
+
+// trimRing releases the ring messages every reader has already passed. The
+// head is read before the readers so one joining meanwhile, which starts at
+// or after it, is never trimmed past.
+func (t *Topic) trimRing() {
+	_, low := t.ring.bounds()
+	for _, sub := range t.getSubscribersSnapshot() {
+		if sub.cursor != nil {
+			low = min(low, sub.cursor.position())
+		}
+	}
+	t.ring.trim(low)
+}
+
+// BytesInFlight is the encoded size of every message buffered for this
+// topic: the ring, once, plus whatever queued subscribers, and ring readers
+// it evicted from under, hold.
+func (t *Topic) BytesInFlight() int64 {
+	total := t.ring.Bytes()
+	for _, sub := range t.getSubscribersSnapshot() {
+		if sub.cursor == nil {
+			total += sub.BufferedBytes()
+		} else {
+			total += sub.cursor.heldSize()
+		}
+	}
+	return total
+}
+
 func (t *Topic) GetMetrics() map[string]interface{} {
+	bytesInFlight := t.BytesInFlight()
+
 	t.subMutex.RLock()
 	subCount := len(t.subscribers)
+	codecCounts := make(map[string]int)
+	var filtering int
+	var filtered int64
+	var projecting int
+	var trimmed int64
+	for _, sub := range t.subscribers {
+		codecCounts[sub.CodecName()]++
+		if sub.filter != nil {
+			filtering++
+			filtered += sub.MessagesFiltered()
+		}
+		if sub.projection != nil {
+			projecting++
+			trimmed += sub.BytesTrimmed()
+		}
+	}
+	groupMetrics := make([]map[string]interface{}, 0, len(t.groups))
+	for _, group := range t.groups {
+		groupMetrics = append(groupMetrics, group.GetMetrics())
+	}
 	t.subMutex.RUnlock()
 
 	return map[string]interface{}{
-		"name":               t.name,
-		"tenant_id":          t.tenantID,
-		"messages_published": t.messagesPublished.Load(),
-		"active_subscribers": subCount,
-		"total_subscribers":  t.totalSubscribers.Load(),
-		"created_at":         t.createdAt,
+		"name":                 t.name,
+		"tenant_id":            t.tenantID,
+		"messages_published":   t.messagesPublished.Load(),
+		"next_offset":          t.NextOffset(),
+		"active_subscribers":   subCount,
+		"total_subscribers":    t.totalSubscribers.Load(),
+		"codecs":               codecCounts,
+		"filtering":            filtering,
+		"messages_filtered":    filtered,
+		"projecting":           projecting,
+		"bytes_trimmed":        trimmed,
+		"bytes_in_flight":      bytesInFlight,
+		"ring":                 t.ring.GetMetrics(),
+		"groups":               groupMetrics,
+		"dead_lettered":        t.deadLettered.Load(),
+		"dead_letters_dropped": t.deadLettersDropped.Load(),
+		"created_at":           t.createdAt,
 	}
 }
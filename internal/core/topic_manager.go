@@ -1,44 +1,157 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/AadityaChoubey68/clevr-live/internal/acl"
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
 	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/quota"
+	"github.com/AadityaChoubey68/clevr-live/internal/spill"
 	"github.com/AadityaChoubey68/clevr-live/internal/throttle"
+	"github.com/AadityaChoubey68/clevr-live/internal/wal"
 )
 
+type TopicManagerConfig struct {
+	CacheSize int
+	Log       *wal.Log
+
+	// RingSize is how many messages each topic's fan-out ring holds for
+	// subscribers to read.
+	RingSize int
+
+	// DeadLetterTopics lists topics whose lost messages are republished on
+	// "<topic>.dlq"; "*" enables it for every topic.
+	DeadLetterTopics []string
+
+	// ACL, if set, is checked on every Publish and Subscribe.
+	ACL *acl.Store
+
+	// Quotas limits tenants and principals; without one, usage is still
+	// tracked but nothing is limited.
+	Quotas *quota.Manager
+
+	// Spill backs the SPILL_TO_DISK drop strategy, which is refused when it
+	// is nil. SpillFallback applies when a spill queue hits its disk limit.
+	Spill         *spill.Manager
+	SpillFallback DropStrategy
+}
+
+func DefaultTopicManagerConfig() TopicManagerConfig {
+	return TopicManagerConfig{
+		CacheSize:     100,
+		RingSize:      DefaultRingSize,
+		SpillFallback: DROP_NEWEST,
+	}
+}
+
 type TopicManager struct {
 	bufferManager *buffer.AddaptiveBufferManager
 	throttler     *throttle.AdaptiveThrottler
+	config        TopicManagerConfig
 
 	topics map[string]*Topic
 	mu     sync.RWMutex
 
+	// creating holds topics whose write-ahead log is being opened, which
+	// is disk I/O and so happens outside mu.
+	creating map[string]*pendingTopic
+
+	wildcards  map[string]*subjectTrie
+	wildcardMu sync.RWMutex
+
+	// deadLetters queues losses for republishing, so a slow or blocked
+	// dead-letter topic never holds up the topic the loss happened on.
+	deadLetters chan deadLetterJob
+
+	// resizedSlots is the buffer size last pushed to live subscribers.
+	resizedSlots atomic.Int64
+
 	shutDownChan chan struct{}
 	shutDownOnce sync.Once
 }
 
-func NewTopicManager(buffer *buffer.AddaptiveBufferManager, throttle *throttle.AdaptiveThrottler) *TopicManager {
+// deadLetterQueueSize bounds the losses waiting to be republished; past it
+// further losses are counted and logged, not dead-lettered.
+const deadLetterQueueSize = 4096
+
+type deadLetterJob struct {
+	topic *Topic
+	dl    DeadLetter
+}
+
+func NewTopicManager(buffer *buffer.AddaptiveBufferManager, throttle *throttle.AdaptiveThrottler, config TopicManagerConfig) *TopicManager {
+	if config.CacheSize <= 0 {
+		config.CacheSize = DefaultTopicManagerConfig().CacheSize
+	}
+	if config.RingSize <= 0 {
+		config.RingSize = DefaultRingSize
+	}
+	if config.Quotas == nil {
+		config.Quotas = quota.NewManager(quota.Config{})
+	}
+
 	tm := &TopicManager{
 		bufferManager: buffer,
 		throttler:     throttle,
+		config:        config,
 
 		topics:       make(map[string]*Topic),
+		creating:     make(map[string]*pendingTopic),
+		wildcards:    make(map[string]*subjectTrie),
+		deadLetters:  make(chan deadLetterJob, deadLetterQueueSize),
 		shutDownChan: make(chan struct{}),
 	}
 
+	buffer.SetResizeHandler(tm.resizeSubscribers)
+
 	go tm.monitoLoop()
+	go tm.deadLetterLoop()
 
 	return tm
 }
 
+// resizeSubscribers pushes new buffer limits to every live subscriber.
+func (tm *TopicManager) resizeSubscribers(slots int, subscriberBytes int64) {
+	tm.mu.RLock()
+	topics := make([]*Topic, 0, len(tm.topics))
+	for _, topic := range tm.topics {
+		topics = append(topics, topic)
+	}
+	tm.mu.RUnlock()
+
+	resized := 0
+	for _, topic := range topics {
+		for _, sub := range topic.getSubscribersSnapshot() {
+			sub.Resize(slots, subscriberBytes)
+			resized++
+		}
+	}
+	for _, sub := range tm.getWildcardSubscribers() {
+		sub.Resize(slots, subscriberBytes)
+		resized++
+	}
+
+	// The byte limit follows free memory and moves on nearly every
+	// recalculation, so only a change in slots is worth a log line.
+	if previous := tm.resizedSlots.Swap(int64(slots)); previous != int64(slots) {
+		fmt.Printf("Resized %d subscriber buffers to %d messages / %d bytes\n", resized, slots, subscriberBytes)
+	}
+}
+
 func (tm *TopicManager) makeTopicKey(tenantID, topicName string) string {
 	return fmt.Sprintf("%s:%s", tenantID, topicName)
 }
 
-func (tm *TopicManager) getOrCreateTopic(tenant_id, topic_name string) (*Topic, error) {
+// getOrCreateTopic returns the topic, creating it if needed. Topics created
+// on behalf of a client count against the tenant's max_topics quota; those
+// the server creates itself, on recovery or for dead letters, are never
+// refused.
+func (tm *TopicManager) getOrCreateTopic(tenant_id, topic_name string, enforceQuota bool) (*Topic, error) {
 
 	topicKey := tm.makeTopicKey(tenant_id, topic_name)
 
@@ -51,47 +164,447 @@ func (tm *TopicManager) getOrCreateTopic(tenant_id, topic_name string) (*Topic,
 	}
 
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
 	topic, exists = tm.topics[topicKey]
 	if exists {
+		tm.mu.Unlock()
 		return topic, nil
 	}
+	if pending, ok := tm.creating[topicKey]; ok {
+		tm.mu.Unlock()
+		<-pending.done
+		return pending.topic, pending.err
+	}
+
+	if enforceQuota {
+		if err := tm.config.Quotas.ReserveTopic(tenant_id); err != nil {
+			tm.mu.Unlock()
+			return nil, err
+		}
+	} else {
+		tm.config.Quotas.AddTopic(tenant_id)
+	}
+	pending := &pendingTopic{done: make(chan struct{})}
+	tm.creating[topicKey] = pending
+	tm.mu.Unlock()
+
+	pending.topic, pending.err = tm.newTopic(tenant_id, topic_name)
+
+	tm.mu.Lock()
+	if pending.err == nil {
+		tm.topics[topicKey] = pending.topic
+	} else {
+		tm.config.Quotas.ReleaseTopic(tenant_id)
+	}
+	delete(tm.creating, topicKey)
+	tm.mu.Unlock()
+	close(pending.done)
 
-	topic = NewTopic(topic_name, tenant_id, 100)
+	if pending.err == nil {
+		fmt.Printf("Created new topic: %s\n", topicKey)
+	}
+	return pending.topic, pending.err
+}
 
-	tm.topics[topicKey] = topic
+// pendingTopic is a topic being created, which other callers for the same
+// key wait for.
+type pendingTopic struct {
+	done  chan struct{}
+	topic *Topic
+	err   error
+}
 
-	fmt.Printf("Created new topic: %s\n", topicKey)
+// newTopic builds a topic, opening its write-ahead log if there is one.
+func (tm *TopicManager) newTopic(tenant_id, topic_name string) (*Topic, error) {
+	var log *wal.TopicLog
+	if tm.config.Log != nil {
+		var err error
+		log, err = tm.config.Log.Topic(tenant_id, topic_name)
+		if err != nil {
+			return nil, fmt.Errorf("open log for %s: %w", tm.makeTopicKey(tenant_id, topic_name), err)
+		}
+	}
+
+	topic := NewTopic(topic_name, tenant_id, tm.config.CacheSize, tm.config.RingSize, tm.bufferManager, log)
+	if tm.hasDeadLetterTopic(topic_name) {
+		topic.deadLetter = func(dl DeadLetter) {
+			tm.queueDeadLetter(topic, dl)
+		}
+	}
 
+	topic.matchWildcards = func() []*Subscriber {
+		return tm.matchWildcards(tenant_id, topic_name)
+	}
 	return topic, nil
 }
 
-func (tm *TopicManager) Publish(tenant_id, topic_name string, msg Message) error {
-	topic, err := tm.getOrCreateTopic(tenant_id, topic_name)
+func (tm *TopicManager) hasDeadLetterTopic(topic_name string) bool {
+	if IsDeadLetterTopic(topic_name) {
+		return false
+	}
+	for _, name := range tm.config.DeadLetterTopics {
+		if name == "*" || name == topic_name {
+			return true
+		}
+	}
+	return false
+}
+
+// queueDeadLetter hands a loss to the dead-letter loop. It is called from
+// publish and delivery paths, so it never blocks.
+func (tm *TopicManager) queueDeadLetter(topic *Topic, dl DeadLetter) {
+	select {
+	case tm.deadLetters <- deadLetterJob{topic: topic, dl: dl}:
+	default:
+		topic.deadLettersDropped.Add(1)
+		fmt.Printf("Dead-letter queue full, dropping %s from %s:%s\n", dl.Message.Id, topic.tenantID, topic.name)
+	}
+}
+
+func (tm *TopicManager) deadLetterLoop() {
+	for {
+		select {
+		case job := <-tm.deadLetters:
+			tm.publishDeadLetter(job.topic, job.dl)
+		case <-tm.shutDownChan:
+			return
+		}
+	}
+}
+
+func (tm *TopicManager) publishDeadLetter(topic *Topic, dl DeadLetter) {
+	msg, err := dl.toMessage()
+	if err == nil {
+		_, err = tm.publish(topic.tenantID, msg.Topic, msg)
+	}
 	if err != nil {
+		fmt.Printf("Failed to dead-letter message %s from %s:%s: %v\n", dl.Message.Id, topic.tenantID, topic.name, err)
+		return
+	}
+	topic.deadLettered.Add(1)
+}
+
+// reportDeadLetter routes a loss seen by a wildcard subscriber to the
+// dead-letter topic of the message's own topic, if it has one.
+func (tm *TopicManager) reportDeadLetter(tenant_id string, dl DeadLetter) {
+	topic, err := tm.GetTopic(tenant_id, dl.Message.Topic)
+	if err != nil || topic.deadLetter == nil {
+		return
+	}
+	topic.deadLetter(dl)
+}
+
+func (tm *TopicManager) getWildcardTrie(tenant_id string, create bool) *subjectTrie {
+	tm.wildcardMu.RLock()
+	trie, exists := tm.wildcards[tenant_id]
+	tm.wildcardMu.RUnlock()
+
+	if exists || !create {
+		return trie
+	}
+
+	tm.wildcardMu.Lock()
+	defer tm.wildcardMu.Unlock()
+
+	if trie, exists = tm.wildcards[tenant_id]; !exists {
+		trie = newSubjectTrie()
+		tm.wildcards[tenant_id] = trie
+	}
+	return trie
+}
+
+// matchWildcards returns the wildcard subscribers whose pattern matches
+// topic_name and who may read it. A pattern is authorized as a whole when it
+// subscribes, but it can match topics the ACL does not allow on their own
+// ("orders.#" matches "orders"), so each topic is checked as it is matched.
+func (tm *TopicManager) matchWildcards(tenant_id, topic_name string) []*Subscriber {
+	trie := tm.getWildcardTrie(tenant_id, false)
+	if trie == nil {
+		return nil
+	}
+	matched := trie.match(topic_name)
+	if tm.config.ACL == nil {
+		return matched
+	}
+
+	allowed := matched[:0]
+	for _, sub := range matched {
+		if sub.mayRead(tm.config.ACL, topic_name) {
+			allowed = append(allowed, sub)
+		}
+	}
+	return allowed
+}
+
+func (tm *TopicManager) getWildcardSubscribers() []*Subscriber {
+	tm.wildcardMu.RLock()
+	defer tm.wildcardMu.RUnlock()
+
+	var subscribers []*Subscriber
+	for _, trie := range tm.wildcards {
+		subscribers = append(subscribers, trie.snapshot()...)
+	}
+	return subscribers
+}
+
+// subscribeWildcard registers a pattern subscription. It is matched on every
+// publish, so topics created later are picked up automatically. Wildcard
+// subscribers always start live.
+func (tm *TopicManager) subscribeWildcard(tenant_id, pattern string, sub *Subscriber) error {
+	if sub.Group != "" {
+		return fmt.Errorf("consumer groups are not supported on wildcard subscriptions")
+	}
+
+	sub.deadLetter = func(dl DeadLetter) {
+		tm.reportDeadLetter(tenant_id, dl)
+	}
+
+	if err := tm.prepareBuffer(sub); err != nil {
+		return err
+	}
+
+	if err := tm.getWildcardTrie(tenant_id, true).insert(pattern, sub); err != nil {
+		sub.discardSpill()
 		return err
 	}
 
-	return topic.Publish(msg)
+	tm.bufferManager.AddNewSubscriber()
+	sub.Start(nil)
+
+	fmt.Printf("Subscriber %s joined pattern %s:%s\n", sub.ID, tenant_id, pattern)
+	return nil
 }
 
-func (tm *TopicManager) Subscribe(tenant_id, topic_name, subscriberID string, sub *Subscriber) error {
+func (tm *TopicManager) unsubscribeWildcard(tenant_id, pattern, subscriberID string) error {
+	trie := tm.getWildcardTrie(tenant_id, false)
+	if trie == nil {
+		return fmt.Errorf("subscriber %s not found", subscriberID)
+	}
+
+	sub, exists := trie.remove(pattern, subscriberID)
+	if !exists {
+		return fmt.Errorf("subscriber %s not found", subscriberID)
+	}
+
+	sub.Close()
+	tm.bufferManager.OnSubscriberRemoval()
+
+	fmt.Printf("Subscriber %s left pattern %s:%s\n", subscriberID, tenant_id, pattern)
+	return nil
+}
+
+// Recover recreates every topic found in the write-ahead log and refills its
+// recent message cache. It is a no-op when no log is configured.
+func (tm *TopicManager) Recover() error {
+	if tm.config.Log == nil {
+		return nil
+	}
+
+	for _, tl := range tm.config.Log.Topics() {
+		topic, err := tm.getOrCreateTopic(tl.TenantID, tl.Topic, false)
+		if err != nil {
+			return err
+		}
+
+		restored, err := topic.recover()
+		if err != nil {
+			return fmt.Errorf("recover %s: %w", tm.makeTopicKey(tl.TenantID, tl.Topic), err)
+		}
+
+		fmt.Printf("Recovered topic %s with %d cached messages\n", tm.makeTopicKey(tl.TenantID, tl.Topic), restored)
+	}
+
+	return nil
+}
+
+// ReauthorizeSubscribers checks every live subscription against the ACL
+// as it now stands, after a reload, and closes those it no longer allows.
+// It returns how many were closed.
+func (tm *TopicManager) ReauthorizeSubscribers() int {
+	if tm.config.ACL == nil {
+		return 0
+	}
+
+	tm.mu.RLock()
+	var subscribers []*Subscriber
+	for _, topic := range tm.topics {
+		subscribers = append(subscribers, topic.getSubscribersSnapshot()...)
+	}
+	tm.mu.RUnlock()
+	subscribers = append(subscribers, tm.getWildcardSubscribers()...)
+
+	revoked := 0
+	for _, sub := range subscribers {
+		err := tm.Authorize(sub.identity, acl.Subscribe, sub.Topic)
+		if !errors.Is(err, acl.ErrDenied) {
+			continue
+		}
+		fmt.Printf("Closing subscriber %s on %s:%s: %v\n", sub.ID, sub.TenantID, sub.Topic, err)
+		sub.revoke(err)
+		revoked++
+	}
+	return revoked
+}
+
+// Authorize checks the ACL, if one is configured, returning an error wrapping
+// acl.ErrDenied when id may not perform action on topic_name.
+func (tm *TopicManager) Authorize(id auth.Identity, action acl.Action, topic_name string) error {
+	if tm.config.ACL == nil {
+		return nil
+	}
+	return tm.config.ACL.Authorize(id, action, topic_name)
+}
+
+func (tm *TopicManager) Publish(id auth.Identity, topic_name string, msg Message) (uint64, error) {
+	if IsWildcard(topic_name) {
+		return 0, fmt.Errorf("cannot publish to wildcard topic %s", topic_name)
+	}
+
+	if err := tm.Authorize(id, acl.Publish, topic_name); err != nil {
+		return 0, err
+	}
+
+	size, err := msg.size()
+	if err != nil {
+		return 0, err
+	}
+	if err := tm.config.Quotas.AllowPublish(id, size); err != nil {
+		return 0, err
+	}
+
+	// Admission comes last, so the throttler only counts publishes that
+	// would otherwise go through. A message refused from here on is not
+	// charged to the quota.
+	if err := tm.throttler.Admit(); err != nil {
+		tm.config.Quotas.RefundPublish(id, size)
+		return 0, err
+	}
+
+	offset, err := tm.publishTo(id.TenantID, topic_name, true, stampHeaders(msg, id.Principal, time.Now()))
+	if err != nil {
+		tm.config.Quotas.RefundPublish(id, size)
+		return 0, err
+	}
+	return offset, nil
+}
+
+// publish skips the ACL and quotas, for messages the server generates itself
+// such as dead letters.
+func (tm *TopicManager) publish(tenant_id, topic_name string, msg Message) (uint64, error) {
+	if IsWildcard(topic_name) {
+		return 0, fmt.Errorf("cannot publish to wildcard topic %s", topic_name)
+	}
+
+	return tm.publishTo(tenant_id, topic_name, false, msg)
+}
+
+// publishTo publishes msg to the topic, creating it if needed and looking it
+// up again if it was retired in between.
+func (tm *TopicManager) publishTo(tenant_id, topic_name string, enforceQuota bool, msg Message) (uint64, error) {
+	for {
+		topic, err := tm.getOrCreateTopic(tenant_id, topic_name, enforceQuota)
+		if err != nil {
+			return 0, err
+		}
+
+		offset, err := topic.Publish(msg)
+		if !errors.Is(err, errTopicRetired) {
+			return offset, err
+		}
+	}
+}
+
+func (tm *TopicManager) Subscribe(id auth.Identity, topic_name, subscriberID string, sub *Subscriber) error {
+	tenant_id := id.TenantID
 	if sub.TenantID != tenant_id {
 		return fmt.Errorf("tenant mismatch")
 	}
 
-	topic, err := tm.getOrCreateTopic(tenant_id, topic_name)
+	if err := tm.Authorize(id, acl.Subscribe, topic_name); err != nil {
+		return err
+	}
+	sub.identity = id
+
+	if err := tm.config.Quotas.ReserveSubscriber(tenant_id); err != nil {
+		return err
+	}
+
+	err := tm.subscribe(tenant_id, topic_name, sub)
+	if err != nil {
+		tm.config.Quotas.ReleaseSubscriber(tenant_id)
+	}
+	return err
+}
+
+func (tm *TopicManager) subscribe(tenant_id, topic_name string, sub *Subscriber) error {
+	if IsWildcard(topic_name) {
+		return tm.subscribeWildcard(tenant_id, topic_name, sub)
+	}
+
+	topic, err := tm.getOrCreateTopic(tenant_id, topic_name, true)
 	if err != nil {
 		return err
 	}
 
+	if err := tm.prepareBuffer(sub); err != nil {
+		return err
+	}
+
+	for {
+		err = topic.Subscribe(sub)
+		if !errors.Is(err, errTopicRetired) {
+			break
+		}
+		if topic, err = tm.getOrCreateTopic(tenant_id, topic_name, true); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		sub.discardSpill()
+		return err
+	}
+
 	tm.bufferManager.AddNewSubscriber()
+	return nil
+}
 
-	return topic.Subscribe(sub)
+// prepareBuffer attaches the byte budget and, for SPILL_TO_DISK, a spill
+// queue to a subscriber about to start receiving.
+func (tm *TopicManager) prepareBuffer(sub *Subscriber) error {
+	if sub.dropStrategy == SPILL_TO_DISK {
+		if tm.config.Spill == nil {
+			return fmt.Errorf("drop strategy spill is not enabled on this server")
+		}
+		sub.spillQueue = tm.config.Spill.NewQueue()
+		sub.spillFallback = tm.config.SpillFallback
+	}
+
+	sub.setByteBudget(tm.bufferManager)
+	return nil
+}
+
+// CheckSubscribe runs the ACL and subscriber quota checks Subscribe would,
+// without subscribing, so streaming handlers can refuse before they commit
+// to a response.
+func (tm *TopicManager) CheckSubscribe(id auth.Identity, topic_name string) error {
+	if err := tm.Authorize(id, acl.Subscribe, topic_name); err != nil {
+		return err
+	}
+	return tm.config.Quotas.CheckSubscriber(id.TenantID)
 }
 
 func (tm *TopicManager) Unsubscribe(tenant_id, topic_name, subscriberID string) error {
+	err := tm.unsubscribe(tenant_id, topic_name, subscriberID)
+	if err == nil {
+		tm.config.Quotas.ReleaseSubscriber(tenant_id)
+	}
+	return err
+}
+
+func (tm *TopicManager) unsubscribe(tenant_id, topic_name, subscriberID string) error {
+	if IsWildcard(topic_name) {
+		return tm.unsubscribeWildcard(tenant_id, topic_name, subscriberID)
+	}
+
 	topicKey := tm.makeTopicKey(tenant_id, topic_name)
 
 	tm.mu.RLock()
@@ -108,10 +621,27 @@ func (tm *TopicManager) Unsubscribe(tenant_id, topic_name, subscriberID string)
 	}
 
 	tm.bufferManager.OnSubscriberRemoval()
+	tm.retireIfIdle(topicKey, topic)
 
 	return nil
 }
 
+// retireIfIdle drops a topic its last subscriber has left if dropping it
+// loses nothing, returning it to the tenant's topic quota. Otherwise every
+// name a client ever subscribed to would count against max_topics for good.
+func (tm *TopicManager) retireIfIdle(topicKey string, topic *Topic) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if tm.topics[topicKey] != topic || !topic.retireIfIdle() {
+		return
+	}
+	delete(tm.topics, topicKey)
+	tm.config.Quotas.ReleaseTopic(topic.tenantID)
+
+	fmt.Printf("Removed idle topic: %s\n", topicKey)
+}
+
 func (tm *TopicManager) GetTopic(tenant_id, topic_name string) (*Topic, error) {
 	topicKey := tm.makeTopicKey(tenant_id, topic_name)
 
@@ -150,7 +680,7 @@ func (tm *TopicManager) GetTotalSubscriberCount() int {
 		total += topic.GetSubscriberCount()
 	}
 
-	return total
+	return total + len(tm.getWildcardSubscribers())
 }
 
 func (tm *TopicManager) GetSlowSubscriberCount() int {
@@ -162,9 +692,92 @@ func (tm *TopicManager) GetSlowSubscriberCount() int {
 		total += topic.GetSlowSubscriberCount()
 	}
 
+	for _, sub := range tm.getWildcardSubscribers() {
+		if sub.IsSlow() {
+			total++
+		}
+	}
+
 	return total
 }
 
+// GetTransportCounts reports how many subscribers are attached through each
+// transport, e.g. websocket, sse, ndjson or poll.
+func (tm *TopicManager) GetTransportCounts() map[string]int {
+	return tm.countSubscribers((*Subscriber).TransportName)
+}
+
+// GetCodecCounts reports how many subscribers receive each wire codec, e.g.
+// json, msgpack, cbor or proto.
+func (tm *TopicManager) GetCodecCounts() map[string]int {
+	return tm.countSubscribers((*Subscriber).CodecName)
+}
+
+// countSubscribers tallies every topic and wildcard subscriber by key.
+func (tm *TopicManager) countSubscribers(key func(*Subscriber) string) map[string]int {
+	tm.mu.RLock()
+	topics := make([]*Topic, 0, len(tm.topics))
+	for _, topic := range tm.topics {
+		topics = append(topics, topic)
+	}
+	tm.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, topic := range topics {
+		for _, sub := range topic.getSubscribersSnapshot() {
+			counts[key(sub)]++
+		}
+	}
+	for _, sub := range tm.getWildcardSubscribers() {
+		counts[key(sub)]++
+	}
+
+	return counts
+}
+
+// GetAverageBufferFill is the mean fraction of subscriber buffers in use
+// across every topic and wildcard subscription.
+func (tm *TopicManager) GetAverageBufferFill() float64 {
+	tm.mu.RLock()
+	topics := make([]*Topic, 0, len(tm.topics))
+	for _, topic := range tm.topics {
+		topics = append(topics, topic)
+	}
+	tm.mu.RUnlock()
+
+	var fill float64
+	count := 0
+	for _, topic := range topics {
+		for _, sub := range topic.getSubscribersSnapshot() {
+			fill += sub.BufferFill()
+			count++
+		}
+	}
+	for _, sub := range tm.getWildcardSubscribers() {
+		fill += sub.BufferFill()
+		count++
+	}
+
+	if count == 0 {
+		return 0
+	}
+	return fill / float64(count)
+}
+
+// trimRings hands back ring memory that every reader has moved past.
+func (tm *TopicManager) trimRings() {
+	tm.mu.RLock()
+	topics := make([]*Topic, 0, len(tm.topics))
+	for _, topic := range tm.topics {
+		topics = append(topics, topic)
+	}
+	tm.mu.RUnlock()
+
+	for _, topic := range topics {
+		topic.trimRing()
+	}
+}
+
 func (tm *TopicManager) monitoLoop() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -174,8 +787,10 @@ func (tm *TopicManager) monitoLoop() {
 		case <-ticker.C:
 			TotalSubCount := tm.GetTotalSubscriberCount()
 			SlowSubCount := tm.GetSlowSubscriberCount()
+			BufferFill := tm.GetAverageBufferFill()
 
-			tm.throttler.UpdateSubscriber(SlowSubCount, TotalSubCount)
+			tm.throttler.UpdateSubscriber(SlowSubCount, TotalSubCount, BufferFill)
+			tm.trimRings()
 
 		case <-tm.shutDownChan:
 			return
@@ -185,20 +800,108 @@ func (tm *TopicManager) monitoLoop() {
 }
 
 func (tm *TopicManager) GetMetrics() map[string]interface{} {
-	tm.mu.RLock()
-	defer tm.mu.RUnlock()
+	totalSubscribers := tm.GetTotalSubscriberCount()
+	slowSubscribers := tm.GetSlowSubscriberCount()
+	transportCounts := tm.GetTransportCounts()
+	codecCounts := tm.GetCodecCounts()
 
+	tm.mu.RLock()
 	topicMetrics := make([]map[string]interface{}, 0, len(tm.topics))
 	for _, topic := range tm.topics {
 		topicMetrics = append(topicMetrics, topic.GetMetrics())
 	}
+	topicCount := len(tm.topics)
+	tm.mu.RUnlock()
+
+	wildcardMetrics := make([]map[string]interface{}, 0)
+	for _, sub := range tm.getWildcardSubscribers() {
+		wildcardMetrics = append(wildcardMetrics, map[string]interface{}{
+			"id":        sub.ID,
+			"tenant_id": sub.TenantID,
+			"pattern":   sub.Topic,
+			"transport": sub.TransportName(),
+			"codec":     sub.CodecName(),
+			"metrics":   sub.GetMetrics(),
+		})
+	}
+
+	metrics := map[string]interface{}{
+		"total_topics":         topicCount,
+		"total_subscribers":    totalSubscribers,
+		"slow_subscribers":     slowSubscribers,
+		"bytes_in_flight":      tm.bufferManager.BytesInFlight(),
+		"transports":           transportCounts,
+		"codecs":               codecCounts,
+		"topics":               topicMetrics,
+		"wildcard_subscribers": wildcardMetrics,
+		"throttler_metrics":    tm.throttler.GetMetrics(),
+		"dead_letter_backlog":  len(tm.deadLetters),
+	}
+
+	if tm.config.Log != nil {
+		metrics["wal_metrics"] = tm.config.Log.GetMetrics()
+	}
+
+	if tm.config.Spill != nil {
+		metrics["spill_metrics"] = tm.config.Spill.GetMetrics()
+	}
+
+	if tm.config.ACL != nil {
+		metrics["acl_metrics"] = tm.config.ACL.GetMetrics()
+	}
+
+	metrics["tenant_usage"] = tm.config.Quotas.GetMetrics()
+
+	return metrics
+}
+
+// GetTenantMetrics reports the part of GetMetrics that belongs to one
+// tenant: its topics, wildcard subscribers and quota usage. Server-wide state
+// such as the throttler, WAL and spill is left out.
+func (tm *TopicManager) GetTenantMetrics(tenantID string) map[string]interface{} {
+	tm.mu.RLock()
+	topicMetrics := make([]map[string]interface{}, 0)
+	totalSubscribers, slowSubscribers := 0, 0
+	for _, topic := range tm.topics {
+		if topic.tenantID != tenantID {
+			continue
+		}
+		topicMetrics = append(topicMetrics, topic.GetMetrics())
+		totalSubscribers += topic.GetSubscriberCount()
+		slowSubscribers += topic.GetSlowSubscriberCount()
+	}
+	tm.mu.RUnlock()
+
+	tm.wildcardMu.RLock()
+	var wildcards []*Subscriber
+	if trie, exists := tm.wildcards[tenantID]; exists {
+		wildcards = trie.snapshot()
+	}
+	tm.wildcardMu.RUnlock()
+
+	wildcardMetrics := make([]map[string]interface{}, 0, len(wildcards))
+	for _, sub := range wildcards {
+		if sub.IsSlow() {
+			slowSubscribers++
+		}
+		wildcardMetrics = append(wildcardMetrics, map[string]interface{}{
+			"id":        sub.ID,
+			"tenant_id": sub.TenantID,
+			"pattern":   sub.Topic,
+			"transport": sub.TransportName(),
+			"codec":     sub.CodecName(),
+			"metrics":   sub.GetMetrics(),
+		})
+	}
 
 	return map[string]interface{}{
-		"total_topics":      len(tm.topics),
-		"total_subscribers": tm.GetTotalSubscriberCount(),
-		"slow_subscribers":  tm.GetSlowSubscriberCount(),
-		"topics":            topicMetrics,
-		"throttler_metrics": tm.throttler.GetMetrics(),
+		"tenant_id":            tenantID,
+		"total_topics":         len(topicMetrics),
+		"total_subscribers":    totalSubscribers + len(wildcards),
+		"slow_subscribers":     slowSubscribers,
+		"topics":               topicMetrics,
+		"wildcard_subscribers": wildcardMetrics,
+		"tenant_usage":         tm.config.Quotas.GetTenantMetrics(tenantID),
 	}
 }
 
@@ -211,10 +914,15 @@ func (tm *TopicManager) ShutDown() {
 			subscribers := topic.getSubscribersSnapshot()
 			for _, subs := range subscribers {
 				subs.Close()
+				subs.discardSpill()
 			}
 		}
 		tm.mu.Unlock()
 
+		for _, subs := range tm.getWildcardSubscribers() {
+			subs.Close()
+		}
+
 		fmt.Println("TopicManager shut down gracefully")
 	})
 }
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/acl"
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
+	"github.com/AadityaChoubey68/clevr-live/internal/quota"
+	"github.com/AadityaChoubey68/clevr-live/internal/throttle"
+)
+
+// newTestTopicManager builds a topic manager with room to spare in every
+// budget, shut down when the test ends.
+func newTestTopicManager(t *testing.T, config TopicManagerConfig) *TopicManager {
+	t.Helper()
+	sampler := load.NewProcessSampler(1<<30, time.Second)
+	bufferManager := buffer.NewAdaptiveBufferManager(1<<30, buffer.ByteBudget{Total: 1 << 30, PerSubscriber: 64 << 20}, sampler)
+	throttler := throttle.NewAdaptiveThrottler(throttle.DefaultConfig(), sampler)
+
+	if config.CacheSize == 0 {
+		config.CacheSize = DefaultTopicManagerConfig().CacheSize
+	}
+	tm := NewTopicManager(bufferManager, throttler, config)
+	t.Cleanup(tm.ShutDown)
+	return tm
+}
+
+func TestReauthorizeSubscribersAfterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(path, []byte("allow subscribe orders.>\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := acl.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := newTestTopicManager(t, TopicManagerConfig{ACL: store})
+
+	reader := auth.Identity{TenantID: "acme", Principal: "reader"}
+	subscribe := func(id, topic string) *Subscriber {
+		sub := NewSubscriber(id, "acme", topic, discardTransport{}, context.Background(), 16)
+		if err := tm.Subscribe(reader, topic, id, sub); err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	eu := subscribe("eu", "orders.eu")
+	us := subscribe("us", "orders.us")
+	wide := subscribe("wide", "orders.>")
+
+	if n := tm.ReauthorizeSubscribers(); n != 0 {
+		t.Fatalf("revoked %d subscribers before the policy changed", n)
+	}
+
+	if err := os.WriteFile(path, []byte("deny subscribe orders.eu\nallow subscribe orders.>\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := tm.ReauthorizeSubscribers(); n != 2 {
+		t.Fatalf("revoked %d subscribers, want 2", n)
+	}
+	for _, sub := range []*Subscriber{eu, wide} {
+		if !sub.IsClosed() {
+			t.Errorf("%s is still open", sub.ID)
+		}
+		if err := sub.Err(); !errors.Is(err, acl.ErrDenied) {
+			t.Errorf("%s closed with %v, want denied", sub.ID, err)
+		}
+	}
+	if us.IsClosed() || us.Err() != nil {
+		t.Errorf("us was closed: %v", us.Err())
+	}
+}
+
+func TestIdleTopicsReturnToTheQuota(t *testing.T) {
+	quotas := quota.NewManager(quota.Config{Defaults: quota.Limits{MaxTopics: 1}})
+	tm := newTestTopicManager(t, TopicManagerConfig{Quotas: quotas})
+	id := auth.Identity{TenantID: "acme", Principal: "reader"}
+
+	// Each topic is only subscribed to, so it goes when its subscriber does
+	// and the next name fits under the limit of one.
+	for _, name := range []string{"a", "b", "c"} {
+		sub := NewSubscriber(name, "acme", name, discardTransport{}, context.Background(), 16)
+		if err := tm.Subscribe(id, name, name, sub); err != nil {
+			t.Fatalf("subscribe to %s: %v", name, err)
+		}
+		if err := tm.Unsubscribe("acme", name, name); err != nil {
+			t.Fatal(err)
+		}
+		if n := tm.GetTopicCount(); n != 0 {
+			t.Fatalf("%d topics left after leaving %s", n, name)
+		}
+	}
+
+	// A topic with messages is kept.
+	sub := NewSubscriber("kept", "acme", "kept", discardTransport{}, context.Background(), 16)
+	if err := tm.Subscribe(id, "kept", "kept", sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tm.Publish(id, "kept", NewMessage("kept", "acme", json.RawMessage(`{}`))); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Unsubscribe("acme", "kept", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tm.GetTopic("acme", "kept"); err != nil {
+		t.Fatalf("published topic was removed: %v", err)
+	}
+	if err := tm.Subscribe(id, "d", "d", NewSubscriber("d", "acme", "d", discardTransport{}, context.Background(), 16)); !errors.Is(err, quota.ErrExceeded) {
+		t.Fatalf("subscribe past the limit gave %v", err)
+	}
+}
+
+func TestRetiredTopicIsLookedUpAgain(t *testing.T) {
+	tm := newTestTopicManager(t, TopicManagerConfig{})
+	id := auth.Identity{TenantID: "acme", Principal: "svc"}
+
+	stale, err := tm.getOrCreateTopic("acme", "jobs", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.retireIfIdle(tm.makeTopicKey("acme", "jobs"), stale)
+
+	// A caller still holding the retired topic is refused and goes back to
+	// the manager, which creates a fresh one.
+	if err := stale.Subscribe(NewSubscriber("s", "acme", "jobs", discardTransport{}, context.Background(), 16)); !errors.Is(err, errTopicRetired) {
+		t.Fatalf("subscribe to a retired topic gave %v", err)
+	}
+	if _, err := stale.Publish(NewMessage("jobs", "acme", json.RawMessage(`{}`))); !errors.Is(err, errTopicRetired) {
+		t.Fatalf("publish to a retired topic gave %v", err)
+	}
+	if _, err := tm.Publish(id, "jobs", NewMessage("jobs", "acme", json.RawMessage(`{}`))); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := tm.GetTopic("acme", "jobs")
+	if err != nil || fresh == stale || fresh.NextOffset() != 1 {
+		t.Fatalf("publish did not reach a fresh topic: %v", err)
+	}
+}
+
+func TestFailedPublishIsRefunded(t *testing.T) {
+	quotas := quota.NewManager(quota.Config{Defaults: quota.Limits{MessagesPerSec: 2, MaxTopics: 1}})
+	tm := newTestTopicManager(t, TopicManagerConfig{Quotas: quotas})
+	id := auth.Identity{TenantID: "acme", Principal: "svc"}
+	publish := func(topic string) error {
+		_, err := tm.Publish(id, topic, NewMessage(topic, "acme", json.RawMessage(`{}`)))
+		return err
+	}
+
+	if err := publish("a"); err != nil {
+		t.Fatal(err)
+	}
+	// The second topic is over max_topics; the message it was charged for
+	// goes back, leaving room for one more.
+	if err := publish("b"); !errors.Is(err, quota.ErrExceeded) {
+		t.Fatalf("publish to a second topic gave %v", err)
+	}
+	if err := publish("a"); err != nil {
+		t.Fatalf("publish after a refused one: %v", err)
+	}
+	if err := publish("a"); !errors.Is(err, quota.ErrExceeded) {
+		t.Fatalf("publish past the rate gave %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
+	"github.com/coder/websocket"
+)
+
+// Transport is how a Subscriber hands messages to its client. The
+// subscriber's buffering, drop strategies and acks are transport agnostic.
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+	Name() string
+}
+
+// Gap reports that offsets a replay asked for are no longer held, in the
+// cache or the log: From is the first one requested and First the oldest
+// left, which the replay continues from.
+type Gap struct {
+	Topic        string `json:"topic"`
+	Subscription string `json:"subscription,omitempty"`
+	From         uint64 `json:"from_offset"`
+	First        uint64 `json:"first_offset"`
+}
+
+// frame is the gap as the control frame streaming transports send.
+func (g Gap) frame() ServerFrame {
+	return ServerFrame{
+		Type:         "gap",
+		Subscription: g.Subscription,
+		Topic:        g.Topic,
+		Error:        fmt.Sprintf("offsets %d to %d are no longer retained", g.From, g.First-1),
+		FromOffset:   &g.From,
+		FirstOffset:  &g.First,
+	}
+}
+
+// gapTransport is implemented by transports that can tell their client
+// about a gap outside the message stream.
+type gapTransport interface {
+	SendGap(ctx context.Context, gap Gap) error
+}
+
+type WebSocketTransport struct {
+	conn  *websocket.Conn
+	codec codec.Codec
+}
+
+// NewWebSocketTransport sends messages in the codec the connection
+// negotiated; nil means JSON.
+func NewWebSocketTransport(conn *websocket.Conn, c codec.Codec) *WebSocketTransport {
+	if c == nil {
+		c = codec.JSON
+	}
+	return &WebSocketTransport{conn: conn, codec: c}
+}
+
+// Send writes msg as a text frame holding its JSON envelope, or, for a
+// non-JSON payload when the subscriber asked for it, as a binary frame (see
+// encodeBinaryFrame). With a binary codec every message is a binary frame
+// in that codec, which carries any payload as raw bytes.
+func (t *WebSocketTransport) Send(ctx context.Context, msg Message) error {
+	if t.codec != codec.JSON {
+		data, err := msg.encodeWith(t.codec)
+		if err != nil {
+			return err
+		}
+		return t.conn.Write(ctx, frameType(t.codec), data)
+	}
+
+	if msg.binaryFrames && !msg.IsJSON() {
+		frame, err := msg.encodeBinaryFrame()
+		if err != nil {
+			return err
+		}
+		return t.conn.Write(ctx, websocket.MessageBinary, frame)
+	}
+
+	data, err := msg.encodeJSON()
+	if err != nil {
+		return err
+	}
+	return t.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// SendGap writes a gap control frame.
+func (t *WebSocketTransport) SendGap(ctx context.Context, gap Gap) error {
+	return WriteServerFrame(ctx, t.conn, t.codec, gap.frame())
+}
+
+// Codec is the codec messages are sent in.
+func (t *WebSocketTransport) Codec() codec.Codec {
+	return t.codec
+}
+
+func (t *WebSocketTransport) Name() string {
+	return "websocket"
+}
+
+// SSETransport streams messages as text/event-stream events whose id is the
+// message offset, so EventSource reconnects resume via Last-Event-ID. On a
+// wildcard subscription offsets of different topics interleave, so ids
+// are topic:offset there.
+type SSETransport struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	format  string
+	mu      sync.Mutex
+}
+
+func NewSSETransport(w http.ResponseWriter, flusher http.Flusher, wildcard bool) *SSETransport {
+	format := wireSSE
+	if wildcard {
+		format = wireSSETopic
+	}
+	return &SSETransport{w: w, flusher: flusher, format: format}
+}
+
+func (t *SSETransport) Send(ctx context.Context, msg Message) error {
+	event, err := msg.encodeFramed(t.format)
+	if err != nil {
+		return err
+	}
+	return t.write(event)
+}
+
+// SendGap writes a gap event outside the message stream.
+func (t *SSETransport) SendGap(ctx context.Context, gap Gap) error {
+	data, err := json.Marshal(gap.frame())
+	if err != nil {
+		return err
+	}
+	return t.write([]byte(fmt.Sprintf("event: gap\ndata: %s\n\n", data)))
+}
+
+// Heartbeat writes an SSE comment line, used as a keep-alive through proxies.
+func (t *SSETransport) Heartbeat() error {
+	return t.write([]byte(": ping\n\n"))
+}
+
+// Error writes an error event outside the message stream. The text is
+// JSON-encoded, so a newline in it cannot end the data line early.
+func (t *SSETransport) Error(text string) error {
+	data, err := json.Marshal(map[string]string{"error": text})
+	if err != nil {
+		return err
+	}
+	return t.write([]byte(fmt.Sprintf("event: error\ndata: %s\n\n", data)))
+}
+
+func (t *SSETransport) write(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.w.Write(data); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+func (t *SSETransport) Name() string {
+	return "sse"
+}
+
+// NDJSONTransport streams one JSON message per line over a chunked response.
+type NDJSONTransport struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+}
+
+func NewNDJSONTransport(w http.ResponseWriter, flusher http.Flusher) *NDJSONTransport {
+	return &NDJSONTransport{w: w, flusher: flusher}
+}
+
+func (t *NDJSONTransport) Send(ctx context.Context, msg Message) error {
+	line, err := msg.encodeFramed(wireNDJSON)
+	if err != nil {
+		return err
+	}
+	return t.write(line)
+}
+
+// SendGap writes the gap frame as a line of its own.
+func (t *NDJSONTransport) SendGap(ctx context.Context, gap Gap) error {
+	data, err := json.Marshal(gap.frame())
+	if err != nil {
+		return err
+	}
+	return t.write(append(data, '\n'))
+}
+
+// Heartbeat writes an empty line, which NDJSON readers skip.
+func (t *NDJSONTransport) Heartbeat() error {
+	return t.write([]byte("\n"))
+}
+
+func (t *NDJSONTransport) Error(text string) error {
+	data, err := json.Marshal(map[string]string{"error": text})
+	if err != nil {
+		return err
+	}
+	return t.write(append(data, '\n'))
+}
+
+func (t *NDJSONTransport) write(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.w.Write(data); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+func (t *NDJSONTransport) Name() string {
+	return "ndjson"
+}
+
+var ErrBatchFull = errors.New("poll batch full")
+
+// PollTransport collects messages for a single long-poll response. Once the
+// batch is full it refuses further sends, which ends the subscription.
+type PollTransport struct {
+	max      int
+	messages []Message
+	gap      *Gap
+	ready    chan struct{}
+	mu       sync.Mutex
+}
+
+func NewPollTransport(max int) *PollTransport {
+	return &PollTransport{
+		max:   max,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (t *PollTransport) Send(ctx context.Context, msg Message) error {
+	t.mu.Lock()
+	if len(t.messages) >= t.max {
+		t.mu.Unlock()
+		return ErrBatchFull
+	}
+	t.messages = append(t.messages, msg)
+	t.mu.Unlock()
+
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SendGap records the gap for the response.
+func (t *PollTransport) SendGap(ctx context.Context, gap Gap) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gap = &gap
+	return nil
+}
+
+// Gap is the gap the poll's replay ran into, if any.
+func (t *PollTransport) Gap() *Gap {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gap
+}
+
+// Ready is signalled after each message added to the batch.
+func (t *PollTransport) Ready() <-chan struct{} {
+	return t.ready
+}
+
+func (t *PollTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messages := make([]Message, len(t.messages))
+	copy(messages, t.messages)
+	return messages
+}
+
+func (t *PollTransport) Name() string {
+	return "poll"
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSEErrorIsOneDataLine(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	transport := NewSSETransport(recorder, recorder, false)
+
+	text := "Failed to subscribe: bad\ndata: {\"injected\":true}\n\nevent: message"
+	if err := transport.Error(text); err != nil {
+		t.Fatal(err)
+	}
+
+	body := recorder.Body.String()
+	if !strings.HasSuffix(body, "\n\n") || strings.Count(body, "\n\n") != 1 {
+		t.Fatalf("error event is not a single event: %q", body)
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\n\n"), "\n")
+	if len(lines) != 2 || lines[0] != "event: error" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("error event %q", body)
+	}
+
+	var data struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Error != text {
+		t.Fatalf("error text %q, want %q", data.Error, text)
+	}
+}
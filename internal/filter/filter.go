@@ -0,0 +1,284 @@
+// Package filter compiles subscription filter expressions and evaluates
+// them against messages.
+//
+// An expression compares message fields with literals:
+//
+//	data.region == "eu" && data.amount > 100
+//	headers["schema-version"] in ["2", "3"] || topic =~ "^orders\\."
+//	!(data.tags[0] == "test")
+//
+// Paths start at data (the JSON payload), headers, topic or content_type.
+// A missing field is null. Comparisons of mismatched types are false, and a
+// path on its own tests for the boolean true.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Roots a path can start from.
+const (
+	RootData        = "data"
+	RootHeaders     = "headers"
+	RootTopic       = "topic"
+	RootContentType = "content_type"
+)
+
+// Filter is a compiled expression; it is safe for concurrent use.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses expr, reporting the first syntax error.
+func Compile(expr string) (*Filter, error) {
+	if len(expr) > MaxExpressionBytes {
+		return nil, fmt.Errorf("filter is longer than %d bytes", MaxExpressionBytes)
+	}
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("filter is empty")
+	}
+
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("invalid filter: %w", p.unexpected("end of expression"))
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether doc passes the filter.
+func (f *Filter) Match(doc *Document) bool {
+	return f.root.eval(doc) == true
+}
+
+// Document is a message as filters see it. Its payload is decoded on first
+// use and shared by every filter evaluated against it, so a message is
+// decoded once however many subscribers filter it.
+type Document struct {
+	Topic       string
+	ContentType string
+	Headers     map[string]string
+
+	raw     json.RawMessage
+	decode  sync.Once
+	decoded any
+}
+
+// NewDocument wraps a message; data is its JSON payload, or nil when the
+// payload is not JSON, in which case data paths are null.
+func NewDocument(topic, contentType string, headers map[string]string, data json.RawMessage) *Document {
+	return &Document{Topic: topic, ContentType: contentType, Headers: headers, raw: data}
+}
+
+func (d *Document) data() any {
+	d.decode.Do(func() {
+		if len(d.raw) > 0 {
+			// A payload that fails to decode is treated as null.
+			json.Unmarshal(d.raw, &d.decoded)
+		}
+	})
+	return d.decoded
+}
+
+type node interface {
+	eval(doc *Document) any
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n literalNode) eval(*Document) any {
+	return n.value
+}
+
+type step struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type pathNode struct {
+	root  string
+	steps []step
+}
+
+// normalize checks the path's shape against its root. Header names are
+// stored lowercase, so they are looked up that way.
+func (n pathNode) normalize() (node, error) {
+	switch n.root {
+	case RootTopic, RootContentType:
+		if len(n.steps) > 0 {
+			return nil, fmt.Errorf("%s has no fields", n.root)
+		}
+	case RootHeaders:
+		if len(n.steps) != 1 || n.steps[0].isIndex {
+			return nil, fmt.Errorf("headers take one name, as headers.name or headers[\"name\"]")
+		}
+		n.steps[0].key = strings.ToLower(n.steps[0].key)
+	}
+	return n, nil
+}
+
+func (n pathNode) eval(doc *Document) any {
+	switch n.root {
+	case RootTopic:
+		return doc.Topic
+	case RootContentType:
+		return doc.ContentType
+	case RootHeaders:
+		if value, ok := doc.Headers[n.steps[0].key]; ok {
+			return value
+		}
+		return nil
+	}
+
+	value := doc.data()
+	for _, s := range n.steps {
+		switch v := value.(type) {
+		case map[string]any:
+			if s.isIndex {
+				return nil
+			}
+			value = v[s.key]
+		case []any:
+			if !s.isIndex || s.index >= len(v) {
+				return nil
+			}
+			value = v[s.index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(doc *Document) any {
+	return n.operand.eval(doc) != true
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(doc *Document) any {
+	return n.left.eval(doc) == true && n.right.eval(doc) == true
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(doc *Document) any {
+	return n.left.eval(doc) == true || n.right.eval(doc) == true
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(doc *Document) any {
+	left, right := n.left.eval(doc), n.right.eval(doc)
+
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func equal(a, b any) bool {
+	switch a.(type) {
+	case map[string]any, []any:
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
+type inNode struct {
+	operand node
+	values  []any
+}
+
+func (n inNode) eval(doc *Document) any {
+	value := n.operand.eval(doc)
+	for _, candidate := range n.values {
+		if equal(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+type matchNode struct {
+	operand node
+	re      *regexp.Regexp
+}
+
+func (n matchNode) eval(doc *Document) any {
+	s, ok := n.operand.eval(doc).(string)
+	return ok && n.re.MatchString(s)
+}
@@ -0,0 +1,169 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func testDocument() *Document {
+	return NewDocument("orders.eu", "application/json", map[string]string{
+		"schema-version": "2",
+		"source":         "checkout",
+	}, json.RawMessage(`{
+		"region": "eu",
+		"amount": 150,
+		"flag": "true",
+		"paid": true,
+		"customer": {"id": "c-1", "tier": 2},
+		"items": [{"sku": "a-1", "qty": 2}, {"sku": "b-2", "qty": 1}],
+		"tags": ["new", "gift"],
+		"note": null
+	}`))
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		{"equal string", `data.region == "eu"`, true},
+		{"equal number", `data.amount == 150`, true},
+		{"not equal", `data.region != "us"`, true},
+		{"ordered numbers", `data.amount > 100 && data.amount <= 150 && data.amount >= 150 && data.amount < 151`, true},
+		{"ordered strings", `data.region < "fr" && topic >= "orders"`, true},
+		{"nested field", `data.customer.tier == 2`, true},
+		{"array index", `data.items[1].sku == "b-2"`, true},
+		{"bracketed key", `data["customer"]["id"] == 'c-1'`, true},
+		{"bare path is true", `data.paid`, true},
+		{"in list", `data.tags[0] in ["old", "new"]`, true},
+		{"not in list", `data.region in ["us", "ap"]`, false},
+		{"regex", `topic =~ "^orders\\."`, true},
+		{"regex no match", `data.region =~ "^u"`, false},
+		{"header", `headers["schema-version"] in ["2", "3"]`, true},
+		{"header name is case insensitive", `headers.Source == "checkout"`, true},
+		{"content type", `content_type == "application/json"`, true},
+		{"deep equal object", `data.customer == data.customer`, true},
+
+		// && binds tighter than ||, ! tighter than &&, and comparisons
+		// tighter than either.
+		{"and before or", `true || false && false`, true},
+		{"and before or on the right", `false && true || true`, true},
+		{"parentheses override", `(true || false) && false`, false},
+		{"not binds to its operand", `!false && false`, false},
+		{"not of a group", `!(false && false)`, true},
+		{"double negation", `!!data.paid`, true},
+		{"comparison before and", `data.amount > 100 && data.region == "eu"`, true},
+		{"comparison before or", `data.amount < 100 || data.region == "eu"`, true},
+
+		// Mismatched types never compare, in either direction.
+		{"number against string", `data.amount == "150"`, false},
+		{"number ordered against string", `data.amount > "100"`, false},
+		{"string ordered against number", `data.region < 1`, false},
+		{"bool ordered", `data.paid > false`, false},
+		{"string true is not bare true", `data.flag`, false},
+		{"regex on a number", `data.amount =~ "1"`, false},
+		{"object ordered", `data.customer > 1`, false},
+		{"mismatch inequality", `data.amount != "150"`, true},
+
+		// A missing field is null.
+		{"missing field equals null", `data.missing == null`, true},
+		{"missing field is not null", `data.missing != null`, false},
+		{"missing field ordered", `data.missing > 0 || data.missing <= 0`, false},
+		{"missing header", `headers.absent == null`, true},
+		{"index past the end", `data.items[5] == null`, true},
+		{"field of a string", `data.region.code == null`, true},
+		{"index into an object", `data.customer[0] == null`, true},
+		{"key into an array", `data.items.sku == null`, true},
+		{"explicit null", `data.note == null`, true},
+		{"missing bare path", `data.missing`, false},
+		{"not of a missing path", `!data.missing`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatalf("compile %s: %v", tt.expr, err)
+			}
+			if got := f.Match(testDocument()); got != tt.want {
+				t.Fatalf("%s matched %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchNonJSONPayload(t *testing.T) {
+	doc := NewDocument("images", "image/png", nil, nil)
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`data == null`, true},
+		{`data.width > 0`, false},
+		{`content_type == "image/png"`, true},
+		{`headers.source == null`, true},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Match(doc); got != tt.want {
+			t.Errorf("%s matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		err  string
+	}{
+		{"empty", "  ", "filter is empty"},
+		{"too long", `data.a == "` + strings.Repeat("x", MaxExpressionBytes) + `"`, "longer than"},
+		{"nested parentheses", strings.Repeat("(", maxNesting) + "true" + strings.Repeat(")", maxNesting), "nested more than"},
+		{"nested negation", strings.Repeat("!", maxNesting) + "true", "nested more than"},
+		{"regex compile error", `data.region =~ "(eu"`, "invalid pattern"},
+		{"regex needs a string", `data.region =~ eu`, "needs a string pattern"},
+		{"unknown root", `payload.region == "eu"`, "unknown field"},
+		{"topic has no fields", `topic.name == "x"`, "topic has no fields"},
+		{"headers need one name", `headers.a.b == "x"`, "headers take one name"},
+		{"headers not indexed", `headers[0] == "x"`, "headers take one name"},
+		{"unterminated string", `data.region == "eu`, "unterminated string"},
+		{"unexpected character", `data.region == #`, "unexpected"},
+		{"trailing tokens", `data.paid true`, "expected end of expression"},
+		{"missing operand", `data.amount >`, "expected a value"},
+		{"unclosed group", `(data.paid`, `expected ")"`},
+		{"negative index", `data.items[-1] == null`, "invalid index"},
+		{"fractional index", `data.items[1.5] == null`, "invalid index"},
+		{"unclosed index", `data.items[0 == null`, `expected "]"`},
+		{"empty in list", `data.region in []`, "expected a value"},
+		{"in needs a list", `data.region in "eu"`, `expected "["`},
+		{"dangling operator", `data.paid &&`, "expected a value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("compile gave %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompileNestingLimit(t *testing.T) {
+	// The top level counts as the first level.
+	depth := maxNesting - 1
+	for _, expr := range []string{
+		strings.Repeat("(", depth) + "true" + strings.Repeat(")", depth),
+		strings.Repeat("!", depth) + "true",
+	} {
+		if _, err := Compile(expr); err != nil {
+			t.Errorf("%d levels rejected: %v", depth, err)
+		}
+	}
+}
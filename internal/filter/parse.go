@@ -0,0 +1,381 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxExpressionBytes bounds a filter expression, and maxNesting how deeply
+// it may nest, so a hostile subscribe cannot make compiling expensive.
+const (
+	MaxExpressionBytes = 4096
+	maxNesting         = 64
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits an expression into identifiers, literals and operators.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(expr) && expr[end] != c {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			text, err := unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = end + 1
+
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(expr) && expr[i+1] >= '0' && expr[i+1] <= '9':
+			end := i + 1
+			for end < len(expr) && strings.IndexByte("0123456789.eE+-", expr[end]) >= 0 {
+				if (expr[end] == '+' || expr[end] == '-') && expr[end-1] != 'e' && expr[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[i:end], pos: i})
+			i = end
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(expr) && (expr[end] == '_' || expr[end] == '-' || unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expr[i:end], pos: i})
+			i = end
+
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "=~", "<", ">", "!", "(", ")", "[", "]", ".", ","} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+// unquote reads a double or single quoted string with Go escapes.
+func unquote(quoted string) (string, error) {
+	if quoted[0] == '"' {
+		return strconv.Unquote(quoted)
+	}
+
+	// Requote as a double quoted string so strconv handles the escapes.
+	inner := quoted[1 : len(quoted)-1]
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case c == '\\' && i+1 < len(inner) && inner[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case c == '\\' && i+1 < len(inner):
+			b.WriteByte(c)
+			b.WriteByte(inner[i+1])
+			i++
+		case c == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return strconv.Unquote(b.String())
+}
+
+// parser is a recursive descent parser over the grammar
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand
+//	                  | "=~" string
+//	                  | "in" "[" literal { "," literal } "]" ]
+//	operand = literal | path | "(" or ")"
+//	path    = root { "." name | "[" ( string | integer ) "]" }
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return p.unexpected(fmt.Sprintf("%q", op))
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("expected %s at end of expression", want)
+	}
+	return fmt.Errorf("expected %s at %d, found %q", want, t.pos, t.text)
+}
+
+func (p *parser) parseOr() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxNesting {
+		return nil, fmt.Errorf("expression nested more than %d deep", maxNesting)
+	}
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxNesting {
+			return nil, fmt.Errorf("expression nested more than %d deep", maxNesting)
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op: t.text, left: left, right: right}, nil
+
+	case t.kind == tokenOp && t.text == "=~":
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("=~ at %d needs a string pattern", t.pos)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern at %d: %v", pattern.pos, err)
+		}
+		return matchNode{operand: left, re: re}, nil
+
+	case t.kind == tokenIdent && t.text == "in":
+		p.next()
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		var values []any
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return inNode{operand: left, values: values}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	t := p.peek()
+	if t.kind == tokenIdent && !isKeyword(t.text) {
+		return p.parsePath()
+	}
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return literalNode{value}, nil
+}
+
+func (p *parser) parseLiteral() (any, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.next()
+		return t.text, nil
+	case t.kind == tokenNumber:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return f, nil
+	case t.kind == tokenIdent && (t.text == "true" || t.text == "false" || t.text == "null"):
+		p.next()
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, nil
+	}
+	return nil, p.unexpected("a value")
+}
+
+func (p *parser) parsePath() (node, error) {
+	root := p.next()
+	path := pathNode{root: root.text}
+	switch root.text {
+	case RootData, RootHeaders, RootTopic, RootContentType:
+	default:
+		return nil, fmt.Errorf("unknown field %q at %d: paths start with data, headers, topic or content_type", root.text, root.pos)
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			name := p.peek()
+			if name.kind != tokenIdent {
+				return nil, p.unexpected("a field name")
+			}
+			p.next()
+			path.steps = append(path.steps, step{key: name.text})
+
+		case p.accept("["):
+			index := p.peek()
+			switch index.kind {
+			case tokenString:
+				path.steps = append(path.steps, step{key: index.text})
+			case tokenNumber:
+				n, err := strconv.Atoi(index.text)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index %q at %d", index.text, index.pos)
+				}
+				path.steps = append(path.steps, step{index: n, isIndex: true})
+			default:
+				return nil, p.unexpected("a string key or index")
+			}
+			p.next()
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+
+		default:
+			return path.normalize()
+		}
+	}
+}
+
+func isKeyword(word string) bool {
+	switch word {
+	case "true", "false", "null", "in":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/acl"
+	"github.com/AadityaChoubey68/clevr-live/internal/quota"
+	"github.com/AadityaChoubey68/clevr-live/internal/throttle"
+)
+
+// statusFor maps a TopicManager error to an HTTP status and, for rate
+// limits and throttling, sets Retry-After.
+func statusFor(w http.ResponseWriter, err error) int {
+	if errors.Is(err, acl.ErrDenied) {
+		return http.StatusForbidden
+	}
+
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		if exceeded.Limit == quota.LimitMessageBytes {
+			return http.StatusRequestEntityTooLarge
+		}
+		if exceeded.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(exceeded.RetryAfterSeconds()))
+		}
+		return http.StatusTooManyRequests
+	}
+
+	var throttled *throttle.ThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+		if throttled.Shed {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusInternalServerError
+}
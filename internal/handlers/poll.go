@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/core"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 120 * time.Second
+	defaultPollBatch   = 100
+	maxPollBatch       = 1000
+
+	// pollLinger is how long a poll keeps collecting after its first message,
+	// so a burst comes back as one batch instead of one message per request.
+	pollLinger = 20 * time.Millisecond
+)
+
+type PollResponse struct {
+	Topic     string         `json:"topic"`
+	Messages  []core.Message `json:"messages"`
+	NextAfter *uint64        `json:"next_after,omitempty"`
+
+	// NextOffset is where the next poll should resume, as from_offset. It
+	// is set even for an empty batch, so nothing published while no poll
+	// was waiting is missed; wildcard polls have no single offset.
+	NextOffset *uint64 `json:"next_offset,omitempty"`
+
+	// Gap is set when the offsets asked for were no longer retained and
+	// the batch starts at the oldest one left.
+	Gap   *core.Gap `json:"gap,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type PollHandler struct {
+	topicManager  *core.TopicManager
+	bufferManager *buffer.AddaptiveBufferManager
+}
+
+func NewPollHandler(tm *core.TopicManager, bufferMgr *buffer.AddaptiveBufferManager) *PollHandler {
+	return &PollHandler{
+		topicManager:  tm,
+		bufferManager: bufferMgr,
+	}
+}
+
+func (h *PollHandler) respondError(w http.ResponseWriter, topic, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	json.NewEncoder(w).Encode(PollResponse{
+		Topic:    topic,
+		Messages: []core.Message{},
+		Error:    message,
+	})
+}
+
+// ServeHTTP long-polls a topic. It blocks until at least one message after
+// the `after` offset exists or timeout_ms elapses, then returns up to `max`
+// messages. Clients pass next_after back as `after` on the following poll.
+// Without `after` the poll waits for the next new message.
+func (h *PollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respondError(w, "", "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity := auth.Current(r.Context())
+	tenant_id := identity.TenantID
+
+	query := r.URL.Query()
+	topic := query.Get("topic")
+
+	timeout, max, err := parsePollLimits(query.Get("timeout_ms"), query.Get("max"))
+	if err != nil {
+		h.respondError(w, topic, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	after := query.Get("after")
+	if after != "" {
+		if core.IsWildcard(topic) {
+			h.respondError(w, topic, "after is not supported with wildcard topics", http.StatusBadRequest)
+			return
+		}
+		afterOffset, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			h.respondError(w, topic, fmt.Sprintf("invalid after %q", after), http.StatusBadRequest)
+			return
+		}
+		query.Set("from_offset", strconv.FormatUint(afterOffset+1, 10))
+	} else if query.Get("from") == "" && query.Get("from_offset") == "" {
+		query.Set("from", "latest")
+	}
+
+	opts, err := core.ParseSubscribeOptions(query)
+	if err != nil {
+		h.respondError(w, topic, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A poll subscription only lives for one request, so anything a group
+	// handed it after the response would be lost, and there is no way to ack.
+	if opts.Group != "" || opts.AckMode {
+		h.respondError(w, topic, "group and ack mode are not supported when polling", http.StatusBadRequest)
+		return
+	}
+	if opts.BinaryPayloads {
+		h.respondError(w, topic, "binary payloads are only supported over WebSocket", http.StatusBadRequest)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	transport := core.NewPollTransport(max)
+
+	subscriberID := uuid.New().String()
+	subscriber := core.NewSubscriber(subscriberID, tenant_id, opts.Topic, transport, r.Context(), h.bufferManager.GetBufferSize())
+	subscriber.ApplyOptions(opts)
+
+	if err := h.topicManager.Subscribe(identity, opts.Topic, subscriberID, subscriber); err != nil {
+		h.respondError(w, topic, fmt.Sprintf("Failed to subscribe: %v", err), statusFor(w, err))
+		return
+	}
+
+	waitForBatch(transport, subscriber, timeout)
+
+	h.topicManager.Unsubscribe(tenant_id, opts.Topic, subscriberID)
+	subscriber.Close()
+	// Once the send loop is gone the batch can no longer change, so the
+	// cursor below covers exactly what is returned.
+	<-subscriber.Stopped()
+
+	messages := transport.Messages()
+	response := PollResponse{
+		Topic:    opts.Topic,
+		Messages: messages,
+		Gap:      transport.Gap(),
+	}
+	// Anything the subscriber buffered but did not get into the batch is
+	// after the cursor, so the next poll picks it up again.
+	if !core.IsWildcard(opts.Topic) {
+		next := subscriber.StartOffset()
+		if response.Gap != nil {
+			next = response.Gap.First
+		}
+		if len(messages) > 0 {
+			next = messages[len(messages)-1].Offset + 1
+		}
+		response.NextOffset = &next
+		if next > 0 {
+			nextAfter := next - 1
+			response.NextAfter = &nextAfter
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// waitForBatch blocks until the first message arrives or the timeout elapses,
+// then lingers briefly to pick up whatever follows it.
+func waitForBatch(transport *core.PollTransport, subscriber *core.Subscriber, timeout time.Duration) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	select {
+	case <-transport.Ready():
+	case <-deadline.C:
+		return
+	case <-subscriber.Context().Done():
+		return
+	}
+
+	linger := time.NewTimer(pollLinger)
+	defer linger.Stop()
+
+	for {
+		select {
+		case <-transport.Ready():
+			if !linger.Stop() {
+				<-linger.C
+			}
+			linger.Reset(pollLinger)
+		case <-linger.C:
+			return
+		case <-deadline.C:
+			return
+		case <-subscriber.Context().Done():
+			return
+		}
+	}
+}
+
+func parsePollLimits(timeoutMs, max string) (time.Duration, int, error) {
+	timeout := defaultPollTimeout
+	if timeoutMs != "" {
+		ms, err := strconv.Atoi(timeoutMs)
+		if err != nil || ms < 0 {
+			return 0, 0, fmt.Errorf("invalid timeout_ms %q", timeoutMs)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+
+	batch := defaultPollBatch
+	if max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid max %q", max)
+		}
+		batch = n
+		if batch > maxPollBatch {
+			batch = maxPollBatch
+		}
+	}
+
+	return timeout, batch, nil
+}
@@ -2,33 +2,46 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
 	"github.com/AadityaChoubey68/clevr-live/internal/core"
-	"github.com/AadityaChoubey68/clevr-live/internal/throttle"
 )
 
+// rawHeaderPrefix marks the request headers of a raw publish that become
+// message headers: Clevr-Header-Correlation-Id sets correlation-id.
+const rawHeaderPrefix = "Clevr-Header-"
+
+// maxRawPayloadBytes bounds a raw publish body read into memory; quotas
+// may set a lower limit per tenant.
+const maxRawPayloadBytes = 16 * 1024 * 1024
+
 type Publishrequest struct {
-	Topic string                 `json:"topic"`
-	Data  map[string]interface{} `json:"data"`
+	Topic       string            `json:"topic"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Data        json.RawMessage   `json:"data"`
 }
 
 type PublishResponse struct {
-	Success   bool   `json:"success"`
-	MessageId string `json:"message_id,omitempty"`
-	Error     string `json:"error,omitempty"`
+	Success   bool    `json:"success"`
+	MessageId string  `json:"message_id,omitempty"`
+	Offset    *uint64 `json:"offset,omitempty"`
+	Error     string  `json:"error,omitempty"`
 }
 
 type PublishHandler struct {
 	topicManager *core.TopicManager
-	throttler    *throttle.AdaptiveThrottler
 }
 
-func NewPublishHandler(tm *core.TopicManager, throttler *throttle.AdaptiveThrottler) *PublishHandler {
+func NewPublishHandler(tm *core.TopicManager) *PublishHandler {
 	return &PublishHandler{
 		topicManager: tm,
-		throttler:    throttler,
 	}
 }
 
@@ -41,54 +54,124 @@ func (h *PublishHandler) respondError(w http.ResponseWriter, message string, sta
 		Error:   message,
 	})
 }
-func (h *PublishHandler) respondSuccess(w http.ResponseWriter, messageID string) {
+func (h *PublishHandler) respondSuccess(w http.ResponseWriter, messageID string, offset uint64) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
 	json.NewEncoder(w).Encode(PublishResponse{
 		Success:   true,
 		MessageId: messageID,
+		Offset:    &offset,
 	})
 }
 
+// ServeHTTP handles POST /publish. The request is JSON, or MessagePack, CBOR
+// or Protobuf when its Content-Type names one of those codecs.
 func (h *PublishHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		h.respondError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	tenant_id, ok := r.Context().Value("tenantId").(string)
-
-	if !ok || tenant_id == "" {
-		tenant_id = "default-tenant"
-	}
+	identity := auth.Current(r.Context())
 
 	var req Publishrequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if c, ok := codec.ForContentType(r.Header.Get("Content-Type")); ok && c.Binary() {
+		body, ok := h.readBody(w, r)
+		if !ok {
+			return
+		}
+		if err := codec.Decode(c, body, &req); err != nil {
+			h.respondError(w, "Invalid request Body", http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.respondError(w, "Invalid request Body", http.StatusBadRequest)
 		return
 	}
 
-	if req.Topic == "" {
-		h.respondError(w, "Topic Needed", http.StatusBadRequest)
+	if err := core.ValidatePublish(req.Topic, req.ContentType, req.Data); err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	headers, err := core.NormalizeHeaders(req.Headers)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if req.Data == nil {
-		h.respondError(w, "DAta Needed", http.StatusBadRequest)
+	msg := core.NewEnvelopeMessage(req.Topic, identity.TenantID, req.ContentType, req.Data)
+	msg.Headers = headers
+	h.publish(w, identity, msg)
+}
+
+// ServeRaw handles POST /topics/{topic}/messages, whose body is the payload
+// itself, of whatever Content-Type it declares.
+func (h *PublishHandler) ServeRaw(w http.ResponseWriter, r *http.Request) {
+	identity := auth.Current(r.Context())
+	topic := r.PathValue("topic")
+	if err := core.ValidatePublishTopic(topic); err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, ok := h.readBody(w, r)
+	if !ok {
 		return
 	}
 
-	if h.throttler.ShouldThrottle() {
-		h.throttler.ApplyThrottle()
+	if len(body) == 0 {
+		h.respondError(w, "data needed", http.StatusBadRequest)
+		return
 	}
 
-	msg := core.NewMessage(req.Topic, tenant_id, req.Data)
+	msg, err := core.NewPayloadMessage(topic, identity.TenantID, r.Header.Get("Content-Type"), body)
+	if err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg.Headers, err = core.NormalizeHeaders(rawHeaders(r.Header)); err != nil {
+		h.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.publish(w, identity, msg)
+}
+
+// rawHeaders collects the message headers of a raw publish. A header sent
+// more than once keeps its first value.
+func rawHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for name, values := range header {
+		if key, ok := strings.CutPrefix(name, rawHeaderPrefix); ok && key != "" {
+			headers[key] = values[0]
+		}
+	}
+	return headers
+}
+
+// readBody reads a request body of up to maxRawPayloadBytes, answering the
+// request itself when it cannot.
+func (h *PublishHandler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRawPayloadBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondError(w, fmt.Sprintf("payload larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		h.respondError(w, "Invalid request Body", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
 
-	if err := h.topicManager.Publish(tenant_id, req.Topic, msg); err != nil {
-		h.respondError(w, fmt.Sprintf("Failed to publish: %v", err), http.StatusInternalServerError)
+func (h *PublishHandler) publish(w http.ResponseWriter, identity auth.Identity, msg core.Message) {
+	offset, err := h.topicManager.Publish(identity, msg.Topic, msg)
+	if err != nil {
+		h.respondError(w, fmt.Sprintf("Failed to publish: %v", err), statusFor(w, err))
 		return
 	}
 
-	h.respondSuccess(w, msg.Id)
+	h.respondSuccess(w, msg.Id, offset)
 }
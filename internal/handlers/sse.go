@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/core"
+)
+
+type SSEHandler struct {
+	topicManager  *core.TopicManager
+	bufferManager *buffer.AddaptiveBufferManager
+}
+
+func NewSSEHandler(tm *core.TopicManager, bufferMgr *buffer.AddaptiveBufferManager) *SSEHandler {
+	return &SSEHandler{
+		topicManager:  tm,
+		bufferManager: bufferMgr,
+	}
+}
+
+// ServeHTTP streams a topic as text/event-stream. It accepts the same query
+// parameters as /subscribe, except ack mode, which needs a back channel SSE
+// does not have. A Last-Event-ID header resumes after that offset.
+func (h *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity := auth.Current(r.Context())
+
+	// Wildcard subscriptions start live, so their topic:offset event ids
+	// are not resumed from.
+	query := r.URL.Query()
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" && query.Get("from_offset") == "" && !core.IsWildcard(query.Get("topic")) {
+		lastOffset, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", lastEventID), http.StatusBadRequest)
+			return
+		}
+		query.Set("from_offset", strconv.FormatUint(lastOffset+1, 10))
+	}
+
+	opts, err := core.ParseSubscribeOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.AckMode {
+		http.Error(w, "ack mode is not supported over SSE", http.StatusBadRequest)
+		return
+	}
+	if opts.BinaryPayloads {
+		http.Error(w, "binary payloads are only supported over WebSocket", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.topicManager.CheckSubscribe(identity, opts.Topic); err != nil {
+		http.Error(w, err.Error(), statusFor(w, err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// The server-wide WriteTimeout would cut the stream off; lift it for this
+	// response only.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	transport := core.NewSSETransport(w, flusher, core.IsWildcard(opts.Topic))
+	streamSubscription(r.Context(), h.topicManager, identity, opts, transport, h.bufferManager.GetBufferSize())
+}
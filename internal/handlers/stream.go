@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/core"
+	"github.com/google/uuid"
+)
+
+const streamHeartbeatInterval = 15 * time.Second
+
+// streamTransport is a transport that keeps an HTTP response open and can
+// write keep-alives and errors outside the message stream.
+type streamTransport interface {
+	core.Transport
+	Heartbeat() error
+	Error(text string) error
+}
+
+// streamSubscription subscribes a streaming transport and blocks until the
+// client goes away or the subscriber is closed.
+func streamSubscription(ctx context.Context, tm *core.TopicManager, identity auth.Identity, opts core.SubscribeOptions, transport streamTransport, bufferSize int) {
+	tenantID := identity.TenantID
+	subscriberID := uuid.New().String()
+	subscriber := core.NewSubscriber(subscriberID, tenantID, opts.Topic, transport, ctx, bufferSize)
+	subscriber.ApplyOptions(opts)
+
+	if err := tm.Subscribe(identity, opts.Topic, subscriberID, subscriber); err != nil {
+		transport.Error(fmt.Sprintf("Failed to subscribe: %v", err))
+		return
+	}
+
+	ticker := time.NewTicker(streamHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := transport.Heartbeat(); err != nil {
+				subscriber.Close()
+			}
+		case <-subscriber.Context().Done():
+			tm.Unsubscribe(tenantID, opts.Topic, subscriberID)
+			// The transport writes to the handler's ResponseWriter, which
+			// must not be touched once ServeHTTP returns.
+			subscriber.Close()
+			<-subscriber.Stopped()
+			fmt.Printf("%s subscriber %s disconnected from %s:%s\n", transport.Name(), subscriberID, tenantID, opts.Topic)
+			return
+		}
+	}
+}
+
+type StreamHandler struct {
+	topicManager  *core.TopicManager
+	bufferManager *buffer.AddaptiveBufferManager
+}
+
+func NewStreamHandler(tm *core.TopicManager, bufferMgr *buffer.AddaptiveBufferManager) *StreamHandler {
+	return &StreamHandler{
+		topicManager:  tm,
+		bufferManager: bufferMgr,
+	}
+}
+
+// ServeHTTP streams a topic as newline-delimited JSON over a chunked
+// response. It takes the same query parameters as /sse.
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity := auth.Current(r.Context())
+
+	opts, err := core.ParseSubscribeOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.AckMode {
+		http.Error(w, "ack mode is not supported over NDJSON streams", http.StatusBadRequest)
+		return
+	}
+	if opts.BinaryPayloads {
+		http.Error(w, "binary payloads are only supported over WebSocket", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.topicManager.CheckSubscribe(identity, opts.Topic); err != nil {
+		http.Error(w, err.Error(), statusFor(w, err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	transport := core.NewNDJSONTransport(w, flusher)
+	streamSubscription(r.Context(), h.topicManager, identity, opts, transport, h.bufferManager.GetBufferSize())
+}
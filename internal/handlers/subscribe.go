@@ -1,63 +1,113 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
 	"github.com/AadityaChoubey68/clevr-live/internal/buffer"
+	"github.com/AadityaChoubey68/clevr-live/internal/codec"
 	"github.com/AadityaChoubey68/clevr-live/internal/core"
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
 )
 
+// authFrameTimeout bounds how long an upgraded but unauthenticated socket may
+// wait before sending its auth frame.
+const authFrameTimeout = 10 * time.Second
+
 type SubscriberHandler struct {
 	topicManager  *core.TopicManager
 	bufferManager *buffer.AddaptiveBufferManager
+	auth          *auth.Middleware
 }
 
-func NewSubscribeHandler(tm *core.TopicManager, bufferMgr *buffer.AddaptiveBufferManager) *SubscriberHandler {
+func NewSubscribeHandler(tm *core.TopicManager, bufferMgr *buffer.AddaptiveBufferManager, authMiddleware *auth.Middleware) *SubscriberHandler {
 	return &SubscriberHandler{
 		topicManager:  tm,
 		bufferManager: bufferMgr,
+		auth:          authMiddleware,
 	}
 }
 
+// ServeHTTP upgrades to a WebSocket session. With a topic query parameter the
+// socket starts subscribed to it; without one, the client drives everything
+// through subscribe / unsubscribe control frames.
+//
+// A client that could not send a credential with the upgrade request must
+// send {"type":"auth","token":"..."} as its first frame.
+//
+// Frames are JSON unless the client offers one of the codec subprotocols
+// (clevr.json, clevr.msgpack, clevr.cbor, clevr.proto); the first the
+// server supports, in that order, is used for the whole connection.
 func (h *SubscriberHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	tenant_id, ok := r.Context().Value("tenantId").(string)
-	if !ok || tenant_id == "" {
-		tenant_id = "default-tenant"
+	var initial *core.SubscribeOptions
+	if r.URL.Query().Get("topic") != "" {
+		opts, err := core.ParseSubscribeOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		initial = &opts
 	}
 
-	topic := r.URL.Query().Get("topic")
-	if topic == "" {
-		http.Error(w, "Topic parameter is required", http.StatusBadRequest)
-		return
+	// Refuse a forbidden or over-quota initial subscription before upgrading,
+	// while a plain HTTP error is still possible. First-frame auth is checked
+	// on subscribe.
+	if identity, ok := auth.FromContext(r.Context()); ok && initial != nil {
+		if err := h.topicManager.CheckSubscribe(identity, initial.Topic); err != nil {
+			http.Error(w, err.Error(), statusFor(w, err))
+			return
+		}
 	}
 
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		OriginPatterns: []string{"*"},
+		Subprotocols:   codec.Subprotocols(),
 	})
 	if err != nil {
 		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
 		return
 	}
 
-	subscriberID := uuid.New().String()
+	wireCodec, _ := codec.ForSubprotocol(conn.Subprotocol())
 
-	bufferSize := h.bufferManager.GetBufferSize()
+	identity, ok := auth.FromContext(r.Context())
+	if !ok {
+		identity, err = h.authenticateFirstFrame(r.Context(), conn, wireCodec)
+		if err != nil {
+			conn.Close(websocket.StatusPolicyViolation, fmt.Sprintf("Unauthorized: %v", err))
+			return
+		}
+	}
 
-	ctx := r.Context()
+	sessionID := uuid.New().String()
 
-	subscriber := core.NewSubscriber(subscriberID, tenant_id, topic, conn, ctx, bufferSize)
+	session := core.NewSession(sessionID, identity, conn, wireCodec, r.Context(), h.topicManager, h.bufferManager.GetBufferSize)
+	session.Run(initial)
+}
 
-	if err := h.topicManager.Subscribe(tenant_id, topic, subscriberID, subscriber); err != nil {
-		conn.Close(websocket.StatusInternalError, fmt.Sprintf("Failed to subscribe: %v", err))
-		return
-	}
+func (h *SubscriberHandler) authenticateFirstFrame(ctx context.Context, conn *websocket.Conn, c codec.Codec) (auth.Identity, error) {
+	ctx, cancel := context.WithTimeout(ctx, authFrameTimeout)
+	defer cancel()
 
-	<-subscriber.Context().Done()
+	conn.SetReadLimit(core.MaxFrameBytes)
 
-	h.topicManager.Unsubscribe(tenant_id, topic, subscriberID)
+	frame, err := core.ReadClientFrame(ctx, conn, c)
+	if err != nil {
+		return auth.Identity{}, fmt.Errorf("no auth frame received")
+	}
+	if frame.Type != "auth" {
+		return auth.Identity{}, fmt.Errorf("expected an auth frame, got %q", frame.Type)
+	}
+
+	identity, err := h.auth.Authenticate(frame.Token)
+	if err != nil {
+		return auth.Identity{}, err
+	}
 
-	fmt.Printf("Subscriber %s disconnected from %s:%s\n", subscriberID, tenant_id, topic)
+	err = core.WriteServerFrame(ctx, conn, c, core.ServerFrame{Type: "authenticated", RequestID: frame.RequestID})
+	return identity, err
 }
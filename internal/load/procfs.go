@@ -0,0 +1,180 @@
+package load
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// clockTicks is USER_HZ, the unit of /proc CPU times. It is 100 on every
+	// mainstream Linux architecture and cannot be read without cgo.
+	clockTicks = 100
+
+	cgroupRoot = "/sys/fs/cgroup"
+
+	// cgroup v1 reports "no limit" as a page-aligned value near MaxInt64.
+	cgroupUnlimited = 1 << 60
+)
+
+// procSelfCPUSeconds reads user plus system CPU time from /proc/self/stat.
+func procSelfCPUSeconds() (float64, bool) {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, false
+	}
+	return parseProcStat(string(data))
+}
+
+// parseProcStat returns user plus system CPU seconds from a /proc/<pid>/stat
+// line.
+func parseProcStat(stat string) (float64, bool) {
+	// The command name in field 2 may contain spaces, so count fields from
+	// the closing parenthesis: utime and stime are fields 14 and 15.
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 13 {
+		return 0, false
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return float64(utime+stime) / clockTicks, true
+}
+
+// cgroupPaths maps each controller to this process's cgroup path from
+// /proc/self/cgroup.
+func cgroupPaths() map[string]string {
+	file, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	return parseCgroupPaths(file)
+}
+
+// parseCgroupPaths reads /proc/<pid>/cgroup lines. The v2 unified hierarchy
+// is keyed by "".
+func parseCgroupPaths(r io.Reader) map[string]string {
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths
+}
+
+// cgroupFS is a cgroup mount and this process's paths within it.
+type cgroupFS struct {
+	root  string
+	paths map[string]string
+}
+
+func hostCgroup() cgroupFS {
+	return cgroupFS{root: cgroupRoot, paths: cgroupPaths()}
+}
+
+func (fs cgroupFS) isV2() bool {
+	_, err := os.Stat(filepath.Join(fs.root, "cgroup.controllers"))
+	return err == nil
+}
+
+// read reads name from the process's own directory of the controller's
+// hierarchy ("" for v2), falling back to the hierarchy's root, which is
+// where a container sees its own cgroup.
+func (fs cgroupFS) read(controller, name string) (string, bool) {
+	mount := filepath.Join(fs.root, controller)
+	for _, dir := range []string{filepath.Join(mount, fs.paths[controller]), mount} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return strings.TrimSpace(string(data)), true
+		}
+	}
+	return "", false
+}
+
+func cgroupMemoryLimit() (uint64, bool) {
+	return hostCgroup().memoryLimit()
+}
+
+func (fs cgroupFS) memoryLimit() (uint64, bool) {
+	if fs.isV2() {
+		value, ok := fs.read("", "memory.max")
+		if !ok || value == "max" {
+			return 0, false
+		}
+		limit, err := strconv.ParseUint(value, 10, 64)
+		return limit, err == nil
+	}
+
+	value, ok := fs.read("memory", "memory.limit_in_bytes")
+	if !ok {
+		return 0, false
+	}
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || limit >= cgroupUnlimited {
+		return 0, false
+	}
+	return limit, true
+}
+
+// cgroupCPUQuota returns the number of CPUs the cgroup may use, e.g. 1.5.
+func cgroupCPUQuota() (float64, bool) {
+	return hostCgroup().cpuQuota()
+}
+
+func (fs cgroupFS) cpuQuota() (float64, bool) {
+	var quota, period string
+	if fs.isV2() {
+		value, ok := fs.read("", "cpu.max")
+		if !ok {
+			return 0, false
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		quota, period = fields[0], fields[1]
+	} else {
+		var ok bool
+		if quota, ok = fs.read("cpu", "cpu.cfs_quota_us"); !ok {
+			return 0, false
+		}
+		if period, ok = fs.read("cpu", "cpu.cfs_period_us"); !ok {
+			return 0, false
+		}
+	}
+
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
@@ -0,0 +1,156 @@
+package load
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		stat    string
+		seconds float64
+		ok      bool
+	}{
+		{"plain", "1234 (clevr) S 1 1234 1234 0 -1 4194560 500 0 0 0 250 150 0 0 20 0 8 0 100 0", 4, true},
+		{"spaces and parentheses in the name", "1234 (a) b (c) S 1 1234 1234 0 -1 4194560 500 0 0 0 7 3 0 0 20 0 8 0 100 0", 0.1, true},
+		{"truncated", "1234 (clevr) S 1 1234 1234 0 -1 4194560 500 0 0 0 250", 0, false},
+		{"no name", "1234 clevr S 1 1234 1234 0 -1 4194560 500 0 0 0 250 150", 0, false},
+		{"not a number", "1234 (clevr) S 1 1234 1234 0 -1 4194560 500 0 0 0 x 150 0", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seconds, ok := parseProcStat(tt.stat)
+			if ok != tt.ok || seconds != tt.seconds {
+				t.Fatalf("got %v, %v; want %v, %v", seconds, ok, tt.seconds, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseCgroupPaths(t *testing.T) {
+	v1 := "12:memory:/docker/abc\n" +
+		"4:cpu,cpuacct:/docker/abc/cpu\n" +
+		"1:name=systemd:/init.scope\n" +
+		"0::/unified\n" +
+		"garbage\n"
+	paths := parseCgroupPaths(strings.NewReader(v1))
+	want := map[string]string{
+		"memory":       "/docker/abc",
+		"cpu":          "/docker/abc/cpu",
+		"cpuacct":      "/docker/abc/cpu",
+		"name=systemd": "/init.scope",
+		"":             "/unified",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("v1 paths %v, want %v", paths, want)
+	}
+	for controller, path := range want {
+		if paths[controller] != path {
+			t.Fatalf("v1 paths %v, want %v", paths, want)
+		}
+	}
+
+	// A path may itself contain colons.
+	paths = parseCgroupPaths(strings.NewReader("0::/system.slice/a:b.service\n"))
+	if len(paths) != 1 || paths[""] != "/system.slice/a:b.service" {
+		t.Fatalf("v2 paths %v", paths)
+	}
+}
+
+// cgroupFixture lays out files under a temporary cgroup root, keyed by their
+// path relative to it.
+func cgroupFixture(t *testing.T, paths map[string]string, files map[string]string) cgroupFS {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cgroupFS{root: root, paths: paths}
+}
+
+func TestCgroupV2(t *testing.T) {
+	own := map[string]string{"": "/app"}
+	tests := []struct {
+		name   string
+		files  map[string]string
+		memory uint64
+		cpus   float64
+		ok     bool
+	}{
+		{"limited", map[string]string{"app/memory.max": "536870912", "app/cpu.max": "150000 100000"}, 512 << 20, 1.5, true},
+		{"unlimited", map[string]string{"app/memory.max": "max", "app/cpu.max": "max 100000"}, 0, 0, false},
+		{"missing", map[string]string{}, 0, 0, false},
+		{"malformed", map[string]string{"app/memory.max": "lots", "app/cpu.max": "150000"}, 0, 0, false},
+		// A container's namespace hides its own path; its files are at the root.
+		{"mount root", map[string]string{"memory.max": "1048576", "cpu.max": "50000 100000"}, 1 << 20, 0.5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.files["cgroup.controllers"] = "cpu memory"
+			fs := cgroupFixture(t, own, tt.files)
+			if !fs.isV2() {
+				t.Fatal("fixture not seen as cgroup v2")
+			}
+			if memory, ok := fs.memoryLimit(); ok != tt.ok || memory != tt.memory {
+				t.Fatalf("memory limit %d, %v; want %d, %v", memory, ok, tt.memory, tt.ok)
+			}
+			if cpus, ok := fs.cpuQuota(); ok != tt.ok || cpus != tt.cpus {
+				t.Fatalf("CPU quota %v, %v; want %v, %v", cpus, ok, tt.cpus, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCgroupV1(t *testing.T) {
+	own := map[string]string{"memory": "/docker/abc", "cpu": "/docker/abc"}
+	tests := []struct {
+		name   string
+		files  map[string]string
+		memory uint64
+		cpus   float64
+		ok     bool
+	}{
+		{"limited", map[string]string{
+			"memory/docker/abc/memory.limit_in_bytes": "268435456",
+			"cpu/docker/abc/cpu.cfs_quota_us":         "200000",
+			"cpu/docker/abc/cpu.cfs_period_us":        "100000",
+		}, 256 << 20, 2, true},
+		{"unlimited", map[string]string{
+			"memory/docker/abc/memory.limit_in_bytes": "9223372036854771712",
+			"cpu/docker/abc/cpu.cfs_quota_us":         "-1",
+			"cpu/docker/abc/cpu.cfs_period_us":        "100000",
+		}, 0, 0, false},
+		{"no period", map[string]string{
+			"memory/docker/abc/memory.limit_in_bytes": "nope",
+			"cpu/docker/abc/cpu.cfs_quota_us":         "200000",
+		}, 0, 0, false},
+		{"mount root", map[string]string{
+			"memory/memory.limit_in_bytes": "1048576",
+			"cpu/cpu.cfs_quota_us":         "25000",
+			"cpu/cpu.cfs_period_us":        "100000",
+		}, 1 << 20, 0.25, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := cgroupFixture(t, own, tt.files)
+			if fs.isV2() {
+				t.Fatal("fixture seen as cgroup v2")
+			}
+			if memory, ok := fs.memoryLimit(); ok != tt.ok || memory != tt.memory {
+				t.Fatalf("memory limit %d, %v; want %d, %v", memory, ok, tt.memory, tt.ok)
+			}
+			if cpus, ok := fs.cpuQuota(); ok != tt.ok || cpus != tt.cpus {
+				t.Fatalf("CPU quota %v, %v; want %v, %v", cpus, ok, tt.cpus, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package load
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// Sample is a point-in-time view of how loaded the process is.
+type Sample struct {
+	// CPU is the share of the CPUs available to the process that it used
+	// since the previous sample, from 0 to 1.
+	CPU       float64
+	CPUs      float64
+	CPUSource string
+
+	// Memory is MemoryUsed / MemoryLimit, from 0 to 1.
+	Memory            float64
+	MemoryUsed        uint64
+	MemoryLimit       uint64
+	MemoryLimitSource string
+
+	Time time.Time
+}
+
+// LoadSampler reports process load to the throttler and buffer manager.
+type LoadSampler interface {
+	Sample() Sample
+}
+
+// ProcessSampler samples real process CPU time and Go memory use against the
+// tightest of the configured maximum, the cgroup (v1 or v2) memory limit and
+// GOMEMLIMIT. It samples in the background so every reader sees the same
+// numbers and CPU deltas are measured over a steady interval.
+type ProcessSampler struct {
+	maxMemory uint64
+	interval  time.Duration
+
+	current  atomic.Pointer[Sample]
+	lastCPU  float64
+	lastTime time.Time
+
+	stopChan chan struct{}
+}
+
+func NewProcessSampler(maxMemory int64, interval time.Duration) *ProcessSampler {
+	ps := &ProcessSampler{
+		maxMemory: uint64(maxMemory),
+		interval:  interval,
+		stopChan:  make(chan struct{}),
+	}
+
+	ps.lastCPU, _ = processCPUSeconds()
+	ps.lastTime = time.Now()
+	ps.sample()
+
+	return ps
+}
+
+func (ps *ProcessSampler) Start() {
+	go ps.sampleLoop()
+}
+
+func (ps *ProcessSampler) Stop() {
+	close(ps.stopChan)
+}
+
+func (ps *ProcessSampler) sampleLoop() {
+	ticker := time.NewTicker(ps.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ps.sample()
+		case <-ps.stopChan:
+			return
+		}
+	}
+}
+
+func (ps *ProcessSampler) Sample() Sample {
+	return *ps.current.Load()
+}
+
+func (ps *ProcessSampler) sample() {
+	now := time.Now()
+	s := Sample{Time: now}
+
+	cpuSeconds, source := processCPUSeconds()
+	s.CPUSource = source
+	s.CPUs = availableCPUs()
+
+	if elapsed := now.Sub(ps.lastTime).Seconds(); elapsed > 0 {
+		s.CPU = clamp((cpuSeconds - ps.lastCPU) / (elapsed * s.CPUs))
+	}
+	ps.lastCPU = cpuSeconds
+	ps.lastTime = now
+
+	s.MemoryUsed = goMemoryUsed()
+	s.MemoryLimit, s.MemoryLimitSource = ps.memoryLimit()
+	if s.MemoryLimit > 0 {
+		s.Memory = clamp(float64(s.MemoryUsed) / float64(s.MemoryLimit))
+	}
+
+	ps.current.Store(&s)
+}
+
+// memoryLimit picks the tightest limit that applies to the process.
+func (ps *ProcessSampler) memoryLimit() (uint64, string) {
+	limit, source := ps.maxMemory, "config"
+
+	if cgroup, ok := cgroupMemoryLimit(); ok && (limit == 0 || cgroup < limit) {
+		limit, source = cgroup, "cgroup"
+	}
+
+	// SetMemoryLimit with a negative value only reads the current limit,
+	// which is math.MaxInt64 when GOMEMLIMIT is unset.
+	if goLimit := debug.SetMemoryLimit(-1); goLimit > 0 && uint64(goLimit) < limit {
+		limit, source = uint64(goLimit), "gomemlimit"
+	}
+
+	return limit, source
+}
+
+func (ps *ProcessSampler) GetMetrics() map[string]interface{} {
+	s := ps.Sample()
+	return map[string]interface{}{
+		"cpu_usage":           s.CPU,
+		"cpus":                s.CPUs,
+		"cpu_source":          s.CPUSource,
+		"memory_usage":        s.Memory,
+		"memory_used_bytes":   s.MemoryUsed,
+		"memory_limit_bytes":  s.MemoryLimit,
+		"memory_limit_source": s.MemoryLimitSource,
+	}
+}
+
+func (s Sample) String() string {
+	return fmt.Sprintf("cpu=%.2f of %.1f CPUs (%s), memory=%.2f of %d bytes (%s)",
+		s.CPU, s.CPUs, s.CPUSource, s.Memory, s.MemoryLimit, s.MemoryLimitSource)
+}
+
+// processCPUSeconds prefers the kernel's accounting and falls back to the Go
+// runtime's estimate where /proc is unavailable.
+func processCPUSeconds() (float64, string) {
+	if seconds, ok := procSelfCPUSeconds(); ok {
+		return seconds, "procfs"
+	}
+	return runtimeCPUSeconds(), "runtime"
+}
+
+func runtimeCPUSeconds() float64 {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+	}
+	metrics.Read(samples)
+
+	if samples[0].Value.Kind() != metrics.KindFloat64 || samples[1].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return samples[0].Value.Float64() - samples[1].Value.Float64()
+}
+
+// goMemoryUsed is the memory the Go runtime holds from the OS, the same
+// figure GOMEMLIMIT is enforced against.
+func goMemoryUsed() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+
+	if samples[0].Value.Kind() != metrics.KindUint64 || samples[1].Value.Kind() != metrics.KindUint64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.Sys - m.HeapReleased
+	}
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+func availableCPUs() float64 {
+	cpus := float64(runtime.NumCPU())
+	if quota, ok := cgroupCPUQuota(); ok && quota < cpus {
+		return quota
+	}
+	return cpus
+}
+
+func clamp(value float64) float64 {
+	if value < 0 {
+		return 0
+	}
+	if value > 1 {
+		return 1
+	}
+	return value
+}
@@ -0,0 +1,84 @@
+package quota
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// newTokenBucket starts full. The burst is one second's worth of tokens.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take removes n tokens, or returns how long until they would be available.
+// A request larger than the burst only needs a full bucket, so oversized
+// messages are slowed down rather than refused forever.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+
+	missing := n - b.tokens
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+// refund returns tokens taken for a request that was refused by another
+// bucket.
+func (b *tokenBucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > b.burst {
+		n = b.burst
+	}
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,142 @@
+package quota
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits caps what a tenant or principal may do. Zero means unlimited.
+// MaxTopics and MaxSubscribers only apply per tenant.
+type Limits struct {
+	MessagesPerSec  float64
+	BytesPerSec     float64
+	MaxMessageBytes int
+	MaxTopics       int
+	MaxSubscribers  int
+}
+
+// merge fills the unset fields of l from defaults.
+func (l Limits) merge(defaults Limits) Limits {
+	if l.MessagesPerSec == 0 {
+		l.MessagesPerSec = defaults.MessagesPerSec
+	}
+	if l.BytesPerSec == 0 {
+		l.BytesPerSec = defaults.BytesPerSec
+	}
+	if l.MaxMessageBytes == 0 {
+		l.MaxMessageBytes = defaults.MaxMessageBytes
+	}
+	if l.MaxTopics == 0 {
+		l.MaxTopics = defaults.MaxTopics
+	}
+	if l.MaxSubscribers == 0 {
+		l.MaxSubscribers = defaults.MaxSubscribers
+	}
+	return l
+}
+
+func (l Limits) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"msgs_per_sec":      l.MessagesPerSec,
+		"bytes_per_sec":     l.BytesPerSec,
+		"max_message_bytes": l.MaxMessageBytes,
+		"max_topics":        l.MaxTopics,
+		"max_subscribers":   l.MaxSubscribers,
+	}
+}
+
+// Config is a parsed quota file.
+type Config struct {
+	Defaults   Limits
+	Tenants    map[string]Limits
+	Principals map[string]Limits
+}
+
+// Parse reads one scope per line followed by key=value limits:
+//
+//	default               msgs_per_sec=1000 bytes_per_sec=1048576 max_message_bytes=65536
+//	tenant:acme           msgs_per_sec=5000 max_topics=200 max_subscribers=1000
+//	principal:acme/ingest msgs_per_sec=100
+//
+// Tenant limits fall back to the default line; principal limits apply on top
+// of their tenant's. Blank lines and lines starting with # are ignored.
+func Parse(r io.Reader) (Config, error) {
+	config := Config{
+		Tenants:    make(map[string]Limits),
+		Principals: make(map[string]Limits),
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		limits, err := parseLimits(fields[1:])
+		if err != nil {
+			return Config{}, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		scope := fields[0]
+		switch {
+		case scope == "default":
+			config.Defaults = limits
+		case strings.HasPrefix(scope, "tenant:") && len(scope) > len("tenant:"):
+			config.Tenants[strings.TrimPrefix(scope, "tenant:")] = limits
+		case strings.HasPrefix(scope, "principal:"):
+			key := strings.TrimPrefix(scope, "principal:")
+			if tenant, principal, ok := strings.Cut(key, "/"); !ok || tenant == "" || principal == "" {
+				return Config{}, fmt.Errorf("line %d: principal scope must be principal:<tenant>/<principal>", lineNumber)
+			}
+			if limits.MaxTopics != 0 || limits.MaxSubscribers != 0 {
+				return Config{}, fmt.Errorf("line %d: max_topics and max_subscribers are per tenant only", lineNumber)
+			}
+			config.Principals[key] = limits
+		default:
+			return Config{}, fmt.Errorf("line %d: invalid scope %q: expected default, tenant:<id> or principal:<tenant>/<principal>", lineNumber, scope)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func parseLimits(fields []string) (Limits, error) {
+	var limits Limits
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Limits{}, fmt.Errorf("invalid limit %q: expected key=value", field)
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || number < 0 {
+			return Limits{}, fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+
+		switch key {
+		case "msgs_per_sec":
+			limits.MessagesPerSec = number
+		case "bytes_per_sec":
+			limits.BytesPerSec = number
+		case "max_message_bytes":
+			limits.MaxMessageBytes = int(number)
+		case "max_topics":
+			limits.MaxTopics = int(number)
+		case "max_subscribers":
+			limits.MaxSubscribers = int(number)
+		default:
+			return Limits{}, fmt.Errorf("unknown limit %q", key)
+		}
+	}
+	return limits, nil
+}
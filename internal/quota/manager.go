@@ -0,0 +1,318 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+)
+
+const (
+	LimitMessageRate  = "msgs_per_sec"
+	LimitByteRate     = "bytes_per_sec"
+	LimitMessageBytes = "max_message_bytes"
+	LimitTopics       = "max_topics"
+	LimitSubscribers  = "max_subscribers"
+)
+
+var ErrExceeded = errors.New("quota exceeded")
+
+// ExceededError reports which limit was hit and, for rate limits, how long
+// until the request would fit.
+type ExceededError struct {
+	Scope      string
+	Limit      string
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("quota exceeded for %s: %s, retry after %s", e.Scope, e.Limit, e.RetryAfter.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("quota exceeded for %s: %s", e.Scope, e.Limit)
+}
+
+func (e *ExceededError) Is(target error) bool {
+	return target == ErrExceeded
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds for a Retry-After
+// header.
+func (e *ExceededError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// usage tracks one tenant or principal against its limits.
+type usage struct {
+	scope    string
+	limits   Limits
+	messages *tokenBucket
+	bytes    *tokenBucket
+
+	topics      atomic.Int64
+	subscribers atomic.Int64
+
+	published           atomic.Int64
+	publishedBytes      atomic.Int64
+	rateLimited         atomic.Int64
+	tooLarge            atomic.Int64
+	topicsRejected      atomic.Int64
+	subscribersRejected atomic.Int64
+}
+
+func newUsage(scope string, limits Limits) *usage {
+	return &usage{
+		scope:    scope,
+		limits:   limits,
+		messages: newTokenBucket(limits.MessagesPerSec),
+		bytes:    newTokenBucket(limits.BytesPerSec),
+	}
+}
+
+func (u *usage) exceeded(limit string, retryAfter time.Duration) error {
+	return &ExceededError{Scope: u.scope, Limit: limit, RetryAfter: retryAfter}
+}
+
+func (u *usage) getMetrics() map[string]interface{} {
+	return map[string]interface{}{
+		"limits":               u.limits.toMap(),
+		"topics":               u.topics.Load(),
+		"subscribers":          u.subscribers.Load(),
+		"published":            u.published.Load(),
+		"published_bytes":      u.publishedBytes.Load(),
+		"rate_limited":         u.rateLimited.Load(),
+		"too_large":            u.tooLarge.Load(),
+		"topics_rejected":      u.topicsRejected.Load(),
+		"subscribers_rejected": u.subscribersRejected.Load(),
+	}
+}
+
+// Manager enforces per-tenant and per-principal limits and records usage
+// for every tenant, limited or not.
+type Manager struct {
+	config Config
+
+	tenants    map[string]*usage
+	principals map[string]*usage
+	mu         sync.Mutex
+}
+
+func NewManager(config Config) *Manager {
+	if config.Tenants == nil {
+		config.Tenants = make(map[string]Limits)
+	}
+	if config.Principals == nil {
+		config.Principals = make(map[string]Limits)
+	}
+
+	return &Manager{
+		config:     config,
+		tenants:    make(map[string]*usage),
+		principals: make(map[string]*usage),
+	}
+}
+
+func Load(path string) (*Manager, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open quota file: %w", err)
+	}
+	defer file.Close()
+
+	config, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewManager(config), nil
+}
+
+func (m *Manager) tenant(tenantID string) *usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, exists := m.tenants[tenantID]
+	if !exists {
+		u = newUsage(tenantID, m.config.Tenants[tenantID].merge(m.config.Defaults))
+		m.tenants[tenantID] = u
+	}
+	return u
+}
+
+// principal returns nil for principals without limits of their own.
+func (m *Manager) principal(tenantID, principal string) *usage {
+	key := tenantID + "/" + principal
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, exists := m.principals[key]
+	if !exists {
+		limits, configured := m.config.Principals[key]
+		if !configured {
+			return nil
+		}
+		u = newUsage(key, limits)
+		m.principals[key] = u
+	}
+	return u
+}
+
+// AllowPublish charges one message of size bytes against the principal's
+// and the tenant's limits. Nothing is charged when either refuses it.
+func (m *Manager) AllowPublish(id auth.Identity, size int) error {
+	scopes := m.publishScopes(id)
+
+	for _, u := range scopes {
+		if u.limits.MaxMessageBytes > 0 && size > u.limits.MaxMessageBytes {
+			u.tooLarge.Add(1)
+			return u.exceeded(LimitMessageBytes, 0)
+		}
+	}
+
+	now := time.Now()
+	for i, u := range scopes {
+		if wait := u.messages.take(1, now); wait > 0 {
+			refund(scopes[:i], size)
+			u.rateLimited.Add(1)
+			return u.exceeded(LimitMessageRate, wait)
+		}
+		if wait := u.bytes.take(float64(size), now); wait > 0 {
+			u.messages.refund(1)
+			refund(scopes[:i], size)
+			u.rateLimited.Add(1)
+			return u.exceeded(LimitByteRate, wait)
+		}
+	}
+
+	for _, u := range scopes {
+		u.published.Add(1)
+		u.publishedBytes.Add(int64(size))
+	}
+	return nil
+}
+
+// RefundPublish returns what AllowPublish charged for a message that was
+// then not published, because the server refused or failed to take it.
+func (m *Manager) RefundPublish(id auth.Identity, size int) {
+	scopes := m.publishScopes(id)
+	refund(scopes, size)
+	for _, u := range scopes {
+		u.published.Add(-1)
+		u.publishedBytes.Add(-int64(size))
+	}
+}
+
+// publishScopes is every usage a publish by id is charged to, the
+// principal's first.
+func (m *Manager) publishScopes(id auth.Identity) []*usage {
+	scopes := []*usage{m.tenant(id.TenantID)}
+	if p := m.principal(id.TenantID, id.Principal); p != nil {
+		scopes = append([]*usage{p}, scopes...)
+	}
+	return scopes
+}
+
+func refund(scopes []*usage, size int) {
+	for _, u := range scopes {
+		u.messages.refund(1)
+		u.bytes.refund(float64(size))
+	}
+}
+
+// ReserveTopic counts a new topic for the tenant, refusing it at the limit.
+func (m *Manager) ReserveTopic(tenantID string) error {
+	u := m.tenant(tenantID)
+	if n := u.topics.Add(1); u.limits.MaxTopics > 0 && n > int64(u.limits.MaxTopics) {
+		u.topics.Add(-1)
+		u.topicsRejected.Add(1)
+		return u.exceeded(LimitTopics, 0)
+	}
+	return nil
+}
+
+// ReleaseTopic returns a topic to the tenant's quota, when the topic could
+// not be created or has been removed.
+func (m *Manager) ReleaseTopic(tenantID string) {
+	m.tenant(tenantID).topics.Add(-1)
+}
+
+// AddTopic counts a topic the server created itself, which is never refused.
+func (m *Manager) AddTopic(tenantID string) {
+	m.tenant(tenantID).topics.Add(1)
+}
+
+// CheckSubscriber reports whether the tenant could add a subscriber now,
+// without reserving one.
+func (m *Manager) CheckSubscriber(tenantID string) error {
+	u := m.tenant(tenantID)
+	if u.limits.MaxSubscribers > 0 && u.subscribers.Load() >= int64(u.limits.MaxSubscribers) {
+		u.subscribersRejected.Add(1)
+		return u.exceeded(LimitSubscribers, 0)
+	}
+	return nil
+}
+
+func (m *Manager) ReserveSubscriber(tenantID string) error {
+	u := m.tenant(tenantID)
+	if n := u.subscribers.Add(1); u.limits.MaxSubscribers > 0 && n > int64(u.limits.MaxSubscribers) {
+		u.subscribers.Add(-1)
+		u.subscribersRejected.Add(1)
+		return u.exceeded(LimitSubscribers, 0)
+	}
+	return nil
+}
+
+func (m *Manager) ReleaseSubscriber(tenantID string) {
+	m.tenant(tenantID).subscribers.Add(-1)
+}
+
+// GetMetrics reports usage per tenant, and per principal for principals with
+// limits of their own.
+func (m *Manager) GetMetrics() map[string]interface{} {
+	return m.metrics(func(string) bool { return true })
+}
+
+// GetTenantMetrics reports the same as GetMetrics for one tenant and its
+// principals only.
+func (m *Manager) GetTenantMetrics(tenantID string) map[string]interface{} {
+	return m.metrics(func(id string) bool { return id == tenantID })
+}
+
+// metrics reports the tenants include accepts and their principals.
+func (m *Manager) metrics(include func(tenantID string) bool) map[string]interface{} {
+	m.mu.Lock()
+	tenants := make(map[string]*usage, len(m.tenants))
+	for id, u := range m.tenants {
+		if include(id) {
+			tenants[id] = u
+		}
+	}
+	principals := make(map[string]*usage, len(m.principals))
+	for id, u := range m.principals {
+		tenantID, _, _ := strings.Cut(id, "/")
+		if include(tenantID) {
+			principals[id] = u
+		}
+	}
+	m.mu.Unlock()
+
+	tenantMetrics := make(map[string]interface{}, len(tenants))
+	for id, u := range tenants {
+		tenantMetrics[id] = u.getMetrics()
+	}
+	principalMetrics := make(map[string]interface{}, len(principals))
+	for id, u := range principals {
+		principalMetrics[id] = u.getMetrics()
+	}
+
+	return map[string]interface{}{
+		"tenants":    tenantMetrics,
+		"principals": principalMetrics,
+	}
+}
@@ -0,0 +1,268 @@
+package quota
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/auth"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10)
+	start := b.last
+
+	// It starts with one second of burst.
+	for i := 0; i < 10; i++ {
+		if wait := b.take(1, start); wait != 0 {
+			t.Fatalf("take %d waited %s", i, wait)
+		}
+	}
+	if wait := b.take(1, start); wait != 100*time.Millisecond {
+		t.Fatalf("empty bucket waited %s, want 100ms", wait)
+	}
+	if wait := b.take(1, start.Add(50*time.Millisecond)); wait != 50*time.Millisecond {
+		t.Fatalf("half refilled bucket waited %s, want 50ms", wait)
+	}
+	if wait := b.take(1, start.Add(100*time.Millisecond)); wait != 0 {
+		t.Fatalf("refilled bucket waited %s", wait)
+	}
+
+	// Refill stops at the burst, however long the bucket sits idle.
+	later := start.Add(time.Minute)
+	if wait := b.take(10, later); wait != 0 {
+		t.Fatalf("full bucket waited %s", wait)
+	}
+	if wait := b.take(1, later); wait == 0 {
+		t.Fatal("bucket held more than its burst")
+	}
+
+	// A request larger than the burst needs only a full bucket.
+	if wait := b.take(25, later.Add(time.Second)); wait != 0 {
+		t.Fatalf("oversized request on a full bucket waited %s", wait)
+	}
+
+	// Refunds are capped at the burst as well.
+	b.refund(25)
+	b.refund(25)
+	if b.tokens != b.burst {
+		t.Fatalf("%v tokens after refunds, burst is %v", b.tokens, b.burst)
+	}
+
+	if slow := newTokenBucket(0.5); slow.burst != 1 {
+		t.Fatalf("burst for half a token a second is %v, want 1", slow.burst)
+	}
+	unlimited := newTokenBucket(0)
+	if wait := unlimited.take(1e9, later); wait != 0 {
+		t.Fatalf("unlimited bucket waited %s", wait)
+	}
+	unlimited.refund(1)
+}
+
+func TestAllowPublishScopes(t *testing.T) {
+	m := NewManager(Config{
+		Tenants: map[string]Limits{
+			"acme": {MessagesPerSec: 2},
+			"beta": {MessagesPerSec: 1},
+		},
+		Principals: map[string]Limits{
+			"acme/ingest": {MessagesPerSec: 1},
+			"beta/ingest": {MessagesPerSec: 5},
+		},
+	})
+	ingest := auth.Identity{TenantID: "acme", Principal: "ingest"}
+	other := auth.Identity{TenantID: "acme", Principal: "other"}
+
+	if err := m.AllowPublish(ingest, 10); err != nil {
+		t.Fatal(err)
+	}
+	var exceeded *ExceededError
+	err := m.AllowPublish(ingest, 10)
+	if !errors.As(err, &exceeded) || exceeded.Scope != "acme/ingest" || exceeded.Limit != LimitMessageRate {
+		t.Fatalf("second publish by ingest gave %v, want the principal's rate limit", err)
+	}
+	if exceeded.RetryAfter <= 0 || exceeded.RetryAfterSeconds() != 1 {
+		t.Fatalf("retry after %s", exceeded.RetryAfter)
+	}
+
+	// The principal refused it, so the tenant still has a token left.
+	if err := m.AllowPublish(other, 10); err != nil {
+		t.Fatal(err)
+	}
+	err = m.AllowPublish(other, 10)
+	if !errors.As(err, &exceeded) || exceeded.Scope != "acme" || !errors.Is(err, ErrExceeded) {
+		t.Fatalf("third tenant publish gave %v, want the tenant's rate limit", err)
+	}
+
+	// A principal whose own limit passes gets its token back when the
+	// tenant refuses.
+	if err := m.AllowPublish(auth.Identity{TenantID: "beta", Principal: "other"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	betaIngest := auth.Identity{TenantID: "beta", Principal: "ingest"}
+	if err := m.AllowPublish(betaIngest, 10); !errors.As(err, &exceeded) || exceeded.Scope != "beta" {
+		t.Fatalf("got %v, want the tenant's rate limit", err)
+	}
+	if tokens := m.principal("beta", "ingest").messages.tokens; tokens < 5 {
+		t.Fatalf("principal has %v tokens after the tenant refused, want 5", tokens)
+	}
+}
+
+func TestAllowPublishBytes(t *testing.T) {
+	m := NewManager(Config{Defaults: Limits{MessagesPerSec: 10, BytesPerSec: 100, MaxMessageBytes: 150}})
+	id := auth.Identity{TenantID: "acme", Principal: "svc"}
+
+	err := m.AllowPublish(id, 151)
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Limit != LimitMessageBytes || exceeded.RetryAfter != 0 {
+		t.Fatalf("oversized message gave %v", err)
+	}
+	if tokens := m.tenant("acme").messages.tokens; tokens != 10 {
+		t.Fatalf("oversized message took a token: %v left", tokens)
+	}
+
+	if err := m.AllowPublish(id, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AllowPublish(id, 50); !errors.As(err, &exceeded) || exceeded.Limit != LimitByteRate {
+		t.Fatalf("got %v, want the byte rate limit", err)
+	}
+	// Only the accepted message's token is gone.
+	if tokens := m.tenant("acme").messages.tokens; tokens < 9 || tokens >= 9.5 {
+		t.Fatalf("%v message tokens left, want 9", tokens)
+	}
+
+	metrics := m.GetTenantMetrics("acme")["tenants"].(map[string]interface{})["acme"].(map[string]interface{})
+	if metrics["published"] != int64(1) || metrics["too_large"] != int64(1) || metrics["rate_limited"] != int64(1) {
+		t.Fatalf("metrics %v", metrics)
+	}
+}
+
+func TestRefundPublish(t *testing.T) {
+	m := NewManager(Config{
+		Defaults:   Limits{MessagesPerSec: 1},
+		Principals: map[string]Limits{"acme/ingest": {BytesPerSec: 100}},
+	})
+	id := auth.Identity{TenantID: "acme", Principal: "ingest"}
+
+	if err := m.AllowPublish(id, 100); err != nil {
+		t.Fatal(err)
+	}
+	m.RefundPublish(id, 100)
+
+	// Both scopes got their tokens back, and nothing counts as published.
+	if err := m.AllowPublish(id, 100); err != nil {
+		t.Fatalf("publish after a refund: %v", err)
+	}
+	m.RefundPublish(id, 100)
+	for _, u := range []*usage{m.tenant("acme"), m.principal("acme", "ingest")} {
+		if u.published.Load() != 0 || u.publishedBytes.Load() != 0 {
+			t.Fatalf("%s: %d published, %d bytes", u.scope, u.published.Load(), u.publishedBytes.Load())
+		}
+	}
+}
+
+func TestTopicQuota(t *testing.T) {
+	m := NewManager(Config{Tenants: map[string]Limits{"acme": {MaxTopics: 2}}})
+
+	for i := 0; i < 2; i++ {
+		if err := m.ReserveTopic("acme"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ReserveTopic("acme"); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("third topic gave %v", err)
+	}
+	m.ReleaseTopic("acme")
+	if err := m.ReserveTopic("acme"); err != nil {
+		t.Fatalf("topic after a release: %v", err)
+	}
+
+	// Topics the server creates are counted but never refused.
+	m.AddTopic("acme")
+	if n := m.tenant("acme").topics.Load(); n != 3 {
+		t.Fatalf("%d topics counted, want 3", n)
+	}
+	if err := m.ReserveTopic("other"); err != nil {
+		t.Fatalf("tenant without limits: %v", err)
+	}
+}
+
+func TestSubscriberQuota(t *testing.T) {
+	m := NewManager(Config{Defaults: Limits{MaxSubscribers: 1}})
+
+	if err := m.CheckSubscriber("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ReserveSubscriber("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckSubscriber("acme"); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("check at the limit gave %v", err)
+	}
+	if err := m.ReserveSubscriber("acme"); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("reserve at the limit gave %v", err)
+	}
+	m.ReleaseSubscriber("acme")
+	if err := m.ReserveSubscriber("acme"); err != nil {
+		t.Fatalf("reserve after a release: %v", err)
+	}
+
+	u := m.tenant("acme")
+	if u.subscribers.Load() != 1 || u.subscribersRejected.Load() != 2 {
+		t.Fatalf("%d subscribers, %d rejected", u.subscribers.Load(), u.subscribersRejected.Load())
+	}
+}
+
+func TestParse(t *testing.T) {
+	config, err := Parse(strings.NewReader(`
+# Shared limits.
+default               msgs_per_sec=1000 bytes_per_sec=1048576 max_message_bytes=65536
+
+tenant:acme           msgs_per_sec=5000 max_topics=200 max_subscribers=1000
+principal:acme/ingest msgs_per_sec=0.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Defaults != (Limits{MessagesPerSec: 1000, BytesPerSec: 1048576, MaxMessageBytes: 65536}) {
+		t.Fatalf("defaults %+v", config.Defaults)
+	}
+	if config.Principals["acme/ingest"] != (Limits{MessagesPerSec: 0.5}) {
+		t.Fatalf("principal %+v", config.Principals["acme/ingest"])
+	}
+
+	// A tenant falls back to the defaults for what it does not set.
+	acme := NewManager(config).tenant("acme").limits
+	want := Limits{MessagesPerSec: 5000, BytesPerSec: 1048576, MaxMessageBytes: 65536, MaxTopics: 200, MaxSubscribers: 1000}
+	if acme != want {
+		t.Fatalf("acme limits %+v, want %+v", acme, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"unknown scope", "default msgs_per_sec=1\nglobal msgs_per_sec=1\n", `line 2: invalid scope "global"`},
+		{"empty tenant", "tenant: max_topics=1\n", "line 1: invalid scope"},
+		{"missing value", "# limits\ntenant:acme max_topics\n", `line 2: invalid limit "max_topics"`},
+		{"negative value", "tenant:acme max_topics=-1\n", "line 1: invalid value for max_topics"},
+		{"not a number", "tenant:acme msgs_per_sec=fast\n", "line 1: invalid value for msgs_per_sec"},
+		{"unknown limit", "tenant:acme max_groups=1\n", `line 1: unknown limit "max_groups"`},
+		{"principal without tenant", "principal:ingest msgs_per_sec=1\n", "line 1: principal scope must be"},
+		{"principal topic limit", "principal:acme/ingest max_topics=1\n", "line 1: max_topics and max_subscribers are per tenant only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package spill
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const fileExt = ".spill"
+
+// ErrFull is returned when a record would take a queue past its own limit or
+// the manager past the global one.
+var ErrFull = errors.New("spill limit reached")
+
+// DefaultSegmentBytes is the segment size used when Config leaves it unset.
+const DefaultSegmentBytes = 4 * 1024 * 1024
+
+// Config limits spill disk use. QueueMaxBytes bounds what one queue holds
+// unread; SegmentBytes is the size at which it moves on to a new file, so
+// read records are handed back a segment at a time. MaxBytes bounds the
+// files of all queues together.
+type Config struct {
+	Dir           string
+	MaxBytes      int64
+	QueueMaxBytes int64
+	SegmentBytes  int64
+}
+
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:           dir,
+		MaxBytes:      4 * 1024 * 1024 * 1024,
+		QueueMaxBytes: 256 * 1024 * 1024,
+		SegmentBytes:  DefaultSegmentBytes,
+	}
+}
+
+// Manager hands out per-subscriber spill queues under one directory and
+// enforces the disk budget they share.
+type Manager struct {
+	config Config
+
+	bytesUsed atomic.Int64
+	queues    atomic.Int64
+	spilled   atomic.Int64
+	refused   atomic.Int64
+}
+
+// Open prepares dir for spill files. Queues do not outlive the process, so
+// any spill files left by a previous run are removed.
+func Open(config Config) (*Manager, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spill dir %s: %w", config.Dir, err)
+	}
+
+	stale, err := filepath.Glob(filepath.Join(config.Dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale spill file %s: %w", path, err)
+		}
+	}
+
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = DefaultSegmentBytes
+	}
+	return &Manager{config: config}, nil
+}
+
+// NewQueue returns an empty queue. Its file is only created on first spill.
+func (m *Manager) NewQueue() *Queue {
+	m.queues.Add(1)
+	return &Queue{manager: m}
+}
+
+func (m *Manager) reserve(n int64) bool {
+	for {
+		current := m.bytesUsed.Load()
+		if current+n > m.config.MaxBytes {
+			m.refused.Add(1)
+			return false
+		}
+		if m.bytesUsed.CompareAndSwap(current, current+n) {
+			return true
+		}
+	}
+}
+
+func (m *Manager) release(n int64) {
+	m.bytesUsed.Add(-n)
+}
+
+func (m *Manager) GetMetrics() map[string]interface{} {
+	return map[string]interface{}{
+		"dir":             m.config.Dir,
+		"bytes_used":      m.bytesUsed.Load(),
+		"max_bytes":       m.config.MaxBytes,
+		"queue_max_bytes": m.config.QueueMaxBytes,
+		"segment_bytes":   m.config.SegmentBytes,
+		"queues":          m.queues.Load(),
+		"spilled":         m.spilled.Load(),
+		"refused":         m.refused.Load(),
+	}
+}
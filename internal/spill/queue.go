@@ -0,0 +1,221 @@
+package spill
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+const headerSize = 8
+
+var errCorruptRecord = errors.New("corrupt spill record")
+
+// Queue is a FIFO of records in a chain of segment files, framed like WAL
+// records as a big-endian uint32 length, a CRC32 of the payload and the
+// payload. Records are appended to the newest segment and consumed from the
+// oldest; a segment is deleted, and its space handed back, as soon as the
+// reader is past it, so disk use follows the backlog still unread rather
+// than everything spilled since the queue was last empty.
+type Queue struct {
+	manager *Manager
+
+	mu       sync.Mutex
+	segments []*segment
+	readPos  int64
+	unread   int64
+	count    int
+	closed   bool
+}
+
+// segment is one spill file. The queue writes at size in the newest segment
+// and reads at readPos in the oldest.
+type segment struct {
+	file *os.File
+	size int64
+}
+
+// Append writes record to the end of the queue, or returns ErrFull if it
+// does not fit within the queue's or the manager's limit.
+func (q *Queue) Append(record []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return fmt.Errorf("spill queue closed")
+	}
+
+	size := int64(headerSize + len(record))
+	if q.unread+size > q.manager.config.QueueMaxBytes {
+		q.manager.refused.Add(1)
+		return ErrFull
+	}
+	if !q.manager.reserve(size) {
+		return ErrFull
+	}
+
+	tail, err := q.tail(size)
+	if err != nil {
+		q.manager.release(size)
+		return err
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(record))
+	copy(buf[headerSize:], record)
+
+	if _, err := tail.file.WriteAt(buf, tail.size); err != nil {
+		q.manager.release(size)
+		return fmt.Errorf("write spill file: %w", err)
+	}
+
+	tail.size += size
+	q.unread += size
+	q.count++
+	q.manager.spilled.Add(1)
+	return nil
+}
+
+// tail returns the segment to append size bytes to, starting a new one when
+// the newest is full. A record larger than a segment gets one of its own.
+// Callers hold mu.
+func (q *Queue) tail(size int64) (*segment, error) {
+	if n := len(q.segments); n > 0 {
+		tail := q.segments[n-1]
+		if tail.size == 0 || tail.size+size <= q.manager.config.SegmentBytes {
+			return tail, nil
+		}
+	}
+
+	file, err := os.CreateTemp(q.manager.config.Dir, "sub-*"+fileExt)
+	if err != nil {
+		return nil, fmt.Errorf("create spill file: %w", err)
+	}
+	seg := &segment{file: file}
+	q.segments = append(q.segments, seg)
+	return seg, nil
+}
+
+// Next removes and returns the oldest record. ok is false when the queue is
+// empty.
+func (q *Queue) Next() (record []byte, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.count == 0 || q.closed {
+		return nil, false, nil
+	}
+
+	head := q.segments[0]
+	var header [headerSize]byte
+	if _, err := head.file.ReadAt(header[:], q.readPos); err != nil {
+		return nil, false, q.corrupt(err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if q.readPos+headerSize+int64(length) > head.size {
+		return nil, false, q.corrupt(errCorruptRecord)
+	}
+
+	record = make([]byte, length)
+	if _, err := head.file.ReadAt(record, q.readPos+headerSize); err != nil && err != io.EOF {
+		return nil, false, q.corrupt(err)
+	}
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, false, q.corrupt(errCorruptRecord)
+	}
+
+	q.readPos += headerSize + int64(length)
+	q.unread -= headerSize + int64(length)
+	q.count--
+	if q.readPos == head.size {
+		q.advance()
+	}
+	return record, true, nil
+}
+
+// advance drops the oldest segment once it has been read through. The last
+// segment is truncated and kept for the next spill instead. Callers hold mu.
+func (q *Queue) advance() {
+	head := q.segments[0]
+	q.manager.release(head.size)
+	q.readPos = 0
+
+	if len(q.segments) == 1 {
+		if err := head.file.Truncate(0); err != nil {
+			fmt.Printf("Spill: truncate %s: %v\n", head.file.Name(), err)
+		}
+		head.size = 0
+		return
+	}
+
+	q.segments[0] = nil
+	q.segments = q.segments[1:]
+	removeSegment(head)
+}
+
+// corrupt abandons everything still queued; a record that cannot be read
+// leaves no reliable way to find the next one.
+func (q *Queue) corrupt(cause error) error {
+	lost := q.count
+	q.discard()
+	return fmt.Errorf("spill queue lost %d records: %w", lost, cause)
+}
+
+// discard deletes every segment and hands their space back. Callers hold mu.
+func (q *Queue) discard() {
+	for _, seg := range q.segments {
+		q.manager.release(seg.size)
+		removeSegment(seg)
+	}
+	q.segments = nil
+	q.readPos = 0
+	q.unread = 0
+	q.count = 0
+}
+
+func removeSegment(seg *segment) {
+	name := seg.file.Name()
+	seg.file.Close()
+	if err := os.Remove(name); err != nil {
+		fmt.Printf("Spill: remove %s: %v\n", name, err)
+	}
+}
+
+// Len is the number of records waiting to be read.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// Bytes is the disk space the queue's segments currently take, which is
+// what it is charged against the manager's limit.
+func (q *Queue) Bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var total int64
+	for _, seg := range q.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// Close discards whatever is still queued and removes the files.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.discard()
+	q.manager.queues.Add(-1)
+	return nil
+}
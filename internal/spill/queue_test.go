@@ -0,0 +1,203 @@
+package spill
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testManager(t *testing.T, config Config) *Manager {
+	t.Helper()
+	config.Dir = t.TempDir()
+	m, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// record is 10 bytes, so 18 on disk with its header.
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record-%03d", i))
+}
+
+func spillFiles(t *testing.T, m *Manager) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(m.config.Dir, "*"+fileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func expectNext(t *testing.T, q *Queue, i int) {
+	t.Helper()
+	got, ok, err := q.Next()
+	if err != nil || !ok {
+		t.Fatalf("next: ok %v, %v", ok, err)
+	}
+	if string(got) != string(record(i)) {
+		t.Fatalf("read %q, want %q", got, record(i))
+	}
+}
+
+func TestQueueFIFOAcrossSegments(t *testing.T) {
+	// Three records to a segment.
+	m := testManager(t, Config{MaxBytes: 1 << 20, QueueMaxBytes: 1 << 20, SegmentBytes: 60})
+	q := m.NewQueue()
+
+	for i := 0; i < 10; i++ {
+		if err := q.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := spillFiles(t, m); n != 4 {
+		t.Fatalf("%d segment files, want 4", n)
+	}
+
+	for i := 0; i < 4; i++ {
+		expectNext(t, q, i)
+	}
+	// The first segment is read through and gone; the second is partly read
+	// and still charged in full.
+	if n := spillFiles(t, m); n != 3 {
+		t.Fatalf("%d segment files after reading one, want 3", n)
+	}
+	if used := m.bytesUsed.Load(); used != 7*18 {
+		t.Fatalf("%d bytes charged, want %d", used, 7*18)
+	}
+
+	// Appends interleaved with reads keep their order.
+	for i := 10; i < 15; i++ {
+		if err := q.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 4; i < 15; i++ {
+		expectNext(t, q, i)
+	}
+	if _, ok, err := q.Next(); ok || err != nil {
+		t.Fatalf("next on an empty queue: ok %v, %v", ok, err)
+	}
+
+	// Drained, the queue keeps one empty segment and is charged nothing.
+	if used := m.bytesUsed.Load(); used != 0 || q.Bytes() != 0 || q.Len() != 0 {
+		t.Fatalf("%d bytes charged, %d held, %d queued after a full drain", used, q.Bytes(), q.Len())
+	}
+	if n := spillFiles(t, m); n != 1 {
+		t.Fatalf("%d segment files after a full drain, want 1", n)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	m := testManager(t, Config{MaxBytes: 100, QueueMaxBytes: 40})
+	q := m.NewQueue()
+
+	for i := 0; i < 2; i++ {
+		if err := q.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Append(record(2)); !errors.Is(err, ErrFull) {
+		t.Fatalf("append past QueueMaxBytes gave %v", err)
+	}
+
+	// The queue limit counts what is unread.
+	expectNext(t, q, 0)
+	if err := q.Append(record(2)); err != nil {
+		t.Fatalf("append after a read: %v", err)
+	}
+
+	// The manager limit counts every queue's files, read or not: 54 bytes
+	// here, so another queue fits two records and no more.
+	other := m.NewQueue()
+	for i := 0; i < 2; i++ {
+		if err := other.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.Append(record(2)); !errors.Is(err, ErrFull) {
+		t.Fatalf("append past MaxBytes gave %v", err)
+	}
+	if m.refused.Load() != 2 {
+		t.Fatalf("%d refusals counted, want 2", m.refused.Load())
+	}
+}
+
+func TestQueueCloseReleasesEverything(t *testing.T) {
+	m := testManager(t, Config{MaxBytes: 1 << 20, QueueMaxBytes: 1 << 20, SegmentBytes: 60})
+	q := m.NewQueue()
+	for i := 0; i < 10; i++ {
+		if err := q.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectNext(t, q, 0)
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if used := m.bytesUsed.Load(); used != 0 {
+		t.Fatalf("%d bytes charged after close", used)
+	}
+	if n := spillFiles(t, m); n != 0 {
+		t.Fatalf("%d segment files after close", n)
+	}
+	if m.queues.Load() != 0 {
+		t.Fatalf("%d queues counted after close", m.queues.Load())
+	}
+	if err := q.Append(record(0)); err == nil {
+		t.Fatal("append to a closed queue succeeded")
+	}
+}
+
+func TestQueueCorruptRecordDiscardsTheQueue(t *testing.T) {
+	m := testManager(t, Config{MaxBytes: 1 << 20, QueueMaxBytes: 1 << 20, SegmentBytes: 60})
+	q := m.NewQueue()
+	for i := 0; i < 5; i++ {
+		if err := q.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flip a payload byte of the second record.
+	path := q.segments[0].file.Name()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[18+headerSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	expectNext(t, q, 0)
+	if _, ok, err := q.Next(); ok || !errors.Is(err, errCorruptRecord) {
+		t.Fatalf("next over a bad checksum: ok %v, %v", ok, err)
+	}
+	if q.Len() != 0 || m.bytesUsed.Load() != 0 || spillFiles(t, m) != 0 {
+		t.Fatalf("%d queued, %d bytes charged, %d files after corruption", q.Len(), m.bytesUsed.Load(), spillFiles(t, m))
+	}
+
+	// The queue is empty, not broken.
+	if err := q.Append(record(5)); err != nil {
+		t.Fatal(err)
+	}
+	expectNext(t, q, 5)
+}
+
+func TestOpenRemovesStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "sub-1"+fileExt)
+	if err := os.WriteFile(stale, []byte("left over"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(Config{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale spill file still there: %v", err)
+	}
+}
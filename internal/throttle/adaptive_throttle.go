@@ -1,160 +1,274 @@
 package throttle
 
 import (
-	"runtime"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
 )
 
-type Config struct {
-	CPUThreshold     float64
-	MemoryThreshold  float64
-	SlowSubThreshold float64
+// ErrThrottled matches every *ThrottledError with errors.Is.
+var ErrThrottled = errors.New("publish throttled")
+
+// ThrottledError tells a publisher it was not admitted and when to retry.
+// Shed means the server is refusing publishes outright rather than pacing
+// them.
+type ThrottledError struct {
+	Rate       float64
+	RetryAfter time.Duration
+	Shed       bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Shed {
+		return fmt.Sprintf("server overloaded, retry after %dms", e.RetryAfterMs())
+	}
+	return fmt.Sprintf("publish rate limited to %.0f msg/s, retry after %dms", e.Rate, e.RetryAfterMs())
+}
+
+func (e *ThrottledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// RetryAfterMs rounds up so a sub-millisecond wait is never reported as zero.
+func (e *ThrottledError) RetryAfterMs() int64 {
+	return int64((e.RetryAfter + time.Millisecond - 1) / time.Millisecond)
+}
 
-	ThrottleDuration   time.Duration
-	CheckInterval      time.Duration
-	MinPublishInterval time.Duration
+// RetryAfterSeconds rounds up for the Retry-After header.
+func (e *ThrottledError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Config tunes the admission controller. The server counts as congested when
+// subscribers are struggling (too many slow, or buffers too full) AND the
+// process is stressed (CPU or memory over threshold). Memory at ShedMemory
+// refuses publishes outright.
+type Config struct {
+	CPUThreshold        float64
+	MemoryThreshold     float64
+	SlowSubThreshold    float64
+	BufferFillThreshold float64
+	ShedMemory          float64
+
+	// Every CheckInterval the admitted rate is multiplied by DecreaseFactor
+	// while congested, never below MinRate, and otherwise raised by
+	// IncreaseRatio of the rate at which congestion began until it is lifted.
+	CheckInterval  time.Duration
+	MinRate        float64
+	DecreaseFactor float64
+	IncreaseRatio  float64
 }
 
 func DefaultConfig() Config {
 	return Config{
-		CPUThreshold:       0.80,
-		MemoryThreshold:    0.80,
-		SlowSubThreshold:   0.50,
-		ThrottleDuration:   5 * time.Second,
-		CheckInterval:      1 * time.Second,
-		MinPublishInterval: 10 * time.Millisecond,
+		CPUThreshold:        0.80,
+		MemoryThreshold:     0.80,
+		SlowSubThreshold:    0.50,
+		BufferFillThreshold: 0.75,
+		ShedMemory:          0.95,
+		CheckInterval:       1 * time.Second,
+		MinRate:             10,
+		DecreaseFactor:      0.5,
+		IncreaseRatio:       0.10,
 	}
 }
 
+// AdaptiveThrottler is an AIMD controller over the global publish rate. While
+// the server is healthy every publish is admitted; once it is congested the
+// controller caps the rate below what was being published and keeps cutting
+// until the pressure clears, then ramps back up additively.
 type AdaptiveThrottler struct {
 	config Config
 
-	isThrottling  atomic.Bool
-	lastCheckTime atomic.Int64
+	limited  atomic.Bool
+	shedding atomic.Bool
+
+	mu         sync.Mutex
+	bucket     admissionBucket
+	ceiling    float64
+	lastAdjust time.Time
 
 	slowSubCount  atomic.Int32
 	totalSubCount atomic.Int32
+	bufferFill    atomic.Uint64
 
-	lastCPUUsage    atomic.Uint64
-	lastMemoryUsage atomic.Uint64
-}
+	intervalAdmitted atomic.Int64
+	observedRate     atomic.Uint64
 
-func NewAdaptiveThrottler(config Config) *AdaptiveThrottler {
-	at := &AdaptiveThrottler{
-		config: config,
-	}
+	admitted      atomic.Int64
+	rejected      atomic.Int64
+	shed          atomic.Int64
+	decreases     atomic.Int64
+	throttleStart atomic.Int64
 
-	at.isThrottling.Store(false)
-	at.lastCheckTime.Store(time.Now().Unix())
-
-	return at
+	sampler  load.LoadSampler
+	stopChan chan struct{}
+	stopOnce sync.Once
 }
 
-func (at *AdaptiveThrottler) ShouldThrottle() bool {
-	if at.isThrottling.Load() {
-		return true
+func NewAdaptiveThrottler(config Config, sampler load.LoadSampler) *AdaptiveThrottler {
+	return &AdaptiveThrottler{
+		config:     config,
+		sampler:    sampler,
+		lastAdjust: time.Now(),
+		stopChan:   make(chan struct{}),
 	}
+}
 
-	now := time.Now()
-	lastCheck := time.Unix(at.lastCheckTime.Load(), 0)
-
-	if now.Sub(lastCheck) < at.config.CheckInterval {
-		return at.isThrottling.Load()
-	}
-
-	at.lastCheckTime.Store(now.Unix())
-
-	cpuUsage := at.getCPUUsage()
-	memoryUsage := at.getMemoryUsage()
+func (at *AdaptiveThrottler) Start() {
+	go at.controlLoop()
+}
 
-	slowSubs := float64(at.slowSubCount.Load())
-	totalSubs := float64(at.totalSubCount.Load())
+func (at *AdaptiveThrottler) Stop() {
+	at.stopOnce.Do(func() {
+		close(at.stopChan)
+	})
+}
 
-	if totalSubs == 0 {
-		return false
+func (at *AdaptiveThrottler) controlLoop() {
+	ticker := time.NewTicker(at.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			at.adjust(now)
+		case <-at.stopChan:
+			return
+		}
 	}
+}
 
-	slowSubPercentage := slowSubs / totalSubs
+// Admit decides whether one publish may proceed. It never blocks: a refused
+// publisher gets a *ThrottledError saying when to try again.
+func (at *AdaptiveThrottler) Admit() error {
+	if at.shedding.Load() {
+		at.shed.Add(1)
+		return &ThrottledError{RetryAfter: at.config.CheckInterval, Shed: true}
+	}
 
-	shouldThrottle := (slowSubPercentage > at.config.SlowSubThreshold && (cpuUsage > at.config.CPUThreshold || memoryUsage > at.config.MemoryThreshold))
+	if at.limited.Load() {
+		at.mu.Lock()
+		wait := at.bucket.take(time.Now())
+		rate := at.bucket.rate
+		at.mu.Unlock()
 
-	if shouldThrottle {
-		at.StartThrottling()
+		if wait > 0 {
+			at.rejected.Add(1)
+			return &ThrottledError{Rate: rate, RetryAfter: wait}
+		}
 	}
 
-	return shouldThrottle
+	at.admitted.Add(1)
+	at.intervalAdmitted.Add(1)
+	return nil
 }
 
-func (at *AdaptiveThrottler) StartThrottling() {
-	at.isThrottling.Store(true)
+// adjust runs once per CheckInterval: measure, decide congestion, then apply
+// a multiplicative decrease or an additive increase to the admitted rate.
+func (at *AdaptiveThrottler) adjust(now time.Time) {
+	sample := at.sampler.Sample()
 
-	go func() {
-		time.Sleep(at.config.ThrottleDuration)
-		at.StopThrottling()
-	}()
-}
+	at.mu.Lock()
+	defer at.mu.Unlock()
 
-func (at *AdaptiveThrottler) StopThrottling() {
-	at.isThrottling.Store(false)
+	elapsed := now.Sub(at.lastAdjust).Seconds()
+	at.lastAdjust = now
+	observed := 0.0
+	if elapsed > 0 {
+		observed = float64(at.intervalAdmitted.Swap(0)) / elapsed
+	}
+	at.observedRate.Store(math.Float64bits(observed))
+
+	at.shedding.Store(sample.Memory >= at.config.ShedMemory)
+
+	congested := at.subscriberPressure() && (sample.CPU > at.config.CPUThreshold || sample.Memory > at.config.MemoryThreshold)
+
+	switch {
+	case congested && !at.limited.Load():
+		at.ceiling = math.Max(observed, at.config.MinRate)
+		at.bucket.reset(math.Max(at.ceiling*at.config.DecreaseFactor, at.config.MinRate), now)
+		at.decreases.Add(1)
+		at.throttleStart.Store(now.Unix())
+		at.limited.Store(true)
+
+	case congested:
+		at.bucket.setRate(math.Max(at.bucket.rate*at.config.DecreaseFactor, at.config.MinRate), now)
+		at.decreases.Add(1)
+
+	case at.limited.Load():
+		rate := at.bucket.rate + at.ceiling*at.config.IncreaseRatio
+		if rate >= at.ceiling {
+			at.limited.Store(false)
+			return
+		}
+		at.bucket.setRate(rate, now)
+	}
 }
 
-func (at *AdaptiveThrottler) ApplyThrottle() {
-	if at.isThrottling.Load() {
-		time.Sleep(at.config.MinPublishInterval)
+func (at *AdaptiveThrottler) subscriberPressure() bool {
+	total := float64(at.totalSubCount.Load())
+	if total == 0 {
+		return false
 	}
+
+	slowRatio := float64(at.slowSubCount.Load()) / total
+	return slowRatio > at.config.SlowSubThreshold || at.getBufferFill() > at.config.BufferFillThreshold
 }
 
-func (at *AdaptiveThrottler) UpdateSubscriber(slowCount, totalCOunt int) {
+// UpdateSubscriber feeds the subscriber health figures the controller acts
+// on; bufferFill is the average fraction of subscriber buffers in use.
+func (at *AdaptiveThrottler) UpdateSubscriber(slowCount, totalCount int, bufferFill float64) {
 	at.slowSubCount.Store(int32(slowCount))
-	at.totalSubCount.Store(int32(totalCOunt))
+	at.totalSubCount.Store(int32(totalCount))
+	at.bufferFill.Store(math.Float64bits(bufferFill))
 }
 
-func (at *AdaptiveThrottler) getCPUUsage() float64 {
-	numGoroutines := runtime.NumGoroutine()
-
-	var usage float64
-	if numGoroutines > 10000 {
-		usage = 0.9
-	} else if numGoroutines > 5000 {
-		usage = 0.7
-	} else if numGoroutines > 1000 {
-		usage = 0.5
-	} else {
-		usage = float64(numGoroutines) / 1000.0
-	}
-
-	at.lastCPUUsage.Store(uint64(usage * 1000000))
-
-	return usage
+func (at *AdaptiveThrottler) getBufferFill() float64 {
+	return math.Float64frombits(at.bufferFill.Load())
 }
 
-func (at *AdaptiveThrottler) getMemoryUsage() float64 {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-
-	maxMemory := uint64(2 * 1024 * 1024 * 1024)
-
-	usage := float64(m.Alloc) / float64(maxMemory)
-
-	if usage > 1.0 {
-		usage = 1.0
+// AdmissionRate is the current cap on publishes per second, or 0 when
+// publishes are not being limited.
+func (at *AdaptiveThrottler) AdmissionRate() float64 {
+	if !at.limited.Load() {
+		return 0
 	}
-
-	at.lastMemoryUsage.Store(uint64(usage * 1000000))
-	return usage
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return at.bucket.rate
 }
 
 func (at *AdaptiveThrottler) GetMetrics() map[string]interface{} {
-	return map[string]interface{}{
-		"is_throttling":     at.isThrottling.Load(),
+	sample := at.sampler.Sample()
+	metrics := map[string]interface{}{
+		"is_throttling":     at.IsThrottling(),
+		"is_shedding":       at.shedding.Load(),
+		"admission_rate":    at.AdmissionRate(),
+		"observed_rate":     math.Float64frombits(at.observedRate.Load()),
+		"admitted":          at.admitted.Load(),
+		"rejected":          at.rejected.Load(),
+		"shed":              at.shed.Load(),
+		"rate_decreases":    at.decreases.Load(),
 		"slow_subscribers":  at.slowSubCount.Load(),
 		"total_subscribers": at.totalSubCount.Load(),
-		"cpu_usage":         float64(at.lastCPUUsage.Load()),
-		"memory_usage":      float64(at.lastMemoryUsage.Load()),
+		"buffer_fill":       at.getBufferFill(),
+		"cpu_usage":         sample.CPU,
+		"memory_usage":      sample.Memory,
 	}
+
+	if at.limited.Load() {
+		metrics["throttling_since"] = time.Unix(at.throttleStart.Load(), 0).Format(time.RFC3339)
+	}
+
+	return metrics
 }
 
 func (at *AdaptiveThrottler) IsThrottling() bool {
-	return at.isThrottling.Load()
+	return at.limited.Load() || at.shedding.Load()
 }
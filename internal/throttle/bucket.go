@@ -0,0 +1,53 @@
+package throttle
+
+import (
+	"math"
+	"time"
+)
+
+// admissionBurst is how much of a second's worth of the admitted rate may be
+// spent at once, so a cut takes effect within a fraction of a second.
+const admissionBurst = 0.1
+
+// admissionBucket is a token bucket whose rate the controller retunes on
+// every adjustment. It is guarded by the throttler's mutex.
+type admissionBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *admissionBucket) reset(rate float64, now time.Time) {
+	b.rate = rate
+	b.burst = math.Max(1, rate*admissionBurst)
+	b.tokens = b.burst
+	b.last = now
+}
+
+// setRate keeps the tokens already earned, capped at the new burst.
+func (b *admissionBucket) setRate(rate float64, now time.Time) {
+	b.refill(now)
+	b.rate = rate
+	b.burst = math.Max(1, rate*admissionBurst)
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+func (b *admissionBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// take spends one token, or returns how long until one is available.
+// Nothing is spent when the caller has to wait.
+func (b *admissionBucket) take(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,145 @@
+package throttle
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AadityaChoubey68/clevr-live/internal/load"
+)
+
+// fakeSampler reports whatever load the test sets.
+type fakeSampler struct {
+	sample load.Sample
+}
+
+func (s *fakeSampler) Sample() load.Sample { return s.sample }
+
+// admitN admits n publishes, failing the test if any is refused.
+func admitN(t *testing.T, at *AdaptiveThrottler, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := at.Admit(); err != nil {
+			t.Fatalf("publish %d refused: %v", i, err)
+		}
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	sampler := &fakeSampler{}
+	at := NewAdaptiveThrottler(DefaultConfig(), sampler)
+	// Adjustments run on a clock ahead of the real one, so Admit never
+	// refills the bucket between them.
+	now := at.lastAdjust
+	tick := func() {
+		now = now.Add(time.Second)
+		at.adjust(now)
+	}
+
+	// Stress alone is not congestion while subscribers keep up.
+	sampler.sample = load.Sample{CPU: 0.9, Memory: 0.5}
+	at.UpdateSubscriber(0, 10, 0.1)
+	admitN(t, at, 1000)
+	tick()
+	if at.IsThrottling() {
+		t.Fatal("throttling without subscriber pressure")
+	}
+
+	// Slow subscribers on a busy CPU halve the rate that was observed.
+	at.UpdateSubscriber(6, 10, 0.1)
+	admitN(t, at, 1000)
+	tick()
+	if rate := at.AdmissionRate(); rate != 500 {
+		t.Fatalf("admission rate %v after congestion began, want 500", rate)
+	}
+
+	// It keeps halving while congested, down to MinRate and no further.
+	for _, want := range []float64{250, 125, 62.5, 31.25, 15.625, 10, 10} {
+		tick()
+		if rate := at.AdmissionRate(); rate != want {
+			t.Fatalf("admission rate %v, want %v", rate, want)
+		}
+	}
+	if n := at.decreases.Load(); n != 8 {
+		t.Fatalf("%d decreases, want 8", n)
+	}
+
+	// At the floor the burst is a single publish.
+	admitN(t, at, 1)
+	err := at.Admit()
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrThrottled) || throttled.Shed {
+		t.Fatalf("second publish at the floor gave %v", err)
+	}
+	if throttled.Rate != 10 || throttled.RetryAfter <= 0 || throttled.RetryAfter > 100*time.Millisecond {
+		t.Fatalf("throttled at %v msg/s, retry after %s", throttled.Rate, throttled.RetryAfter)
+	}
+
+	// Full buffers count as pressure too.
+	at.UpdateSubscriber(0, 10, 0.9)
+	tick()
+	if rate := at.AdmissionRate(); rate != 10 {
+		t.Fatalf("admission rate %v with full buffers, want 10", rate)
+	}
+
+	// Once the pressure clears the rate climbs by a tenth of the rate at
+	// which congestion began, and the limit lifts on reaching it.
+	at.UpdateSubscriber(0, 10, 0.1)
+	for i := 1; i <= 9; i++ {
+		tick()
+		if rate, want := at.AdmissionRate(), 10+100*float64(i); rate != want {
+			t.Fatalf("admission rate %v after %d increases, want %v", rate, i, want)
+		}
+	}
+	tick()
+	if at.IsThrottling() || at.AdmissionRate() != 0 {
+		t.Fatalf("still limited to %v after recovering", at.AdmissionRate())
+	}
+	admitN(t, at, 1000)
+}
+
+func TestCongestionBelowMinRate(t *testing.T) {
+	sampler := &fakeSampler{sample: load.Sample{Memory: 0.9}}
+	at := NewAdaptiveThrottler(DefaultConfig(), sampler)
+	at.UpdateSubscriber(6, 10, 0)
+
+	admitN(t, at, 3)
+	at.adjust(at.lastAdjust.Add(time.Second))
+	if rate := at.AdmissionRate(); rate != 10 {
+		t.Fatalf("admission rate %v, want MinRate", rate)
+	}
+}
+
+func TestShedding(t *testing.T) {
+	sampler := &fakeSampler{sample: load.Sample{Memory: 0.95}}
+	config := DefaultConfig()
+	at := NewAdaptiveThrottler(config, sampler)
+
+	// Shedding needs no subscriber pressure.
+	now := at.lastAdjust.Add(time.Second)
+	at.adjust(now)
+	err := at.Admit()
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || !throttled.Shed || throttled.RetryAfter != config.CheckInterval {
+		t.Fatalf("publish at ShedMemory gave %v", err)
+	}
+	if !at.IsThrottling() || at.shed.Load() != 1 {
+		t.Fatalf("throttling %v, %d shed", at.IsThrottling(), at.shed.Load())
+	}
+
+	sampler.sample.Memory = 0.5
+	at.adjust(now.Add(time.Second))
+	if err := at.Admit(); err != nil {
+		t.Fatalf("publish after memory fell: %v", err)
+	}
+}
+
+func TestRetryAfterRoundsUp(t *testing.T) {
+	err := &ThrottledError{RetryAfter: 1500 * time.Microsecond}
+	if ms := err.RetryAfterMs(); ms != 2 {
+		t.Fatalf("RetryAfterMs %d, want 2", ms)
+	}
+	if s := err.RetryAfterSeconds(); s != 1 {
+		t.Fatalf("RetryAfterSeconds %d, want 1", s)
+	}
+}
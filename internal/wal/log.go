@@ -0,0 +1,627 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FsyncPolicy int
+
+const (
+	FsyncAlways FsyncPolicy = iota
+	FsyncInterval
+	FsyncNever
+)
+
+func ParseFsyncPolicy(value string) (FsyncPolicy, error) {
+	switch strings.ToLower(value) {
+	case "always":
+		return FsyncAlways, nil
+	case "interval", "":
+		return FsyncInterval, nil
+	case "never":
+		return FsyncNever, nil
+	default:
+		return FsyncInterval, fmt.Errorf("unknown fsync policy %q", value)
+	}
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncInterval:
+		return "interval"
+	case FsyncNever:
+		return "never"
+	default:
+		return "unknown"
+	}
+}
+
+type Config struct {
+	Dir           string
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	SegmentBytes  int64
+
+	// Retention bounds each topic's log by size and by age; zero keeps
+	// everything. Whole segments are removed, oldest first, and the active
+	// segment is always kept. Size is checked as segments roll, so a log
+	// may run over by up to one segment.
+	RetentionBytes int64
+	RetentionAge   time.Duration
+}
+
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:           dir,
+		Fsync:         FsyncInterval,
+		FsyncInterval: 1 * time.Second,
+		SegmentBytes:  64 * 1024 * 1024,
+	}
+}
+
+var errStopRead = errors.New("stop read")
+
+// retentionCheckInterval is how often segments are checked for age.
+const retentionCheckInterval = time.Minute
+
+// Log is the on-disk write-ahead log. It holds one TopicLog per tenant/topic,
+// laid out as <dir>/<tenant>/<topic>/<baseOffset>.log.
+type Log struct {
+	config Config
+
+	topics map[string]*TopicLog
+	mu     sync.RWMutex
+
+	// opening holds topic logs being opened outside mu, so concurrent
+	// callers for the same topic wait for the first instead of opening
+	// its files twice.
+	opening map[string]*openingTopic
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// TopicLog is the ordered sequence of segments for a single tenant/topic.
+type TopicLog struct {
+	TenantID string
+	Topic    string
+
+	dir      string
+	config   Config
+	segments []*segment
+	dirty    bool
+	removed  int64
+	mu       sync.Mutex
+}
+
+type openingTopic struct {
+	done chan struct{}
+	log  *TopicLog
+	err  error
+}
+
+func Open(config Config) (*Log, error) {
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = DefaultConfig(config.Dir).SegmentBytes
+	}
+	if config.FsyncInterval <= 0 {
+		config.FsyncInterval = DefaultConfig(config.Dir).FsyncInterval
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create wal dir: %w", err)
+	}
+
+	l := &Log{
+		config:   config,
+		topics:   make(map[string]*TopicLog),
+		opening:  make(map[string]*openingTopic),
+		stopChan: make(chan struct{}),
+	}
+
+	if err := l.load(); err != nil {
+		l.closeTopics()
+		return nil, err
+	}
+
+	if config.Fsync == FsyncInterval {
+		go l.syncLoop()
+	}
+	if config.RetentionAge > 0 {
+		go l.retentionLoop()
+	}
+
+	return l, nil
+}
+
+func (l *Log) load() error {
+	tenantDirs, err := os.ReadDir(l.config.Dir)
+	if err != nil {
+		return fmt.Errorf("read wal dir: %w", err)
+	}
+
+	for _, tenantDir := range tenantDirs {
+		if !tenantDir.IsDir() {
+			continue
+		}
+		tenantID, err := unescapeName(tenantDir.Name())
+		if err != nil {
+			continue
+		}
+
+		topicDirs, err := os.ReadDir(filepath.Join(l.config.Dir, tenantDir.Name()))
+		if err != nil {
+			return fmt.Errorf("read tenant dir %s: %w", tenantDir.Name(), err)
+		}
+
+		for _, topicDir := range topicDirs {
+			if !topicDir.IsDir() {
+				continue
+			}
+			topic, err := unescapeName(topicDir.Name())
+			if err != nil {
+				continue
+			}
+
+			tl, err := openTopicLog(l.config, tenantID, topic)
+			if err != nil {
+				return err
+			}
+			l.topics[makeKey(tenantID, topic)] = tl
+		}
+	}
+
+	return nil
+}
+
+func makeKey(tenantID, topic string) string {
+	return tenantID + ":" + topic
+}
+
+// Topic returns the log for the given tenant/topic, creating it if needed.
+// Its files are opened without holding the log's lock, so a slow disk only
+// holds up callers for the same topic.
+func (l *Log) Topic(tenantID, topic string) (*TopicLog, error) {
+	key := makeKey(tenantID, topic)
+
+	l.mu.RLock()
+	tl, exists := l.topics[key]
+	l.mu.RUnlock()
+
+	if exists {
+		return tl, nil
+	}
+
+	l.mu.Lock()
+	if tl, exists = l.topics[key]; exists {
+		l.mu.Unlock()
+		return tl, nil
+	}
+	if pending, ok := l.opening[key]; ok {
+		l.mu.Unlock()
+		<-pending.done
+		return pending.log, pending.err
+	}
+	pending := &openingTopic{done: make(chan struct{})}
+	l.opening[key] = pending
+	l.mu.Unlock()
+
+	pending.log, pending.err = openTopicLog(l.config, tenantID, topic)
+
+	l.mu.Lock()
+	if pending.err == nil {
+		l.topics[key] = pending.log
+	}
+	delete(l.opening, key)
+	l.mu.Unlock()
+	close(pending.done)
+
+	return pending.log, pending.err
+}
+
+// Topics returns every topic log currently known, including those recovered
+// from disk at Open.
+func (l *Log) Topics() []*TopicLog {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	topics := make([]*TopicLog, 0, len(l.topics))
+	for _, tl := range l.topics {
+		topics = append(topics, tl)
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		if topics[i].TenantID != topics[j].TenantID {
+			return topics[i].TenantID < topics[j].TenantID
+		}
+		return topics[i].Topic < topics[j].Topic
+	})
+	return topics
+}
+
+func (l *Log) syncLoop() {
+	ticker := time.NewTicker(l.config.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, tl := range l.Topics() {
+				if err := tl.Sync(); err != nil {
+					fmt.Printf("WAL: sync failed for %s:%s: %v\n", tl.TenantID, tl.Topic, err)
+				}
+			}
+		case <-l.stopChan:
+			return
+		}
+	}
+}
+
+func (l *Log) retentionLoop() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, tl := range l.Topics() {
+				tl.mu.Lock()
+				tl.enforceRetention(time.Now())
+				tl.mu.Unlock()
+			}
+		case <-l.stopChan:
+			return
+		}
+	}
+}
+
+func (l *Log) closeTopics() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, tl := range l.topics {
+		if err := tl.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (l *Log) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.stopChan)
+		err = l.closeTopics()
+	})
+	return err
+}
+
+func (l *Log) GetMetrics() map[string]interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var totalBytes, removed int64
+	segments := 0
+	for _, tl := range l.topics {
+		tl.mu.Lock()
+		segments += len(tl.segments)
+		for _, seg := range tl.segments {
+			totalBytes += seg.size
+		}
+		removed += tl.removed
+		tl.mu.Unlock()
+	}
+
+	return map[string]interface{}{
+		"dir":              l.config.Dir,
+		"fsync":            l.config.Fsync.String(),
+		"topics":           len(l.topics),
+		"segments":         segments,
+		"bytes":            totalBytes,
+		"retention_bytes":  l.config.RetentionBytes,
+		"retention_age":    l.config.RetentionAge.String(),
+		"segments_removed": removed,
+	}
+}
+
+func openTopicLog(config Config, tenantID, topic string) (*TopicLog, error) {
+	dir := filepath.Join(config.Dir, escapeName(tenantID), escapeName(topic))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create topic log dir: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read topic log dir: %w", err)
+	}
+
+	var bases []uint64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if base, ok := parseSegmentName(entry.Name()); ok {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	tl := &TopicLog{
+		TenantID: tenantID,
+		Topic:    topic,
+		dir:      dir,
+		config:   config,
+	}
+
+	if len(bases) == 0 {
+		seg, err := createSegment(dir, 0)
+		if err != nil {
+			return nil, err
+		}
+		tl.segments = []*segment{seg}
+		return tl, nil
+	}
+
+	for i, base := range bases[:len(bases)-1] {
+		tl.segments = append(tl.segments, sealedSegment(dir, base, bases[i+1]))
+	}
+
+	active, err := openActiveSegment(dir, bases[len(bases)-1])
+	if err != nil {
+		return nil, err
+	}
+	tl.segments = append(tl.segments, active)
+	tl.enforceRetention(time.Now())
+
+	return tl, nil
+}
+
+func (tl *TopicLog) active() *segment {
+	return tl.segments[len(tl.segments)-1]
+}
+
+// Append writes a record to the active segment, rolling to a new segment when
+// the configured size is exceeded or a failed append left the active one
+// torn. It returns the offset assigned to the record.
+func (tl *TopicLog) Append(record []byte) (uint64, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	active := tl.active()
+	if active.file == nil {
+		return 0, fmt.Errorf("topic log %s:%s is closed", tl.TenantID, tl.Topic)
+	}
+
+	if active.torn || active.size > 0 && active.size+int64(headerSize+len(record)) > tl.config.SegmentBytes {
+		if err := tl.roll(); err != nil {
+			return 0, err
+		}
+		active = tl.active()
+	}
+
+	offset := active.nextOffset
+	if err := active.append(record); err != nil {
+		return 0, fmt.Errorf("append to %s: %w", active.path, err)
+	}
+
+	if tl.config.Fsync == FsyncAlways {
+		if err := active.sync(); err != nil {
+			return 0, fmt.Errorf("sync %s: %w", active.path, err)
+		}
+	} else {
+		tl.dirty = true
+	}
+
+	return offset, nil
+}
+
+func (tl *TopicLog) roll() error {
+	old := tl.active()
+	if tl.config.Fsync != FsyncNever {
+		if err := old.sync(); err != nil {
+			return fmt.Errorf("sync %s: %w", old.path, err)
+		}
+	}
+	if err := old.close(); err != nil {
+		return err
+	}
+
+	seg, err := createSegment(tl.dir, old.nextOffset)
+	if err != nil {
+		return err
+	}
+
+	if old.nextOffset == old.baseOffset {
+		// Only a torn segment rolls before its first record, and the new
+		// one shares its name: cut off what the failed append left and
+		// take its place.
+		if err := seg.file.Truncate(0); err != nil {
+			seg.close()
+			return fmt.Errorf("truncate %s: %w", seg.path, err)
+		}
+		tl.segments[len(tl.segments)-1] = seg
+	} else {
+		tl.segments = append(tl.segments, seg)
+	}
+	tl.dirty = false
+	tl.enforceRetention(time.Now())
+	return nil
+}
+
+// enforceRetention removes the oldest sealed segments while the log is over
+// its size limit or they are older than its age limit. The caller holds mu.
+// A read already scanning a removed segment finishes it from the open file.
+func (tl *TopicLog) enforceRetention(now time.Time) {
+	if tl.config.RetentionBytes <= 0 && tl.config.RetentionAge <= 0 {
+		return
+	}
+
+	var total int64
+	for _, seg := range tl.segments {
+		total += seg.size
+	}
+
+	for len(tl.segments) > 1 {
+		oldest := tl.segments[0]
+		overSize := tl.config.RetentionBytes > 0 && total > tl.config.RetentionBytes
+		expired := tl.config.RetentionAge > 0 && now.Sub(oldest.modified) > tl.config.RetentionAge
+		if !overSize && !expired {
+			return
+		}
+
+		if err := os.Remove(oldest.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("WAL: remove %s: %v\n", oldest.path, err)
+			return
+		}
+		total -= oldest.size
+		tl.segments = tl.segments[1:]
+		tl.removed++
+	}
+}
+
+// NextOffset is the offset the next appended record will receive.
+func (tl *TopicLog) NextOffset() uint64 {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return tl.active().nextOffset
+}
+
+// FirstOffset is the oldest offset still held on disk.
+func (tl *TopicLog) FirstOffset() uint64 {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return tl.segments[0].baseOffset
+}
+
+// ReadFrom calls fn for up to max records starting at offset, in order.
+// A max of zero or less reads to the end of the log.
+func (tl *TopicLog) ReadFrom(offset uint64, max int, fn func(offset uint64, record []byte) error) error {
+	tl.mu.Lock()
+	segments := make([]segment, len(tl.segments))
+	for i, seg := range tl.segments {
+		segments[i] = *seg
+	}
+	tl.mu.Unlock()
+
+	// The first segment that still holds records at or after offset; the
+	// active segment is always read, since the copy of it may be behind.
+	first := sort.Search(len(segments)-1, func(i int) bool {
+		return segments[i].nextOffset > offset
+	})
+
+	read := 0
+	for i := first; i < len(segments); i++ {
+		seg := &segments[i]
+
+		end := seg.nextOffset
+		from, position := seg.baseOffset, int64(0)
+		if offset > seg.baseOffset {
+			from, position = seg.seek(offset)
+		}
+		_, _, err := seg.scan(from, position, func(o uint64, _ int64, record []byte) error {
+			if o >= end {
+				return errStopRead
+			}
+			if o < offset {
+				return nil
+			}
+			if max > 0 && read >= max {
+				return errStopRead
+			}
+			read++
+			return fn(o, record)
+		})
+
+		if errors.Is(err, errStopRead) {
+			return nil
+		}
+		if errors.Is(err, errSegmentRemoved) {
+			// Retention removed it since the copy was taken.
+			continue
+		}
+		if err != nil && !errors.Is(err, errCorruptRecord) {
+			return err
+		}
+		if max > 0 && read >= max {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (tl *TopicLog) Sync() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if !tl.dirty {
+		return nil
+	}
+	tl.dirty = false
+	return tl.active().sync()
+}
+
+func (tl *TopicLog) close() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	active := tl.active()
+	if tl.config.Fsync != FsyncNever {
+		active.sync()
+	}
+	return active.close()
+}
+
+// escapeName turns a tenant or topic name into a single safe path element.
+func escapeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		safe := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || (c == '.' && i > 0)
+		if safe {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	if b.Len() == 0 {
+		return "%"
+	}
+	return b.String()
+}
+
+func unescapeName(name string) (string, error) {
+	if name == "%" {
+		return "", nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", fmt.Errorf("invalid escaped name %q", name)
+		}
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escaped name %q", name)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
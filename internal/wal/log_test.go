@@ -0,0 +1,355 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testConfig(t *testing.T) Config {
+	t.Helper()
+	config := DefaultConfig(t.TempDir())
+	config.Fsync = FsyncNever
+	config.SegmentBytes = 256 * 1024
+	return config
+}
+
+func record(i int) []byte {
+	return append([]byte(fmt.Sprintf("record-%06d:", i)), bytes.Repeat([]byte{'x'}, 1000)...)
+}
+
+func appendRecords(t *testing.T, tl *TopicLog, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		offset, err := tl.Append(record(i))
+		if err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+		if offset != uint64(i) {
+			t.Fatalf("append %d got offset %d", i, offset)
+		}
+	}
+}
+
+func readAll(t *testing.T, tl *TopicLog, from uint64, max int) []uint64 {
+	t.Helper()
+	var offsets []uint64
+	err := tl.ReadFrom(from, max, func(offset uint64, data []byte) error {
+		if !bytes.Equal(data, record(int(offset))) {
+			t.Fatalf("offset %d holds the wrong record", offset)
+		}
+		offsets = append(offsets, offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read from %d: %v", from, err)
+	}
+	return offsets
+}
+
+func expectRange(t *testing.T, got []uint64, from, to uint64) {
+	t.Helper()
+	if uint64(len(got)) != to-from {
+		t.Fatalf("read %d records, want %d (%d to %d)", len(got), to-from, from, to)
+	}
+	for i, offset := range got {
+		if offset != from+uint64(i) {
+			t.Fatalf("record %d has offset %d, want %d", i, offset, from+uint64(i))
+		}
+	}
+}
+
+func TestReadFromAcrossSegments(t *testing.T) {
+	l, err := Open(testConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tl, err := l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, tl, 1000)
+
+	tl.mu.Lock()
+	segments := len(tl.segments)
+	tl.mu.Unlock()
+	if segments < 3 {
+		t.Fatalf("expected the log to roll, got %d segments", segments)
+	}
+
+	tests := []struct {
+		from uint64
+		max  int
+		want [2]uint64
+	}{
+		{from: 0, max: 0, want: [2]uint64{0, 1000}},
+		{from: 1, max: 5, want: [2]uint64{1, 6}},
+		{from: 437, max: 0, want: [2]uint64{437, 1000}},
+		{from: 999, max: 10, want: [2]uint64{999, 1000}},
+		{from: 1000, max: 0, want: [2]uint64{1000, 1000}},
+	}
+	for _, tt := range tests {
+		expectRange(t, readAll(t, tl, tt.from, tt.max), tt.want[0], tt.want[1])
+	}
+}
+
+func TestReopenIndexesSealedSegmentsAndTruncatesTornTail(t *testing.T) {
+	config := testConfig(t)
+
+	l, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, tl, 600)
+	active := tl.active().path
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash mid-write leaves half a record behind.
+	f, err := os.OpenFile(active, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 9, 9})
+	f.Close()
+
+	l, err = Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tl, err = l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := tl.NextOffset(); next != 600 {
+		t.Fatalf("next offset after reopen is %d, want 600", next)
+	}
+	expectRange(t, readAll(t, tl, 123, 0), 123, 600)
+
+	if offset, err := tl.Append(record(600)); err != nil || offset != 600 {
+		t.Fatalf("append after reopen: offset %d, %v", offset, err)
+	}
+	expectRange(t, readAll(t, tl, 598, 0), 598, 601)
+}
+
+// tearActive makes the next append to the active segment fail after half a
+// record has reached the file, and fail to cut it off again.
+func tearActive(t *testing.T, tl *TopicLog) {
+	t.Helper()
+	active := tl.active()
+	f, err := os.OpenFile(active.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 9, 9})
+	f.Close()
+
+	readOnly, err := os.Open(active.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active.file.Close()
+	active.file = readOnly
+}
+
+func TestFailedAppendRollsPastATornSegment(t *testing.T) {
+	config := testConfig(t)
+	l, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, tl, 100)
+
+	tearActive(t, tl)
+	torn := tl.active()
+	size, entries := torn.size, len(torn.index.entries)
+	if _, err := tl.Append(record(100)); err == nil {
+		t.Fatal("append to a read-only segment succeeded")
+	}
+	if !torn.torn || torn.size != size || torn.nextOffset != 100 || len(torn.index.entries) != entries {
+		t.Fatalf("failed append moved the segment on: torn %v, size %d, next %d, %d index entries",
+			torn.torn, torn.size, torn.nextOffset, len(torn.index.entries))
+	}
+
+	// The next append starts a segment of its own rather than writing
+	// behind the torn record.
+	for i := 100; i < 300; i++ {
+		if offset, err := tl.Append(record(i)); err != nil || offset != uint64(i) {
+			t.Fatalf("append %d: offset %d, %v", i, offset, err)
+		}
+	}
+	if tl.segments[1].baseOffset != 100 {
+		t.Fatalf("second segment starts at %d, want 100", tl.segments[1].baseOffset)
+	}
+	expectRange(t, readAll(t, tl, 0, 0), 0, 300)
+	expectRange(t, readAll(t, tl, 99, 0), 99, 300)
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tl, err = l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRange(t, readAll(t, tl, 50, 0), 50, 300)
+}
+
+func TestFailedFirstAppendReplacesTheSegment(t *testing.T) {
+	l, err := Open(testConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tl, err := l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tearActive(t, tl)
+	if _, err := tl.Append(record(0)); err == nil {
+		t.Fatal("append to a read-only segment succeeded")
+	}
+
+	// The torn segment held no whole record, so its replacement has the
+	// same base offset and file.
+	appendRecords(t, tl, 10)
+	if len(tl.segments) != 1 {
+		t.Fatalf("%d segments, want 1", len(tl.segments))
+	}
+	expectRange(t, readAll(t, tl, 0, 0), 0, 10)
+}
+
+func TestRetentionBySize(t *testing.T) {
+	config := testConfig(t)
+	config.RetentionBytes = 600 * 1024
+
+	l, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tl, err := l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, tl, 2000)
+
+	first := tl.FirstOffset()
+	if first == 0 {
+		t.Fatal("retention removed nothing")
+	}
+
+	tl.mu.Lock()
+	var total int64
+	for _, seg := range tl.segments {
+		total += seg.size
+	}
+	tl.mu.Unlock()
+	if total > config.RetentionBytes+config.SegmentBytes {
+		t.Fatalf("log holds %d bytes, over its %d byte retention by more than a segment", total, config.RetentionBytes)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(tl.dir, "*"+segmentExt))
+	tl.mu.Lock()
+	segments := len(tl.segments)
+	tl.mu.Unlock()
+	if len(files) != segments {
+		t.Fatalf("%d segment files on disk for %d segments", len(files), segments)
+	}
+
+	// Reading from before the retained range starts at what is left.
+	expectRange(t, readAll(t, tl, 0, 0), first, 2000)
+}
+
+func TestRetentionByAgeKeepsActiveSegment(t *testing.T) {
+	config := testConfig(t)
+	config.RetentionAge = time.Hour
+
+	l, err := Open(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tl, err := l.Topic("tenant", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, tl, 1000)
+
+	tl.mu.Lock()
+	tl.enforceRetention(time.Now().Add(2 * time.Hour))
+	segments := len(tl.segments)
+	tl.mu.Unlock()
+
+	if segments != 1 {
+		t.Fatalf("%d segments left, want only the active one", segments)
+	}
+	first := tl.FirstOffset()
+	expectRange(t, readAll(t, tl, 0, 0), first, 1000)
+}
+
+func TestConcurrentTopicOpenSharesOneLog(t *testing.T) {
+	l, err := Open(testConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	logs := make([]*TopicLog, 16)
+	var wg sync.WaitGroup
+	for i := range logs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tl, err := l.Topic("tenant", "orders")
+			if err != nil {
+				t.Error(err)
+			}
+			logs[i] = tl
+		}(i)
+	}
+	wg.Wait()
+
+	for _, tl := range logs[1:] {
+		if tl != logs[0] {
+			t.Fatal("concurrent opens returned different logs for one topic")
+		}
+	}
+}
+
+func TestEscapeNameRoundTrip(t *testing.T) {
+	for _, name := range []string{"", "orders", "orders.eu", ".hidden", "a/b", "ten ant", "%", "ünï"} {
+		escaped := escapeName(name)
+		if filepath.Base(escaped) != escaped {
+			t.Fatalf("escaped %q to %q, which is not one path element", name, escaped)
+		}
+		got, err := unescapeName(escaped)
+		if err != nil || got != name {
+			t.Fatalf("round trip of %q gave %q, %v", name, got, err)
+		}
+	}
+}
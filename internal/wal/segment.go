@@ -0,0 +1,313 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt   = ".log"
+	headerSize   = 8
+	maxRecordLen = 64 * 1024 * 1024
+
+	// indexInterval is how many bytes of records lie between two entries of
+	// a segment's offset index, so a read seeks to within that of its start.
+	indexInterval = 64 * 1024
+)
+
+var (
+	errCorruptRecord  = errors.New("corrupt record")
+	errSegmentRemoved = errors.New("segment removed")
+)
+
+// segment is a single append-only file holding records [baseOffset, nextOffset).
+// Each record is framed as a big-endian uint32 length, a CRC32 of the payload
+// and the payload itself.
+type segment struct {
+	baseOffset uint64
+	nextOffset uint64
+	path       string
+	size       int64
+	file       *os.File
+
+	// modified is when a record was last appended, which retention ages
+	// the segment by.
+	modified time.Time
+
+	// index is shared by every copy of the segment taken for a read.
+	index *offsetIndex
+
+	// torn is set when a failed append could not be cut back off the
+	// file. Records written after it would sit behind the partial one
+	// where no scan reaches them, so the log rolls to a new segment.
+	torn bool
+}
+
+// indexEntry places a record in its segment file.
+type indexEntry struct {
+	offset   uint64
+	position int64
+}
+
+// offsetIndex is a sparse in-memory index of a segment: the file position of
+// a record at least every indexInterval bytes. The active segment's index
+// grows as records are appended; a sealed segment recovered from disk is
+// indexed by one scan, on its first read.
+type offsetIndex struct {
+	mu      sync.RWMutex
+	entries []indexEntry
+	built   bool
+	build   sync.Once
+}
+
+func newOffsetIndex(built bool) *offsetIndex {
+	return &offsetIndex{built: built}
+}
+
+// add records where offset starts, if it is far enough past the last entry.
+func (x *offsetIndex) add(offset uint64, position int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if n := len(x.entries); n > 0 && position-x.entries[n-1].position < indexInterval {
+		return
+	}
+	x.entries = append(x.entries, indexEntry{offset: offset, position: position})
+}
+
+// lookup returns the closest indexed record at or before offset, or the
+// start of the segment.
+func (x *offsetIndex) lookup(base, offset uint64) (uint64, int64) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	i := sort.Search(len(x.entries), func(i int) bool { return x.entries[i].offset > offset })
+	if i == 0 {
+		return base, 0
+	}
+	return x.entries[i-1].offset, x.entries[i-1].position
+}
+
+func segmentName(baseOffset uint64) string {
+	return fmt.Sprintf("%020d%s", baseOffset, segmentExt)
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return base, true
+}
+
+func createSegment(dir string, baseOffset uint64) (*segment, error) {
+	path := filepath.Join(dir, segmentName(baseOffset))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create segment %s: %w", path, err)
+	}
+
+	return &segment{
+		baseOffset: baseOffset,
+		nextOffset: baseOffset,
+		path:       path,
+		file:       file,
+		modified:   time.Now(),
+		index:      newOffsetIndex(true),
+	}, nil
+}
+
+// sealedSegment describes a segment recovered from disk that is no longer
+// appended to; it is indexed on first read.
+func sealedSegment(dir string, baseOffset, nextOffset uint64) *segment {
+	seg := &segment{
+		baseOffset: baseOffset,
+		nextOffset: nextOffset,
+		path:       filepath.Join(dir, segmentName(baseOffset)),
+		index:      newOffsetIndex(false),
+	}
+	if info, err := os.Stat(seg.path); err == nil {
+		seg.size = info.Size()
+		seg.modified = info.ModTime()
+	}
+	return seg
+}
+
+// openActiveSegment opens an existing segment for appending, scanning it to
+// find the next offset and truncating any torn or corrupt tail left behind by
+// a crash.
+func openActiveSegment(dir string, baseOffset uint64) (*segment, error) {
+	path := filepath.Join(dir, segmentName(baseOffset))
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open segment %s: %w", path, err)
+	}
+
+	seg := &segment{
+		baseOffset: baseOffset,
+		nextOffset: baseOffset,
+		path:       path,
+		file:       file,
+		index:      newOffsetIndex(true),
+	}
+
+	validSize, next, err := seg.scan(baseOffset, 0, func(offset uint64, position int64, _ []byte) error {
+		seg.index.add(offset, position)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errCorruptRecord) {
+		file.Close()
+		return nil, err
+	}
+	seg.nextOffset = next
+
+	info, statErr := file.Stat()
+	if statErr != nil {
+		file.Close()
+		return nil, statErr
+	}
+
+	if info.Size() != validSize {
+		fmt.Printf("WAL: truncating %s from %d to %d bytes\n", path, info.Size(), validSize)
+		if err := file.Truncate(validSize); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("truncate segment %s: %w", path, err)
+		}
+	}
+
+	seg.size = validSize
+	seg.modified = info.ModTime()
+	return seg, nil
+}
+
+// scan walks the valid records from the one with the given offset, which
+// starts at position, to the end of the segment. It returns the position
+// and offset just past the last record it read.
+func (s *segment) scan(offset uint64, position int64, fn func(offset uint64, position int64, record []byte) error) (int64, uint64, error) {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return position, offset, errSegmentRemoved
+	}
+	if err != nil {
+		return position, offset, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		return position, offset, err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		record, n, err := readRecord(reader)
+		if err == io.EOF {
+			return position, offset, nil
+		}
+		if err != nil {
+			return position, offset, err
+		}
+
+		if err := fn(offset, position, record); err != nil {
+			return position, offset, err
+		}
+
+		offset++
+		position += n
+	}
+}
+
+// seek returns where to start scanning for offset, indexing the segment
+// first if it has not been read since it was recovered.
+func (s *segment) seek(offset uint64) (uint64, int64) {
+	s.index.build.Do(func() {
+		if s.index.built {
+			return
+		}
+		s.scan(s.baseOffset, 0, func(o uint64, position int64, _ []byte) error {
+			s.index.add(o, position)
+			return nil
+		})
+	})
+	return s.index.lookup(s.baseOffset, offset)
+}
+
+// append writes one record. A failed write may have left part of the record
+// behind, so the file is cut back to the last whole record.
+func (s *segment) append(record []byte) error {
+	buf := make([]byte, headerSize+len(record))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(record))
+	copy(buf[headerSize:], record)
+
+	if _, err := s.file.Write(buf); err != nil {
+		if truncateErr := s.file.Truncate(s.size); truncateErr != nil {
+			s.torn = true
+			return fmt.Errorf("%w; truncate to %d bytes: %v", err, s.size, truncateErr)
+		}
+		return err
+	}
+
+	s.index.add(s.nextOffset, s.size)
+	s.size += int64(len(buf))
+	s.nextOffset++
+	s.modified = time.Now()
+	return nil
+}
+
+func (s *segment) sync() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+func (s *segment) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func readRecord(reader *bufio.Reader) ([]byte, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errCorruptRecord
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	if length > maxRecordLen {
+		return nil, 0, errCorruptRecord
+	}
+
+	record := make([]byte, length)
+	if _, err := io.ReadFull(reader, record); err != nil {
+		return nil, 0, errCorruptRecord
+	}
+
+	if crc32.ChecksumIEEE(record) != checksum {
+		return nil, 0, errCorruptRecord
+	}
+
+	return record, int64(headerSize) + int64(length), nil
+}